@@ -0,0 +1,219 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Kinds of boilerplate removed from pages
+const (
+	BoilerplateHeader    = "header"
+	BoilerplateFooter    = "footer"
+	BoilerplateWatermark = "watermark"
+)
+
+// Boilerplate detection thresholds, in PDF points unless noted
+const (
+	// Share of the page height at the top and at the bottom where repeated
+	// lines are headers and footers
+	boilerplateMarginShare = 0.15
+	// Repeated lines whose tops differ by less than this are at the same position
+	boilerplatePositionTolerance = 6.0
+	// A line is boilerplate when it repeats at the same position on this
+	// share of the pages with text, and on at least boilerplateMinPages
+	boilerplateMinShare = 0.5
+	boilerplateMinPages = 3
+	// Repeated lines this many times the body font size are watermarks
+	// wherever they are on the page
+	watermarkFontScale = 2.0
+)
+
+// Lines that are watermarks on their own, on any page
+var watermarkLineRe = regexp.MustCompile(`(?i)^(draft|confidential|copy|sample|specimen|duplicate|cancelled|void|not for sale|for reference only|uncontrolled copy|do not copy|(downloaded|printed) (from|on|by)\b.*)$`)
+
+// BoilerplateLine is a line removed from a page as a header, footer or watermark
+type BoilerplateLine struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+}
+
+// BoilerplateEntry is removed text and the pages it was removed from, for
+// auditing the removal. Lines differing only in their digits, such as page
+// numbers, share an entry with the text of the first.
+type BoilerplateEntry struct {
+	Text  string `json:"text"`
+	Kind  string `json:"kind"`
+	Pages []int  `json:"pages"`
+}
+
+// boilerplateKey normalises a line for comparison across pages: case and
+// spacing are ignored and digits match any digit, so "Page 3 of 40" repeats
+func boilerplateKey(text string) string {
+	var key strings.Builder
+	for _, word := range strings.Fields(text) {
+		if key.Len() > 0 {
+			key.WriteByte(' ')
+		}
+		for _, r := range word {
+			if unicode.IsDigit(r) {
+				r = '#'
+			}
+			key.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return key.String()
+}
+
+// detectBoilerplate finds the lines of each page that are headers, footers
+// or watermarks, by the index of the line in the page's layout. heights are
+// the page heights; a page of unknown height has no headers or footers.
+func detectBoilerplate(layouts [][]TextLine, heights []float64) []map[int]string {
+	found := make([]map[int]string, len(layouts))
+	mark := func(page, line int, kind string) {
+		if found[page] == nil {
+			found[page] = make(map[int]string)
+		}
+		found[page][line] = kind
+	}
+
+	type occurrence struct {
+		page, line int
+		top        float64
+	}
+	byKey := make(map[string][]occurrence)
+	textPages := 0
+	for page, lines := range layouts {
+		if len(lines) > 0 {
+			textPages++
+		}
+		for i, line := range lines {
+			if watermarkLineRe.MatchString(strings.TrimSpace(line.Text)) {
+				mark(page, i, BoilerplateWatermark)
+				continue
+			}
+			if key := boilerplateKey(line.Text); key != "" {
+				byKey[key] = append(byKey[key], occurrence{page: page, line: i, top: line.BBox.Y0})
+			}
+		}
+	}
+
+	minPages := int(math.Ceil(boilerplateMinShare * float64(textPages)))
+	if minPages < boilerplateMinPages {
+		minPages = boilerplateMinPages
+	}
+	bodySizes := make([]float64, len(layouts))
+	for page, lines := range layouts {
+		bodySizes[page] = bodyFontSize(lines)
+	}
+
+	for _, occurrences := range byKey {
+		if len(occurrences) < minPages {
+			continue
+		}
+		// The largest group of occurrences at the same position
+		sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].top < occurrences[j].top })
+		best, bestPages := []occurrence(nil), 0
+		for start := range occurrences {
+			end := start
+			pages := make(map[int]bool)
+			for end < len(occurrences) && occurrences[end].top-occurrences[start].top <= boilerplatePositionTolerance {
+				pages[occurrences[end].page] = true
+				end++
+			}
+			if len(pages) > bestPages {
+				best, bestPages = occurrences[start:end], len(pages)
+			}
+		}
+		if bestPages < minPages {
+			continue
+		}
+
+		for _, occ := range best {
+			line := layouts[occ.page][occ.line]
+			height := heights[occ.page]
+			switch {
+			case height > 0 && line.BBox.Y0 < boilerplateMarginShare*height:
+				mark(occ.page, occ.line, BoilerplateHeader)
+			case height > 0 && line.BBox.Y1 > (1-boilerplateMarginShare)*height:
+				mark(occ.page, occ.line, BoilerplateFooter)
+			case bodySizes[occ.page] > 0 && line.FontSize >= watermarkFontScale*bodySizes[occ.page]:
+				mark(occ.page, occ.line, BoilerplateWatermark)
+			}
+		}
+	}
+	return found
+}
+
+// stripBoilerplate removes headers, footers and watermarks from the text and
+// layout of pages with a text layer, recording what was removed on each page
+func stripBoilerplate(pages []PageText, layouts [][]TextLine, heights []float64) {
+	for page, found := range detectBoilerplate(layouts, heights) {
+		if len(found) == 0 {
+			continue
+		}
+		kept := make([]TextLine, 0, len(layouts[page])-len(found))
+		var removed []string
+		for i, line := range layouts[page] {
+			kind, ok := found[i]
+			if !ok {
+				kept = append(kept, line)
+				continue
+			}
+			removed = append(removed, line.Text)
+			pages[page].Boilerplate = append(pages[page].Boilerplate, BoilerplateLine{Text: line.Text, Kind: kind})
+		}
+		layouts[page] = kept
+		pages[page].Text = removeTextLines(pages[page].Text, removed)
+	}
+}
+
+// removeTextLines drops one line of text for each of lines, comparing them
+// with spacing ignored
+func removeTextLines(text string, lines []string) string {
+	pending := make(map[string]int, len(lines))
+	for _, line := range lines {
+		pending[strings.Join(strings.Fields(line), " ")]++
+	}
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		key := strings.Join(strings.Fields(line), " ")
+		if pending[key] > 0 {
+			pending[key]--
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// BoilerplateMetadata lists the text removed from pages for document metadata
+func BoilerplateMetadata(pages []PageText) map[string]interface{} {
+	var entries []*BoilerplateEntry
+	byKey := make(map[BoilerplateLine]*BoilerplateEntry)
+	removed := 0
+	for _, page := range pages {
+		for _, line := range page.Boilerplate {
+			removed++
+			key := BoilerplateLine{Text: boilerplateKey(line.Text), Kind: line.Kind}
+			entry, ok := byKey[key]
+			if !ok {
+				entry = &BoilerplateEntry{Text: line.Text, Kind: line.Kind}
+				byKey[key] = entry
+				entries = append(entries, entry)
+			}
+			if n := len(entry.Pages); n == 0 || entry.Pages[n-1] != page.Number {
+				entry.Pages = append(entry.Pages, page.Number)
+			}
+		}
+	}
+	if removed == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"boilerplate":               entries,
+		"boilerplate_lines_removed": removed,
+	}
+}
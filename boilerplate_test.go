@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Height of the test pages in points
+const testPageHeight = 800
+
+// tenderPage is a page with a running header, two lines of body text, a
+// signature line in the body and a page number footer, in that order
+func tenderPage(page, pages int, extra ...TextLine) []TextLine {
+	lines := []TextLine{
+		styledLine(page, "Tender No. NHAI/2025/01", 30, 9, false),
+		styledLine(page, fmt.Sprintf("Clause %d.1 of the conditions of contract applies.", page), 200, 10, false),
+		styledLine(page, fmt.Sprintf("Clause %d.2 sets out the payment terms in full.", page), 215, 10, false),
+		styledLine(page, "Signature of Bidder", 600, 10, false),
+		styledLine(page, fmt.Sprintf("Page %d of %d", page, pages), 770, 9, false),
+	}
+	return append(lines, extra...)
+}
+
+// pageHeights gives n pages the same height
+func pageHeights(n int, height float64) []float64 {
+	heights := make([]float64, n)
+	for i := range heights {
+		heights[i] = height
+	}
+	return heights
+}
+
+func TestDetectBoilerplate(t *testing.T) {
+	headerFooter := map[int]string{0: BoilerplateHeader, 4: BoilerplateFooter}
+	restricted := func(page int) TextLine { return styledLine(page, "RESTRICTED", 400, 40, false) }
+
+	tests := []struct {
+		name    string
+		layouts [][]TextLine
+		heights []float64
+		want    []map[int]string
+	}{
+		{
+			name:    "headers and footers",
+			layouts: [][]TextLine{tenderPage(1, 4), tenderPage(2, 4), tenderPage(3, 4), tenderPage(4, 4)},
+			heights: pageHeights(4, testPageHeight),
+			want:    []map[int]string{headerFooter, headerFooter, headerFooter, headerFooter},
+		},
+		{
+			name: "watermarks",
+			layouts: [][]TextLine{
+				tenderPage(1, 3, restricted(1), styledLine(1, "Downloaded from eprocure.gov.in on 01/04/2025", 500, 10, false)),
+				tenderPage(2, 3, restricted(2)),
+				tenderPage(3, 3, restricted(3)),
+			},
+			heights: pageHeights(3, testPageHeight),
+			want: []map[int]string{
+				{0: BoilerplateHeader, 4: BoilerplateFooter, 5: BoilerplateWatermark, 6: BoilerplateWatermark},
+				{0: BoilerplateHeader, 4: BoilerplateFooter, 5: BoilerplateWatermark},
+				{0: BoilerplateHeader, 4: BoilerplateFooter, 5: BoilerplateWatermark},
+			},
+		},
+		{
+			name:    "too few pages",
+			layouts: [][]TextLine{tenderPage(1, 2), tenderPage(2, 2, styledLine(2, "DRAFT", 400, 10, false))},
+			heights: pageHeights(2, testPageHeight),
+			want:    []map[int]string{nil, {5: BoilerplateWatermark}},
+		},
+		{
+			name:    "unknown page height",
+			layouts: [][]TextLine{tenderPage(1, 3), tenderPage(2, 3), tenderPage(3, 3)},
+			heights: pageHeights(3, 0),
+			want:    []map[int]string{nil, nil, nil},
+		},
+		{
+			name: "repeated on under half the pages",
+			layouts: [][]TextLine{
+				tenderPage(1, 7), tenderPage(2, 7), tenderPage(3, 7),
+				{styledLine(4, "Annexure A", 300, 10, false)},
+				{styledLine(5, "Annexure B", 300, 10, false)},
+				{styledLine(6, "Annexure C", 300, 10, false)},
+				{styledLine(7, "Annexure D", 300, 10, false)},
+			},
+			heights: pageHeights(7, testPageHeight),
+			want:    make([]map[int]string, 7),
+		},
+	}
+	for _, tt := range tests {
+		if got := detectBoilerplate(tt.layouts, tt.heights); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: boilerplate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripBoilerplate(t *testing.T) {
+	layouts := [][]TextLine{tenderPage(1, 3), tenderPage(2, 3), tenderPage(3, 3)}
+	pages := make([]PageText, len(layouts))
+	for i, lines := range layouts {
+		texts := make([]string, len(lines))
+		for j, line := range lines {
+			texts[j] = line.Text
+		}
+		pages[i] = PageText{Number: i + 1, Text: strings.Join(texts, "\n")}
+	}
+
+	stripBoilerplate(pages, layouts, pageHeights(3, testPageHeight))
+
+	want := "Clause 2.1 of the conditions of contract applies.\nClause 2.2 sets out the payment terms in full.\nSignature of Bidder"
+	if pages[1].Text != want {
+		t.Errorf("text = %q, want %q", pages[1].Text, want)
+	}
+	if len(layouts[1]) != 3 {
+		t.Errorf("layout has %d lines, want 3", len(layouts[1]))
+	}
+	wantRemoved := []BoilerplateLine{
+		{Text: "Tender No. NHAI/2025/01", Kind: BoilerplateHeader},
+		{Text: "Page 2 of 3", Kind: BoilerplateFooter},
+	}
+	if !reflect.DeepEqual(pages[1].Boilerplate, wantRemoved) {
+		t.Errorf("removed = %+v, want %+v", pages[1].Boilerplate, wantRemoved)
+	}
+
+	metadata := BoilerplateMetadata(pages)
+	if metadata["boilerplate_lines_removed"] != 6 {
+		t.Errorf("lines removed = %v, want 6", metadata["boilerplate_lines_removed"])
+	}
+	wantEntries := []*BoilerplateEntry{
+		{Text: "Tender No. NHAI/2025/01", Kind: BoilerplateHeader, Pages: []int{1, 2, 3}},
+		{Text: "Page 1 of 3", Kind: BoilerplateFooter, Pages: []int{1, 2, 3}},
+	}
+	if entries := metadata["boilerplate"]; !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("entries = %+v, want %+v", entries, wantEntries)
+	}
+}
+
+func TestBoilerplateKey(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Page 3 of 40", "page # of ##"},
+		{"  PAGE 12   of 40 ", "page ## of ##"},
+		{"Tender No. NHAI/2025/01", "tender no. nhai/####/##"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := boilerplateKey(tt.text); got != tt.want {
+			t.Errorf("boilerplateKey(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveTextLines(t *testing.T) {
+	tests := []struct {
+		text  string
+		lines []string
+		want  string
+	}{
+		{"Header\nBody\nFooter", []string{"Header", "Footer"}, "Body"},
+		{"Page  1 of 3\nBody", []string{"Page 1 of 3"}, "Body"},
+		{"Note\nBody\nNote", []string{"Note"}, "Body\nNote"},
+		{"Body", []string{"Missing"}, "Body"},
+	}
+	for _, tt := range tests {
+		if got := removeTextLines(tt.text, tt.lines); got != tt.want {
+			t.Errorf("removeTextLines(%q, %q) = %q, want %q", tt.text, tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// Default number of follow-up calls for a response cut off at the output token limit
+const defaultMaxContinuations = 2
+
+// Shortest overlap trimmed when a continuation repeats the tail of the
+// partial output; shorter matches are likely coincidental
+const (
+	minContinuationOverlap = 16
+	maxContinuationOverlap = 512
+)
+
+// maxContinuations reads TENDERIQ_MAX_CONTINUATIONS, falling back to the default
+func maxContinuations() int {
+	return getEnvInt("TENDERIQ_MAX_CONTINUATIONS", defaultMaxContinuations)
+}
+
+// generateWithContinuation sends req and, while the response stops on the
+// output token limit, asks the model to carry on from where it stopped, up
+// to maxContinuations times. The returned response holds the stitched text
+// and the finish reason of the last call; the int is the number of
+// continuations made.
+func generateWithContinuation(ctx context.Context, llm LLMClient, req LLMRequest) (*LLMResponse, int, error) {
+	resp, err := llm.Generate(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.FinishReason != FinishReasonMaxTokens {
+		return resp, 0, nil
+	}
+
+	combined := *resp
+	maxCalls := maxContinuations()
+	continuations := 0
+	for combined.FinishReason == FinishReasonMaxTokens && continuations < maxCalls {
+		continuations++
+		log.Printf("Response from %s hit the output token limit after %d chars, continuation %d/%d", req.Model, len(combined.Text), continuations, maxCalls)
+
+		prompt, err := promptRegistry().Get(PromptContinuation).Render(map[string]string{})
+		if err != nil {
+			return nil, continuations, err
+		}
+
+		// The schema preamble stays on the original turn; the follow-up
+		// itself is free text that is stitched onto the partial output
+		contReq := req
+		contReq.ResponseSchema = nil
+		contReq.History = append(append([]LLMMessage{}, req.History...),
+			LLMMessage{Role: MessageRoleUser, Text: continuationTurn(req)},
+			LLMMessage{Role: MessageRoleAssistant, Text: combined.Text},
+		)
+		contReq.Prompt = prompt
+
+		next, err := llm.Generate(WithUsageMode(ctx, UsageModeContinuation), contReq)
+		if err != nil {
+			// Keep the partial output; callers report it as truncated
+			log.Printf("Continuation %d for %s failed (%s): %v", continuations, req.Model, ClassifyLLMError(err), err)
+			break
+		}
+
+		combined.Text = stitchContinuation(combined.Text, next.Text)
+		combined.FinishReason = next.FinishReason
+		combined.Usage.PromptTokens += next.Usage.PromptTokens
+		combined.Usage.OutputTokens += next.Usage.OutputTokens
+		combined.Usage.Latency += next.Usage.Latency
+		combined.Usage.Estimated = combined.Usage.Estimated || next.Usage.Estimated
+		combined.Cached = combined.Cached && next.Cached
+	}
+
+	return &combined, continuations, nil
+}
+
+// continuationTurn is the original user turn as the model saw it, including
+// the schema constraint backends add for structured requests
+func continuationTurn(req LLMRequest) string {
+	if req.ResponseSchema == nil {
+		return req.Prompt
+	}
+	return schemaInstruction(req.ResponseSchema) + "\n\n" + req.Prompt
+}
+
+// stitchContinuation appends a continuation to the partial output. Models
+// sometimes wrap the continuation in a code fence or repeat the last few
+// lines before carrying on, so both are removed first.
+func stitchContinuation(partial, next string) string {
+	trimmed := strings.TrimSpace(next)
+	if strings.HasPrefix(trimmed, "```") {
+		// Drop the opening fence line, e.g. ```json
+		if newline := strings.IndexByte(trimmed, '\n'); newline >= 0 {
+			next = trimmed[newline+1:]
+		} else {
+			next = ""
+		}
+		next = strings.TrimSuffix(strings.TrimRight(next, " \n"), "```")
+	}
+
+	limit := maxContinuationOverlap
+	if len(partial) < limit {
+		limit = len(partial)
+	}
+	if len(next) < limit {
+		limit = len(next)
+	}
+	for n := limit; n >= minContinuationOverlap; n-- {
+		if strings.HasSuffix(partial, next[:n]) {
+			return partial + next[n:]
+		}
+	}
+
+	return partial + next
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Cross-check outcomes for a critical field
+const (
+	CrossCheckAgreed       = "agreed"
+	CrossCheckDisagreed    = "disagreed"
+	CrossCheckSingleSource = "single_source"
+	CrossCheckNotFound     = "not_found"
+)
+
+// Critical tender fields compared by the cross-check
+const (
+	CriticalContractValue = "contract_value"
+	CriticalEMD           = "emd"
+	CriticalBidSubmission = "bid_submission"
+	CriticalPreBidQueries = "pre_bid_queries"
+	CriticalDocumentFees  = "document_fees"
+)
+
+var criticalFieldKinds = []struct {
+	Name   string
+	IsDate bool
+}{
+	{CriticalContractValue, false},
+	{CriticalEMD, false},
+	{CriticalBidSubmission, true},
+	{CriticalPreBidQueries, true},
+	{CriticalDocumentFees, false},
+}
+
+// CriticalFieldValue is one field as extracted by a model
+type CriticalFieldValue struct {
+	Value string `json:"value"`
+	Pages []int  `json:"pages"`
+}
+
+// CriticalFields is the structured output of the critical_fields prompts
+type CriticalFields struct {
+	ContractValue CriticalFieldValue `json:"contract_value"`
+	EMD           CriticalFieldValue `json:"emd"`
+	BidSubmission CriticalFieldValue `json:"bid_submission"`
+	PreBidQueries CriticalFieldValue `json:"pre_bid_queries"`
+	DocumentFees  CriticalFieldValue `json:"document_fees"`
+}
+
+func (f *CriticalFields) get(name string) CriticalFieldValue {
+	switch name {
+	case CriticalContractValue:
+		return f.ContractValue
+	case CriticalEMD:
+		return f.EMD
+	case CriticalBidSubmission:
+		return f.BidSubmission
+	case CriticalPreBidQueries:
+		return f.PreBidQueries
+	case CriticalDocumentFees:
+		return f.DocumentFees
+	}
+	return CriticalFieldValue{}
+}
+
+// CrossCheckCandidate is one side's answer for a field
+type CrossCheckCandidate struct {
+	Model      string `json:"model"`
+	Prompt     string `json:"prompt"`
+	Value      string `json:"value"`
+	Normalized string `json:"normalized,omitempty"`
+	Pages      []int  `json:"pages,omitempty"`
+}
+
+// FieldCrossCheck is the verdict for one critical field
+type FieldCrossCheck struct {
+	Field      string                `json:"field"`
+	Status     string                `json:"status"`
+	Candidates []CrossCheckCandidate `json:"candidates"`
+}
+
+// CrossCheckResult is the verification block added to results
+type CrossCheckResult struct {
+	Fields    []FieldCrossCheck `json:"fields"`
+	Agreed    int               `json:"agreed"`
+	Disagreed int               `json:"disagreed"`
+	Errors    []string          `json:"errors,omitempty"`
+}
+
+// criticalFieldCheckRequested reports whether a request asked for the
+// cross-check with ?verify=true, falling back to TENDERIQ_VERIFY_CRITICAL_FIELDS
+func criticalFieldCheckRequested(c echo.Context) bool {
+	if value := c.QueryParam("verify"); value != "" {
+		verify, _ := strconv.ParseBool(value)
+		return verify
+	}
+	verify, _ := strconv.ParseBool(os.Getenv("TENDERIQ_VERIFY_CRITICAL_FIELDS"))
+	return verify
+}
+
+type criticalFieldCheckKey struct{}
+
+// WithCriticalFieldCheck asks the extractors run with ctx to cross-check
+// their critical fields
+func WithCriticalFieldCheck(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, criticalFieldCheckKey{}, enabled)
+}
+
+func criticalFieldCheckFrom(ctx context.Context) bool {
+	enabled, _ := ctx.Value(criticalFieldCheckKey{}).(bool)
+	return enabled
+}
+
+// CrossCheckCriticalFields extracts the critical fields twice, with the
+// extractor's Pro and Flash models, and compares the normalised answers.
+// When both route to the same model the second side uses a differently
+// worded prompt so the answers stay independent.
+func (g *GeminiService) CrossCheckCriticalFields(ctx context.Context, extractor string, documentText string) (*CrossCheckResult, []PromptRef) {
+	ctx = WithLLMExtractor(WithUsageMode(ctx, UsageModeCrossCheck), extractor)
+	models := g.routes[extractor]
+
+	prompts := promptRegistry()
+	primaryTmpl := prompts.Get(PromptCriticalFields)
+	secondaryTmpl := primaryTmpl
+	if models.Pro == models.Flash {
+		secondaryTmpl = prompts.Get(PromptCriticalFieldsVerify)
+	}
+
+	type side struct {
+		model  string
+		tmpl   *PromptTemplate
+		fields *CriticalFields
+		err    error
+	}
+	sides := []*side{
+		{model: models.Pro, tmpl: primaryTmpl},
+		{model: models.Flash, tmpl: secondaryTmpl},
+	}
+
+	var wg sync.WaitGroup
+	for i, s := range sides {
+		wg.Add(1)
+		go func(ctx context.Context, s *side) {
+			defer wg.Done()
+
+			prompt, err := s.tmpl.Render(map[string]string{"Document": documentText})
+			if err != nil {
+				s.err = err
+				return
+			}
+			var fields CriticalFields
+			if _, err := g.generateStructured(ctx, s.model, prompt, &fields); err != nil {
+				s.err = err
+				return
+			}
+			s.fields = &fields
+		}(WithLLMCallIndex(ctx, i), s)
+	}
+	wg.Wait()
+
+	result := &CrossCheckResult{Fields: []FieldCrossCheck{}}
+	promptRefs := []PromptRef{primaryTmpl.Ref()}
+	if secondaryTmpl != primaryTmpl {
+		promptRefs = append(promptRefs, secondaryTmpl.Ref())
+	}
+	for _, s := range sides {
+		if s.err != nil {
+			log.Printf("Critical field cross-check with %s failed (%s): %v", s.model, ClassifyLLMError(s.err), s.err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", s.model, s.err))
+		}
+	}
+
+	for _, kind := range criticalFieldKinds {
+		check := FieldCrossCheck{Field: kind.Name, Candidates: []CrossCheckCandidate{}}
+		var normalized []string
+		for _, s := range sides {
+			if s.fields == nil {
+				continue
+			}
+			value := s.fields.get(kind.Name)
+			if strings.TrimSpace(value.Value) == "" {
+				continue
+			}
+			candidate := CrossCheckCandidate{
+				Model:      s.model,
+				Prompt:     s.tmpl.Name,
+				Value:      value.Value,
+				Normalized: normalizeCriticalValue(value.Value, kind.IsDate),
+				Pages:      value.Pages,
+			}
+			check.Candidates = append(check.Candidates, candidate)
+			normalized = append(normalized, candidate.Normalized)
+		}
+
+		switch len(normalized) {
+		case 0:
+			check.Status = CrossCheckNotFound
+		case 1:
+			check.Status = CrossCheckSingleSource
+		default:
+			if criticalValuesAllAgree(normalized, kind.IsDate) {
+				check.Status = CrossCheckAgreed
+				result.Agreed++
+			} else {
+				check.Status = CrossCheckDisagreed
+				result.Disagreed++
+			}
+		}
+		result.Fields = append(result.Fields, check)
+	}
+
+	log.Printf("Critical field cross-check: %d agreed, %d disagreed", result.Agreed, result.Disagreed)
+	return result, promptRefs
+}
+
+var (
+	currencyRegex = regexp.MustCompile(`₹|\brs\b|\binr\b|\brupees?\b|\$|\busd\b|\bdollars?\b|€|\beur\b|\beuros?\b|£|\bgbp\b`)
+	amountRegex   = regexp.MustCompile(`(\d[\d,]*(?:\.\d+)?)\s*(crores?|cr\b|lakhs?|lacs?|million|mn\b|thousand|k\b)?`)
+	numericDate   = regexp.MustCompile(`\b(\d{1,2})[./-](\d{1,2})[./-](\d{2,4})\b`)
+	isoDate       = regexp.MustCompile(`\b(\d{4})-(\d{1,2})-(\d{1,2})\b`)
+	isoDateTime   = regexp.MustCompile(`\b(\d{4}-\d{1,2}-\d{1,2})t(\d)`)
+	dayMonthYear  = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?([a-z]{3,9})[,.]?\s+(\d{4})\b`)
+	monthDayYear  = regexp.MustCompile(`\b([a-z]{3,9})\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`)
+	clockTime     = regexp.MustCompile(`\b(\d{1,2})[:.](\d{2})\s*(am|pm|a\.m\.|p\.m\.)?`)
+	nonAlnumRegex = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+var monthNumbers = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// normalizeCriticalValue reduces a value to a comparable form: amounts to
+// their currency and units ("inr:1486000000", "usd:3000000"), dates to
+// "2025-03-28" with an optional " 15:00". Values that cannot be parsed, or
+// that name more than one currency, fall back to lower-case alphanumerics.
+func normalizeCriticalValue(value string, isDate bool) string {
+	s := strings.ToLower(value)
+	if isDate {
+		if date := normalizeDate(s); date != "" {
+			return date
+		}
+	} else if currency, ok := amountCurrency(s); ok {
+		if amount, ok := normalizeAmount(s); ok {
+			return currency + ":" + strconv.FormatFloat(amount, 'f', -1, 64)
+		}
+	}
+	return strings.Trim(nonAlnumRegex.ReplaceAllString(s, " "), " ")
+}
+
+// currencyCodes maps the currency markers of currencyRegex to ISO codes
+var currencyCodes = map[string]string{
+	"₹": "inr", "rs": "inr", "inr": "inr", "rupee": "inr", "rupees": "inr",
+	"$": "usd", "usd": "usd", "dollar": "usd", "dollars": "usd",
+	"€": "eur", "eur": "eur", "euro": "eur", "euros": "eur",
+	"£": "gbp", "gbp": "gbp",
+}
+
+// amountCurrency returns the ISO code of the currency an amount is in.
+// Amounts without one are in rupees, as in Indian tenders; amounts naming
+// two currencies, such as a dollar value with its rupee equivalent, are
+// not reported.
+func amountCurrency(s string) (string, bool) {
+	currency := ""
+	for _, marker := range currencyRegex.FindAllString(s, -1) {
+		code := currencyCodes[marker]
+		if currency != "" && code != currency {
+			return "", false
+		}
+		currency = code
+	}
+	if currency == "" {
+		currency = "inr"
+	}
+	return currency, true
+}
+
+// normalizeAmount returns the first amount in units of its currency,
+// applying crore, lakh, million and thousand multipliers
+func normalizeAmount(s string) (float64, bool) {
+	match := amountRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0, false
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(match[1], ",", ""), 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch unit := match[2]; {
+	case strings.HasPrefix(unit, "cr"):
+		amount *= 1e7
+	case strings.HasPrefix(unit, "la"):
+		amount *= 1e5
+	case unit == "million" || unit == "mn":
+		amount *= 1e6
+	case unit == "thousand" || unit == "k":
+		amount *= 1e3
+	}
+	// Round away float noise from the multipliers, to the paisa
+	return float64(int64(amount*100+0.5)) / 100, true
+}
+
+// normalizeDate returns the first date as YYYY-MM-DD, followed by HH:MM
+// when a time is given. Numeric dates are read day first, as in Indian
+// tenders. ISO and RFC 3339 date-times such as 2025-03-28T15:00:00+05:30
+// keep the time as written; the zone is ignored.
+func normalizeDate(s string) string {
+	s = isoDateTime.ReplaceAllString(s, "$1 $2")
+	var year, month, day int
+	if m := isoDate.FindStringSubmatch(s); m != nil {
+		year, month, day = atoi(m[1]), atoi(m[2]), atoi(m[3])
+	} else if m := numericDate.FindStringSubmatch(s); m != nil {
+		day, month, year = atoi(m[1]), atoi(m[2]), atoi(m[3])
+	} else if m := dayMonthYear.FindStringSubmatch(s); m != nil && monthNumber(m[2]) > 0 {
+		day, month, year = atoi(m[1]), monthNumber(m[2]), atoi(m[3])
+	} else if m := monthDayYear.FindStringSubmatch(s); m != nil && monthNumber(m[1]) > 0 {
+		month, day, year = monthNumber(m[1]), atoi(m[2]), atoi(m[3])
+	} else {
+		return ""
+	}
+	if year < 100 {
+		year += 2000
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return ""
+	}
+	date := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+
+	// Look for a time after removing the date, so "28.03.2025" is not read as 28:03
+	rest := isoDate.ReplaceAllString(s, " ")
+	rest = numericDate.ReplaceAllString(rest, " ")
+	if m := clockTime.FindStringSubmatch(rest); m != nil {
+		hour, minute := atoi(m[1]), atoi(m[2])
+		switch strings.ReplaceAll(m[3], ".", "") {
+		case "pm":
+			if hour < 12 {
+				hour += 12
+			}
+		case "am":
+			if hour == 12 {
+				hour = 0
+			}
+		}
+		if hour < 24 && minute < 60 {
+			date += fmt.Sprintf(" %02d:%02d", hour, minute)
+		}
+	}
+	return date
+}
+
+func monthNumber(name string) int {
+	if len(name) < 3 {
+		return 0
+	}
+	return monthNumbers[name[:3]]
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// criticalValuesAllAgree reports whether every pair of normalised values agrees
+func criticalValuesAllAgree(values []string, isDate bool) bool {
+	for i := range values {
+		for j := i + 1; j < len(values); j++ {
+			if !criticalValuesAgree(values[i], values[j], isDate) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// criticalValuesAgree compares two normalised values. Dates agree when the
+// days match and, if both carry a time, the times match too.
+func criticalValuesAgree(a, b string, isDate bool) bool {
+	if a == b {
+		return true
+	}
+	if !isDate {
+		return false
+	}
+	dayA, timeA, _ := strings.Cut(a, " ")
+	dayB, timeB, _ := strings.Cut(b, " ")
+	if dayA != dayB {
+		return false
+	}
+	return timeA == "" || timeB == "" || timeA == timeB
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestNormalizeCriticalValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		isDate bool
+		want   string
+	}{
+		{"Rs. 148.60 Crores", false, "inr:1486000000"},
+		{"INR 1,48,60,00,000", false, "inr:1486000000"},
+		{"₹ 2.5 lakh", false, "inr:250000"},
+		{"Rs 15 Lacs only (Rupees Fifteen Lakh)", false, "inr:1500000"},
+		{"148.60 crores", false, "inr:1486000000"},
+		{"USD 3 million", false, "usd:3000000"},
+		{"$3,000,000", false, "usd:3000000"},
+		{"EUR 2.5 million", false, "eur:2500000"},
+		{"€ 40,000", false, "eur:40000"},
+		{"USD 3 million (Rs. 25 crore)", false, "usd 3 million rs 25 crore"},
+		{"Rs. 5,000", false, "inr:5000"},
+		{"Not specified", false, "not specified"},
+		{"28.03.2025 up to 15:00 hrs", true, "2025-03-28 15:00"},
+		{"28/03/25", true, "2025-03-28"},
+		{"2025-03-28", true, "2025-03-28"},
+		{"2025-03-28T15:00", true, "2025-03-28 15:00"},
+		{"2025-03-28T15:00:00+05:30", true, "2025-03-28 15:00"},
+		{"2025-03-28T09:30:00Z", true, "2025-03-28 09:30"},
+		{"28th March, 2025 at 3.00 PM", true, "2025-03-28 15:00"},
+		{"March 28, 2025 12:30 am", true, "2025-03-28 00:30"},
+		{"31.13.2025", true, "31 13 2025"},
+		{"within 30 days", true, "within 30 days"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCriticalValue(tt.value, tt.isDate); got != tt.want {
+			t.Errorf("normalizeCriticalValue(%q, %v) = %q, want %q", tt.value, tt.isDate, got, tt.want)
+		}
+	}
+}
+
+func TestCriticalValuesAllAgree(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		isDate bool
+		want   bool
+	}{
+		{"same amount", []string{"inr:1486000000", "inr:1486000000"}, false, true},
+		{"different amount", []string{"inr:1486000000", "inr:148600000"}, false, false},
+		{"different currency", []string{"inr:3000000", "usd:3000000"}, false, false},
+		{"date without time", []string{"2025-03-28", "2025-03-28 15:00"}, true, true},
+		{"different times", []string{"2025-03-28 15:00", "2025-03-28 17:00"}, true, false},
+		{"different days", []string{"2025-03-28", "2025-03-29"}, true, false},
+		{"third model disagrees", []string{"2025-03-28 15:00", "2025-03-28", "2025-03-28 17:00"}, true, false},
+		{"three models agree", []string{"inr:5000", "inr:5000", "inr:5000"}, false, true},
+	}
+	for _, tt := range tests {
+		if got := criticalValuesAllAgree(tt.values, tt.isDate); got != tt.want {
+			t.Errorf("%s: criticalValuesAllAgree(%q) = %v, want %v", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Status of an extraction result; anything but complete means the request
+// context ended early and the result holds what was extracted until then
+const (
+	ResultStatusComplete         = "complete"
+	ResultStatusCancelled        = "cancelled"
+	ResultStatusDeadlineExceeded = "deadline_exceeded"
+)
+
+// EndpointUpload names the document upload deadline; the other endpoints use
+// their extractor names
+const EndpointUpload = "upload"
+
+// Default time budget of a request, and the defaults of endpoints that differ
+const defaultRequestDeadlineSeconds = 300
+
+var defaultEndpointDeadlineSeconds = map[string]int{
+	ExtractorAnalyze: 120,
+	ExtractorChat:    60,
+}
+
+// requestDeadline reads TENDERIQ_DEADLINE_<ENDPOINT>_SECONDS, falling back to
+// TENDERIQ_DEADLINE_SECONDS and the defaults. Zero or less means no deadline.
+func requestDeadline(endpoint string) time.Duration {
+	def, ok := defaultEndpointDeadlineSeconds[endpoint]
+	if !ok {
+		def = getEnvInt("TENDERIQ_DEADLINE_SECONDS", defaultRequestDeadlineSeconds)
+	}
+	seconds := getEnvInt("TENDERIQ_DEADLINE_"+strings.ToUpper(endpoint)+"_SECONDS", def)
+	return time.Duration(seconds) * time.Second
+}
+
+// withRequestDeadline bounds ctx by the endpoint's deadline
+func withRequestDeadline(ctx context.Context, endpoint string) (context.Context, context.CancelFunc) {
+	deadline := requestDeadline(endpoint)
+	if deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, deadline)
+}
+
+// resultStatus reports whether ctx ended before the work using it finished
+func resultStatus(ctx context.Context) string {
+	switch err := ctx.Err(); {
+	case err == nil:
+		return ResultStatusComplete
+	case errors.Is(err, context.DeadlineExceeded):
+		return ResultStatusDeadlineExceeded
+	default:
+		return ResultStatusCancelled
+	}
+}
+
+// logInterrupted notes that an extraction stopped early and returns its status
+func logInterrupted(ctx context.Context, what string) string {
+	status := resultStatus(ctx)
+	if status != ResultStatusComplete {
+		log.Printf("%s stopped early (%s); returning partial result", what, status)
+	}
+	return status
+}
+
+// withRequestStatus adds the request status to an error body when the
+// request context ended before anything could be returned
+func withRequestStatus(ctx context.Context, body map[string]string) map[string]string {
+	if status := resultStatus(ctx); status != ResultStatusComplete {
+		body["status"] = status
+	}
+	return body
+}
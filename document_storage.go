@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage backends for stored documents
+const (
+	DocumentStorageDisk   = "disk"
+	DocumentStorageMemory = "memory"
+)
+
+const (
+	defaultDocumentStoreDir = "tenderiq_store"
+	documentSnapshotFile    = "snapshot.jsonl"
+	documentLogFile         = "log.jsonl"
+	documentBlobDir         = "blobs"
+	defaultStoreCompactMB   = 256
+)
+
+// DocumentStorage keeps the documents of a VectorStore across restarts.
+// The VectorStore holds every document in memory and writes each change
+// through to its storage. Put, SetUsage and Delete keep changes in the order
+// they are called, so the VectorStore calls them under its lock; the slow
+// parts of storing a change, Encode and Sync, are left out of it.
+type DocumentStorage interface {
+	// Load returns every stored document, with its parse
+	Load() ([]*Document, error)
+	// Encode prepares a document for Put
+	Encode(doc *Document) (*EncodedDocument, error)
+	Put(doc *EncodedDocument) error
+	// SetUsage replaces a document's usage total
+	SetUsage(docID string, usage *UsageSummary) error
+	Delete(docID string) error
+	// Sync makes the changes written so far durable
+	Sync() error
+	Close() error
+}
+
+// EncodedDocument is a document ready to be stored
+type EncodedDocument struct {
+	ID   string
+	line []byte
+}
+
+// MemoryStorage keeps nothing: documents last as long as the process
+type MemoryStorage struct{}
+
+func (MemoryStorage) Load() ([]*Document, error) { return nil, nil }
+func (MemoryStorage) Encode(doc *Document) (*EncodedDocument, error) {
+	return &EncodedDocument{ID: doc.ID}, nil
+}
+func (MemoryStorage) Put(*EncodedDocument) error           { return nil }
+func (MemoryStorage) SetUsage(string, *UsageSummary) error { return nil }
+func (MemoryStorage) Delete(string) error                  { return nil }
+func (MemoryStorage) Sync() error                          { return nil }
+func (MemoryStorage) Close() error                         { return nil }
+
+// documentRecord is one change in the log, or one document in the snapshot
+type documentRecord struct {
+	Op       string        `json:"op"`
+	ID       string        `json:"id"`
+	Document *Document     `json:"document,omitempty"`
+	Parsed   *parsedRecord `json:"parsed,omitempty"`
+	Usage    *UsageSummary `json:"usage,omitempty"`
+}
+
+const (
+	recordPut    = "put"
+	recordUsage  = "usage"
+	recordDelete = "delete"
+)
+
+// parsedRecord is a ParsedDocument with the fields it keeps out of API
+// responses, such as page texts and layouts
+type parsedRecord struct {
+	Hash      string                 `json:"hash"`
+	Filename  string                 `json:"filename"`
+	Pages     []pageRecord           `json:"pages"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	Outline   *DocumentOutline       `json:"outline,omitempty"`
+	Files     []fileRecord           `json:"files,omitempty"`
+	Encrypted bool                   `json:"encrypted,omitempty"`
+	Text      string                 `json:"text"`
+}
+
+// pageRecord has the fields of PageText, so pages convert to and from it
+type pageRecord struct {
+	Number      int               `json:"page"`
+	Text        string            `json:"text"`
+	Source      string            `json:"source"`
+	Confidence  float64           `json:"confidence,omitempty"`
+	Lines       []TextLine        `json:"lines,omitempty"`
+	Boilerplate []BoilerplateLine `json:"boilerplate,omitempty"`
+	Language    string            `json:"language,omitempty"`
+	TableText   string            `json:"table_text,omitempty"`
+	Tables      []Table           `json:"tables,omitempty"`
+}
+
+// fileRecord is a PackageMember. The content of a PDF is kept in a blob
+// named by its SHA-256; stores written before blobs have it in Source.
+type fileRecord struct {
+	Filename   string `json:"filename"`
+	Kind       string `json:"kind"`
+	Pages      int    `json:"pages"`
+	FirstPage  int    `json:"first_page"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
+	SourceHash string `json:"source_hash,omitempty"`
+	Source     []byte `json:"source,omitempty"`
+}
+
+func newParsedRecord(d *ParsedDocument) *parsedRecord {
+	if d == nil {
+		return nil
+	}
+	record := &parsedRecord{
+		Hash:      d.Hash,
+		Filename:  d.Filename,
+		Pages:     make([]pageRecord, len(d.Pages)),
+		Metadata:  d.Metadata,
+		Outline:   d.Outline,
+		Encrypted: d.Encrypted,
+		Text:      d.text,
+	}
+	for i, page := range d.Pages {
+		record.Pages[i] = pageRecord(page)
+	}
+	for _, member := range d.Files {
+		record.Files = append(record.Files, fileRecord{
+			Filename:  member.Filename,
+			Kind:      member.Kind,
+			Pages:     member.Pages,
+			FirstPage: member.FirstPage,
+			Encrypted: member.encrypted,
+		})
+	}
+	return record
+}
+
+func (r *parsedRecord) document() *ParsedDocument {
+	if r == nil {
+		return nil
+	}
+	doc := &ParsedDocument{
+		Hash:      r.Hash,
+		Filename:  r.Filename,
+		Pages:     make([]PageText, len(r.Pages)),
+		Metadata:  r.Metadata,
+		Outline:   r.Outline,
+		Encrypted: r.Encrypted,
+		text:      r.Text,
+	}
+	for i, page := range r.Pages {
+		doc.Pages[i] = PageText(page)
+	}
+	for _, file := range r.Files {
+		doc.Files = append(doc.Files, &PackageMember{
+			Filename:  file.Filename,
+			Kind:      file.Kind,
+			Pages:     file.Pages,
+			FirstPage: file.FirstPage,
+			encrypted: file.Encrypted,
+			source:    file.Source,
+		})
+	}
+	return doc
+}
+
+// LogStorage keeps documents on disk as a snapshot of every document plus a
+// log of the changes since, both as JSON lines. The content of PDFs is kept
+// once per content in blob files, which records refer to by hash. Load
+// replays the log over the snapshot and writes a new snapshot, as does Sync
+// once the log grows past its size limit.
+type LogStorage struct {
+	dir          string
+	compactBytes int64
+	mu           sync.Mutex
+	log          *os.File
+	logBytes     int64
+}
+
+// NewLogStorage opens the store in dir, creating it if needed. The log is
+// compacted when it grows past TENDERIQ_STORE_COMPACT_MB.
+func NewLogStorage(dir string) (*LogStorage, error) {
+	if err := os.MkdirAll(filepath.Join(dir, documentBlobDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create document store directory: %w", err)
+	}
+	return &LogStorage{
+		dir:          dir,
+		compactBytes: int64(getEnvInt("TENDERIQ_STORE_COMPACT_MB", defaultStoreCompactMB)) << 20,
+	}, nil
+}
+
+// Load reads the snapshot and the log, then compacts them into a new
+// snapshot and removes the blobs no document refers to. A record cut short
+// by a crash ends the log and is dropped.
+func (s *LogStorage) Load() ([]*Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.replay()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.compact(records); err != nil {
+		return nil, err
+	}
+	s.removeUnusedBlobs(records)
+
+	loaded := make([]*Document, len(records))
+	for i, record := range records {
+		loaded[i] = s.document(record)
+	}
+	return loaded, nil
+}
+
+// replay reads the snapshot and then the log into the put record of each
+// stored document, in the order they were added, with their latest usage
+// total
+func (s *LogStorage) replay() ([]documentRecord, error) {
+	records := make(map[string]*documentRecord)
+	var order []string
+	added := make(map[string]int)
+	apply := func(record documentRecord) {
+		switch record.Op {
+		case recordPut:
+			if record.Document == nil {
+				return
+			}
+			if previous, exists := records[record.ID]; exists {
+				// Uploading a document again keeps its usage total
+				if record.Document.Usage == nil {
+					record.Document.Usage = previous.Document.Usage
+				}
+			} else {
+				added[record.ID] = len(order)
+				order = append(order, record.ID)
+			}
+			records[record.ID] = &record
+		case recordUsage:
+			if previous, exists := records[record.ID]; exists {
+				previous.Document.Usage = record.Usage
+			}
+		case recordDelete:
+			delete(records, record.ID)
+		}
+	}
+	for _, name := range []string{documentSnapshotFile, documentLogFile} {
+		if err := readDocumentRecords(filepath.Join(s.dir, name), apply); err != nil {
+			return nil, err
+		}
+	}
+
+	var replayed []documentRecord
+	for i, id := range order {
+		// A document deleted and added again is listed where it was added again
+		if record, exists := records[id]; exists && added[id] == i {
+			replayed = append(replayed, *record)
+		}
+	}
+	return replayed, nil
+}
+
+// document returns the document of a put record with its PDFs read back
+// from their blobs
+func (s *LogStorage) document(record documentRecord) *Document {
+	doc := record.Document
+	doc.Parsed = record.Parsed.document()
+	if doc.Parsed == nil {
+		return doc
+	}
+	for i, file := range record.Parsed.Files {
+		if file.SourceHash == "" {
+			continue
+		}
+		source, err := os.ReadFile(s.blobPath(file.SourceHash))
+		if err != nil {
+			log.Printf("Warning: content of %s in document %s is missing: %v", file.Filename, doc.ID, err)
+			continue
+		}
+		doc.Parsed.Files[i].source = source
+	}
+	return doc
+}
+
+// readDocumentRecords calls apply with each record of a file, if it exists
+func readDocumentRecords(path string, apply func(documentRecord)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var record documentRecord
+		if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+			if err == io.EOF {
+				log.Printf("Warning: dropping incomplete last record of %s", path)
+				return nil
+			}
+			return fmt.Errorf("failed to parse record %d of %s: %w", n, path, jsonErr)
+		}
+		apply(record)
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// compact writes records as the new snapshot and starts an empty log. PDF
+// content held in records from before blobs is moved to blobs. The snapshot
+// is replaced atomically; records replayed over it again after a crash
+// before the log is emptied leave the same documents.
+func (s *LogStorage) compact(records []documentRecord) error {
+	tmp, err := os.CreateTemp(s.dir, documentSnapshotFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, record := range records {
+		if err = s.moveSourcesToBlobs(record.Parsed); err != nil {
+			break
+		}
+		var line []byte
+		if line, err = encodeDocumentRecord(record); err != nil {
+			break
+		}
+		if _, err = writer.Write(line); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, documentSnapshotFile)); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, err = os.OpenFile(filepath.Join(s.dir, documentLogFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open document log: %w", err)
+	}
+	s.logBytes = 0
+	return nil
+}
+
+// moveSourcesToBlobs writes PDF content held in a record to blobs. Those
+// records do not say which file of a package was encrypted, so the content
+// of every file of a package with an encrypted file is dropped instead.
+func (s *LogStorage) moveSourcesToBlobs(parsed *parsedRecord) error {
+	if parsed == nil {
+		return nil
+	}
+	for i := range parsed.Files {
+		file := &parsed.Files[i]
+		if file.Source == nil {
+			continue
+		}
+		if !parsed.Encrypted && !file.Encrypted {
+			hash, err := s.writeBlob(file.Source)
+			if err != nil {
+				return err
+			}
+			file.SourceHash = hash
+		}
+		file.Source = nil
+	}
+	return nil
+}
+
+// blobPath is the file holding the content with the given hash
+func (s *LogStorage) blobPath(hash string) string {
+	return filepath.Join(s.dir, documentBlobDir, hash+".pdf")
+}
+
+// writeBlob stores content under its SHA-256 unless the same content is
+// already stored, and returns the hash
+func (s *LogStorage) writeBlob(content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	path := s.blobPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	return hash, nil
+}
+
+// removeUnusedBlobs deletes the blobs, and blobs left half written, that no
+// record refers to. It only runs in Load, before any blob is written for a
+// record not yet in the log.
+func (s *LogStorage) removeUnusedBlobs(records []documentRecord) {
+	used := make(map[string]bool)
+	for _, record := range records {
+		if record.Parsed == nil {
+			continue
+		}
+		for _, file := range record.Parsed.Files {
+			if file.SourceHash != "" {
+				used[filepath.Base(s.blobPath(file.SourceHash))] = true
+			}
+		}
+	}
+
+	dir := filepath.Join(s.dir, documentBlobDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: failed to list document blobs: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if used[entry.Name()] || !(strings.HasSuffix(entry.Name(), ".pdf") || strings.HasSuffix(entry.Name(), ".tmp")) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("Warning: failed to remove unused blob %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+func encodeDocumentRecord(record documentRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode document %s: %w", record.ID, err)
+	}
+	return append(data, '\n'), nil
+}
+
+// appendLine writes an encoded record to the log
+func (s *LogStorage) appendLine(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log == nil {
+		var err error
+		s.log, err = os.OpenFile(filepath.Join(s.dir, documentLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open document log: %w", err)
+		}
+		if info, err := s.log.Stat(); err == nil {
+			s.logBytes = info.Size()
+		}
+	}
+	n, err := s.log.Write(line)
+	s.logBytes += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write document log: %w", err)
+	}
+	return nil
+}
+
+func (s *LogStorage) appendRecord(record documentRecord) error {
+	line, err := encodeDocumentRecord(record)
+	if err != nil {
+		return err
+	}
+	return s.appendLine(line)
+}
+
+// Encode writes the content of the document's PDFs to blobs and encodes
+// its put record. The decrypted content of encrypted PDFs is not kept.
+func (s *LogStorage) Encode(doc *Document) (*EncodedDocument, error) {
+	record := documentRecord{Op: recordPut, ID: doc.ID, Document: doc, Parsed: newParsedRecord(doc.Parsed)}
+	if doc.Parsed != nil {
+		for i, member := range doc.Parsed.Files {
+			if member.source == nil || member.encrypted {
+				continue
+			}
+			hash, err := s.writeBlob(member.source)
+			if err != nil {
+				return nil, err
+			}
+			record.Parsed.Files[i].SourceHash = hash
+		}
+	}
+	line, err := encodeDocumentRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	return &EncodedDocument{ID: doc.ID, line: line}, nil
+}
+
+func (s *LogStorage) Put(doc *EncodedDocument) error {
+	return s.appendLine(doc.line)
+}
+
+func (s *LogStorage) SetUsage(docID string, usage *UsageSummary) error {
+	return s.appendRecord(documentRecord{Op: recordUsage, ID: docID, Usage: usage})
+}
+
+func (s *LogStorage) Delete(docID string) error {
+	return s.appendRecord(documentRecord{Op: recordDelete, ID: docID})
+}
+
+// Sync syncs the log to disk, then compacts the store if the log has grown
+// past its limit. A failed compaction leaves the log as it is.
+func (s *LogStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log == nil {
+		return nil
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync document log: %w", err)
+	}
+	if s.compactBytes <= 0 || s.logBytes < s.compactBytes {
+		return nil
+	}
+
+	records, err := s.replay()
+	if err == nil {
+		err = s.compact(records)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to compact document store: %v", err)
+		return nil
+	}
+	log.Printf("Compacted document store to %d documents", len(records))
+	return nil
+}
+
+func (s *LogStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+// documentStorageFromEnv opens the storage chosen by TENDERIQ_STORE: disk
+// (the default) in TENDERIQ_STORE_DIR, or memory
+func documentStorageFromEnv() (DocumentStorage, error) {
+	switch backend := os.Getenv("TENDERIQ_STORE"); backend {
+	case "", DocumentStorageDisk:
+		dir := os.Getenv("TENDERIQ_STORE_DIR")
+		if dir == "" {
+			dir = defaultDocumentStoreDir
+		}
+		return NewLogStorage(dir)
+	case DocumentStorageMemory:
+		return MemoryStorage{}, nil
+	default:
+		return nil, fmt.Errorf("unknown TENDERIQ_STORE %q: use disk or memory", backend)
+	}
+}
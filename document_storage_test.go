@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testDocument is a stored document whose parse holds one PDF with content
+func testDocument(id string, source []byte, encrypted bool) *Document {
+	return &Document{
+		ID:      id,
+		Content: "content of " + id,
+		Chunks:  []DocumentChunk{{ID: id + "_chunk_0", Content: "content of " + id, PageNum: 1}},
+		Parsed: &ParsedDocument{
+			Hash:      id,
+			Filename:  id + ".pdf",
+			Pages:     []PageText{{Number: 1, Text: "content of " + id, Source: PageSourceText}},
+			Encrypted: encrypted,
+			Files: []*PackageMember{{
+				Filename:  id + ".pdf",
+				Kind:      PackageFilePDF,
+				Pages:     1,
+				FirstPage: 1,
+				encrypted: encrypted,
+				source:    source,
+			}},
+			text: "content of " + id,
+		},
+	}
+}
+
+// testUsage is a usage total of one call
+func testUsage(promptTokens int) *UsageSummary {
+	usage := newUsageSummary()
+	usage.add(UsageRecord{Model: "gemini-2.5-flash", PromptTokens: promptTokens})
+	return usage
+}
+
+// storeOp changes a store through its VectorStore, as the server does
+type storeOp func(t *testing.T, vs *VectorStore)
+
+func putOp(doc *Document) storeOp {
+	return func(t *testing.T, vs *VectorStore) {
+		if err := vs.putDocument(doc); err != nil {
+			t.Fatalf("failed to put %s: %v", doc.ID, err)
+		}
+	}
+}
+
+func usageOp(id string, promptTokens int) storeOp {
+	return func(t *testing.T, vs *VectorStore) { vs.RecordUsage(id, testUsage(promptTokens)) }
+}
+
+func deleteOp(id string) storeOp {
+	return func(t *testing.T, vs *VectorStore) { vs.DeleteDocument(id) }
+}
+
+// reopen loads the store in dir as the server does at startup
+func reopen(t *testing.T, dir string) (*VectorStore, []*Document) {
+	t.Helper()
+	storage, err := NewLogStorage(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	docs, err := storage.Load()
+	if err != nil {
+		t.Fatalf("failed to load store: %v", err)
+	}
+	vs := &VectorStore{documents: make(map[string]*Document), storage: storage}
+	for _, doc := range docs {
+		vs.documents[doc.ID] = doc
+	}
+	t.Cleanup(func() { storage.Close() })
+	return vs, docs
+}
+
+// storedSummary renders loaded documents as "id:prompt tokens"
+func storedSummary(docs []*Document) []string {
+	var out []string
+	for _, doc := range docs {
+		tokens := 0
+		if doc.Usage != nil {
+			tokens = doc.Usage.PromptTokens
+		}
+		out = append(out, doc.ID+":"+strings.Repeat("x", tokens))
+	}
+	return out
+}
+
+func TestLogStorageReplay(t *testing.T) {
+	pdf := []byte("%PDF-1.7 content")
+	tests := []struct {
+		name string
+		ops  []storeOp
+		torn string
+		want []string
+	}{
+		{
+			name: "puts and usage",
+			ops:  []storeOp{putOp(testDocument("a", pdf, false)), putOp(testDocument("b", nil, false)), usageOp("a", 2), usageOp("a", 1)},
+			want: []string{"a:xxx", "b:"},
+		},
+		{
+			name: "torn last record",
+			ops:  []storeOp{putOp(testDocument("a", pdf, false)), usageOp("a", 1)},
+			torn: `{"op":"put","id":"c","document":{"id":"c","content":"cut sh`,
+			want: []string{"a:x"},
+		},
+		{
+			name: "delete then put again",
+			ops: []storeOp{
+				putOp(testDocument("a", pdf, false)), usageOp("a", 2), putOp(testDocument("b", nil, false)),
+				deleteOp("a"), putOp(testDocument("a", pdf, false)), usageOp("a", 1),
+			},
+			want: []string{"b:", "a:x"},
+		},
+		{
+			name: "upload again keeps usage",
+			ops:  []storeOp{putOp(testDocument("a", pdf, false)), usageOp("a", 2), putOp(testDocument("a", pdf, false)), usageOp("a", 1)},
+			want: []string{"a:xxx"},
+		},
+		{
+			name: "delete",
+			ops:  []storeOp{putOp(testDocument("a", pdf, false)), putOp(testDocument("b", nil, false)), deleteOp("a"), deleteOp("missing")},
+			want: []string{"b:"},
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		vs, _ := reopen(t, dir)
+		for _, op := range tt.ops {
+			op(t, vs)
+		}
+		want := storedSummary(sortedDocuments(vs))
+		if tt.torn != "" {
+			f, err := os.OpenFile(filepath.Join(dir, documentLogFile), os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatalf("%s: failed to open log: %v", tt.name, err)
+			}
+			f.WriteString(tt.torn)
+			f.Close()
+		}
+
+		_, docs := reopen(t, dir)
+		if got := storedSummary(docs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: loaded %q, want %q", tt.name, got, tt.want)
+		}
+		if got := storedSummary(sortedByID(docs)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: loaded %q, but the store held %q", tt.name, got, want)
+		}
+		// Loading compacted the store, so loading again gives the same documents
+		if _, again := reopen(t, dir); !reflect.DeepEqual(storedSummary(again), tt.want) {
+			t.Errorf("%s: loaded %q after compaction, want %q", tt.name, storedSummary(again), tt.want)
+		}
+	}
+}
+
+// sortedDocuments lists the documents of a store by ID
+func sortedDocuments(vs *VectorStore) []*Document {
+	var docs []*Document
+	for _, id := range vs.ListDocuments() {
+		doc, _ := vs.GetDocument(id)
+		docs = append(docs, doc)
+	}
+	return sortedByID(docs)
+}
+
+func sortedByID(docs []*Document) []*Document {
+	sorted := append([]*Document(nil), docs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+func TestLogStorageCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	vs, _ := reopen(t, dir)
+	putOp(testDocument("a", nil, false))(t, vs)
+	vs.storage.Close()
+
+	// A bad record followed by others is not a torn write
+	f, err := os.OpenFile(filepath.Join(dir, documentLogFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteString("{not json\n" + `{"op":"delete","id":"a"}` + "\n")
+	f.Close()
+
+	storage, err := NewLogStorage(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if _, err := storage.Load(); err == nil || !strings.Contains(err.Error(), "failed to parse record 2") {
+		t.Errorf("error = %v, want a parse error for record 2", err)
+	}
+}
+
+func TestLogStorageBlobs(t *testing.T) {
+	dir := t.TempDir()
+	shared := []byte("%PDF-1.7 shared content")
+	secret := []byte("%PDF-1.7 decrypted content")
+
+	vs, _ := reopen(t, dir)
+	for _, op := range []storeOp{
+		putOp(testDocument("a", shared, false)),
+		putOp(testDocument("b", shared, false)),
+		putOp(testDocument("c", secret, true)),
+		putOp(testDocument("d", []byte("%PDF-1.7 deleted content"), false)),
+		deleteOp("d"),
+	} {
+		op(t, vs)
+	}
+
+	// Records refer to blobs instead of holding the content
+	for _, name := range []string{documentLogFile, documentSnapshotFile} {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		for _, content := range [][]byte{shared, secret} {
+			if bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(content))) {
+				t.Errorf("%s holds PDF content %q", name, content)
+			}
+		}
+	}
+	blobs, _ := os.ReadDir(filepath.Join(dir, documentBlobDir))
+	if len(blobs) != 2 {
+		t.Errorf("%d blobs before load, want 2 (shared and deleted)", len(blobs))
+	}
+
+	_, docs := reopen(t, dir)
+	blobs, _ = os.ReadDir(filepath.Join(dir, documentBlobDir))
+	if len(blobs) != 1 {
+		t.Errorf("%d blobs after load, want 1", len(blobs))
+	}
+	sources := make(map[string][]byte)
+	for _, doc := range docs {
+		sources[doc.ID] = doc.Parsed.Files[0].source
+	}
+	want := map[string][]byte{"a": shared, "b": shared, "c": nil}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("sources = %q, want %q", sources, want)
+	}
+	if _, _, err := docs[2].Parsed.PageSource(1); err == nil || !strings.Contains(err.Error(), "encrypted PDF") {
+		t.Errorf("page source of encrypted document: error = %v", err)
+	}
+}
+
+func TestLogStorageInlineSource(t *testing.T) {
+	dir := t.TempDir()
+	pdf := []byte("%PDF-1.7 inline content")
+	record := documentRecord{Op: recordPut, ID: "a", Document: &Document{ID: "a"}, Parsed: newParsedRecord(testDocument("a", nil, false).Parsed)}
+	record.Parsed.Files[0].Source = pdf
+	line, err := encodeDocumentRecord(record)
+	if err != nil {
+		t.Fatalf("failed to encode record: %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, documentSnapshotFile), line, 0644)
+
+	_, docs := reopen(t, dir)
+	if len(docs) != 1 || !bytes.Equal(docs[0].Parsed.Files[0].source, pdf) {
+		t.Fatalf("inline source was not loaded")
+	}
+	snapshot, _ := os.ReadFile(filepath.Join(dir, documentSnapshotFile))
+	if bytes.Contains(snapshot, []byte(base64.StdEncoding.EncodeToString(pdf))) || !bytes.Contains(snapshot, []byte(`"source_hash"`)) {
+		t.Errorf("snapshot still holds the content inline: %s", snapshot)
+	}
+}
+
+func TestLogStorageCompactsPastLimit(t *testing.T) {
+	dir := t.TempDir()
+	vs, _ := reopen(t, dir)
+	storage := vs.storage.(*LogStorage)
+	putOp(testDocument("a", nil, false))(t, vs)
+
+	info, _ := os.Stat(filepath.Join(dir, documentLogFile))
+	storage.compactBytes = info.Size() + 1
+	usageOp("a", 1)(t, vs)
+
+	if info, _ := os.Stat(filepath.Join(dir, documentLogFile)); info.Size() != 0 {
+		t.Errorf("log has %d bytes after passing its limit, want 0", info.Size())
+	}
+	putOp(testDocument("b", nil, false))(t, vs)
+	usageOp("b", 2)(t, vs)
+
+	_, docs := reopen(t, dir)
+	if got, want := storedSummary(docs), []string{"a:x", "b:xx"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %q, want %q", got, want)
+	}
+}
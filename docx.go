@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DOCX files have no fixed pages. Page breaks Word recorded when it last
+// laid the file out are used when present, explicit page breaks otherwise,
+// and without either the text is cut into pages of about this many characters.
+const docxPageChars = 3000
+
+// Limit on any one XML part read from an office file
+const officePartMaxBytes = 64 << 20
+
+// docxBlock is a paragraph or a table of a DOCX body
+type docxBlock struct {
+	text       string
+	breakAfter bool // a page break follows the block
+	heading    int  // outline level of a heading paragraph, 0 otherwise
+	table      *Table
+}
+
+var docxHeadingStyleRe = regexp.MustCompile(`(?i)^heading\s*([1-9])$`)
+
+// ExtractDOCX reads the text of a DOCX file into pages, with its tables as
+// markdown and its Heading styles as an outline
+func ExtractDOCX(data []byte) ([]PageText, []OutlineEntry, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open DOCX file: %w", err)
+	}
+	body, err := readZipPart(archive, "word/document.xml")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read DOCX body: %w", err)
+	}
+
+	// Rendered breaks already include the explicit ones, so only one kind is used
+	rendered := bytes.Contains(body, []byte("lastRenderedPageBreak"))
+	blocks, err := parseDOCXBody(body, rendered)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse DOCX body: %w", err)
+	}
+
+	var pages []PageText
+	var outline []OutlineEntry
+	var page strings.Builder
+	hasBreaks := false
+	for _, block := range blocks {
+		hasBreaks = hasBreaks || block.breakAfter
+	}
+	var tables []Table
+	newPage := func() {
+		pages = append(pages, PageText{Number: len(pages) + 1, Text: page.String(), Source: PageSourceText, Tables: tables})
+		page.Reset()
+		tables = nil
+	}
+	for _, block := range blocks {
+		if !hasBreaks && page.Len() > 0 && page.Len()+len(block.text) > docxPageChars {
+			newPage()
+		}
+		if block.heading > 0 {
+			outline = append(outline, OutlineEntry{Title: block.text, Level: block.heading, Page: len(pages) + 1})
+		}
+		if block.table != nil {
+			block.table.Page = len(pages) + 1
+			tables = append(tables, *block.table)
+		}
+		page.WriteString(block.text)
+		page.WriteString("\n")
+		if block.breakAfter {
+			newPage()
+		}
+	}
+	if page.Len() > 0 {
+		newPage()
+	}
+
+	for i := range pages {
+		if strings.TrimSpace(pages[i].Text) == "" {
+			pages[i].Source = PageSourceEmpty
+		}
+	}
+	return pages, outline, nil
+}
+
+// parseDOCXBody walks word/document.xml into paragraphs and tables. Tables
+// nested in a cell are flattened into the cell text.
+func parseDOCXBody(body []byte, renderedBreaks bool) ([]docxBlock, error) {
+	var blocks []docxBlock
+	var para strings.Builder
+	var paraStyle string
+	paraBreak := false
+	inText := false
+
+	// Open tables, innermost last
+	type docxTable struct {
+		rows [][]TableCell
+		row  []TableCell
+		cell strings.Builder
+	}
+	var tables []*docxTable
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				// Tab stop definitions in paragraph properties have a position
+				if attr(t, "pos") == "" {
+					para.WriteString("\t")
+				}
+			case "br":
+				if attr(t, "type") == "page" {
+					paraBreak = paraBreak || !renderedBreaks
+				} else {
+					para.WriteString("\n")
+				}
+			case "lastRenderedPageBreak":
+				// Marks the start of a new page, i.e. a break after the previous block
+				if renderedBreaks && len(tables) == 0 && len(blocks) > 0 {
+					blocks[len(blocks)-1].breakAfter = true
+				}
+			case "pStyle":
+				paraStyle = attr(t, "val")
+			case "tbl":
+				tables = append(tables, &docxTable{})
+			case "tr":
+				if n := len(tables); n > 0 {
+					tables[n-1].row = nil
+				}
+			case "tc":
+				if n := len(tables); n > 0 {
+					tables[n-1].cell.Reset()
+				}
+			}
+
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(para.String())
+				para.Reset()
+				if n := len(tables); n > 0 {
+					cell := &tables[n-1].cell
+					if text != "" {
+						if cell.Len() > 0 {
+							cell.WriteString(" ")
+						}
+						cell.WriteString(text)
+					}
+				} else if text != "" || paraBreak {
+					block := docxBlock{text: text, breakAfter: paraBreak}
+					if m := docxHeadingStyleRe.FindStringSubmatch(paraStyle); m != nil && text != "" {
+						block.heading, _ = strconv.Atoi(m[1])
+					} else if strings.EqualFold(paraStyle, "Title") && text != "" {
+						block.heading = 1
+					}
+					blocks = append(blocks, block)
+				}
+				paraStyle = ""
+				paraBreak = false
+			case "tc":
+				if n := len(tables); n > 0 {
+					table := tables[n-1]
+					table.row = append(table.row, TableCell{Text: table.cell.String()})
+				}
+			case "tr":
+				if n := len(tables); n > 0 {
+					table := tables[n-1]
+					table.rows = append(table.rows, table.row)
+				}
+			case "tbl":
+				n := len(tables)
+				if n == 0 {
+					continue
+				}
+				table := Table{Rows: padRows(tables[n-1].rows)}
+				tables = tables[:n-1]
+				if len(table.Rows) == 0 {
+					continue
+				}
+				if n > 1 {
+					// A nested table becomes text of the cell around it
+					cell := &tables[n-2].cell
+					for _, row := range table.Rows {
+						for _, c := range row {
+							if c.Text != "" {
+								cell.WriteString(" " + c.Text)
+							}
+						}
+					}
+					continue
+				}
+				blocks = append(blocks, docxBlock{text: strings.TrimSpace(table.Markdown()), table: &table})
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// padRows gives every row as many cells as the widest, as merged cells leave
+// rows short
+func padRows(rows [][]TableCell) [][]TableCell {
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	for i := range rows {
+		for len(rows[i]) < width {
+			rows[i] = append(rows[i], TableCell{})
+		}
+	}
+	return rows
+}
+
+// attr returns the value of an attribute by local name, whatever its namespace
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// readZipPart reads one file of an archive, up to officePartMaxBytes
+func readZipPart(archive *zip.Reader, name string) ([]byte, error) {
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(io.LimitReader(rc, officePartMaxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > officePartMaxBytes {
+			return nil, fmt.Errorf("%s is larger than %d bytes", name, officePartMaxBytes)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// docxDocument wraps body XML in a word/document.xml part
+func docxDocument(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+		body + `</w:body></w:document>`
+}
+
+// docxPara is a paragraph in the given style, or in the default one when
+// style is empty
+func docxPara(style, text string) string {
+	props := ""
+	if style != "" {
+		props = `<w:pPr><w:pStyle w:val="` + style + `"/><w:tabs><w:tab w:val="left" w:pos="720"/></w:tabs></w:pPr>`
+	}
+	return `<w:p>` + props + `<w:r><w:t>` + text + `</w:t></w:r></w:p>`
+}
+
+func TestExtractDOCX(t *testing.T) {
+	pageBreak := `<w:p><w:r><w:br w:type="page"/></w:r></w:p>`
+	rendered := `<w:p><w:r><w:lastRenderedPageBreak/><w:t>Second page</w:t></w:r></w:p>`
+	table := `<w:tbl>` +
+		`<w:tr><w:tc>` + docxPara("", "Item") + `</w:tc><w:tc>` + docxPara("", "Qty") + `</w:tc></w:tr>` +
+		`<w:tr><w:tc>` + docxPara("", "Pipes") + `<w:tbl><w:tr><w:tc>` + docxPara("", "DI K9") + `</w:tc></w:tr></w:tbl></w:tc></w:tr>` +
+		`</w:tbl>`
+
+	tests := []struct {
+		name    string
+		body    string
+		pages   []string
+		outline []OutlineEntry
+		tables  map[int]int
+	}{
+		{
+			name: "headings",
+			body: docxPara("Title", "Tender Document") + docxPara("Heading1", "Section 1") +
+				docxPara("Heading 2", "1.1 Scope") + docxPara("", "Body text.") + docxPara("Heading1", ""),
+			pages: []string{"Tender Document\nSection 1\n1.1 Scope\nBody text.\n"},
+			outline: []OutlineEntry{
+				{Title: "Tender Document", Level: 1, Page: 1},
+				{Title: "Section 1", Level: 1, Page: 1},
+				{Title: "1.1 Scope", Level: 2, Page: 1},
+			},
+		},
+		{
+			name:  "explicit page breaks",
+			body:  docxPara("", "First page") + pageBreak + docxPara("Heading1", "Annexure") + docxPara("", "Second page"),
+			pages: []string{"First page\n\n", "Annexure\nSecond page\n"},
+			outline: []OutlineEntry{
+				{Title: "Annexure", Level: 1, Page: 2},
+			},
+		},
+		{
+			name:  "rendered breaks win over explicit ones",
+			body:  docxPara("", "First page") + pageBreak + rendered,
+			pages: []string{"First page\n", "Second page\n"},
+		},
+		{
+			name:   "table with merged and nested cells",
+			body:   docxPara("", "Schedule") + table,
+			pages:  []string{"Schedule\n| Item | Qty |\n| --- | --- |\n| Pipes DI K9 |  |\n"},
+			tables: map[int]int{1: 1},
+		},
+		{
+			name:  "no breaks",
+			body:  docxPara("", strings.Repeat("a", docxPageChars-100)) + docxPara("", strings.Repeat("b", 200)),
+			pages: []string{strings.Repeat("a", docxPageChars-100) + "\n", strings.Repeat("b", 200) + "\n"},
+		},
+	}
+	for _, tt := range tests {
+		data := buildZip(t, zipEntry{"word/document.xml", []byte(docxDocument(tt.body))})
+		pages, outline, err := ExtractDOCX(data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		var texts []string
+		tables := make(map[int]int)
+		for i, page := range pages {
+			if page.Number != i+1 {
+				t.Errorf("%s: page %d is numbered %d", tt.name, i+1, page.Number)
+			}
+			texts = append(texts, page.Text)
+			for _, table := range page.Tables {
+				tables[table.Page]++
+			}
+		}
+		if !reflect.DeepEqual(texts, tt.pages) {
+			t.Errorf("%s: pages = %q, want %q", tt.name, texts, tt.pages)
+		}
+		if !reflect.DeepEqual(outline, tt.outline) {
+			t.Errorf("%s: outline = %+v, want %+v", tt.name, outline, tt.outline)
+		}
+		if tt.tables == nil {
+			tt.tables = map[int]int{}
+		}
+		if !reflect.DeepEqual(tables, tt.tables) {
+			t.Errorf("%s: tables per page = %v, want %v", tt.name, tables, tt.tables)
+		}
+	}
+}
+
+func TestExtractDOCXErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"not a zip", []byte("plain text"), "failed to open DOCX file"},
+		{"no body", buildZip(t, zipEntry{"word/styles.xml", []byte("<styles/>")}), "word/document.xml not found"},
+		{"bad xml", buildZip(t, zipEntry{"word/document.xml", []byte("<w:document><w:body>")}), "failed to parse DOCX body"},
+	}
+	for _, tt := range tests {
+		_, _, err := ExtractDOCX(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	updateGolden = flag.Bool("update", false, "rewrite the extractor golden files from the current output")
+	recordGolden = flag.Bool("record", false, "record the extractor fixtures against Gemini (needs GEMINI_API_KEY)")
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// The response cache is process-wide and would hide calls from a recording
+	os.Setenv("TENDERIQ_LLM_CACHE_MAX_MB", "0")
+	os.Exit(m.Run())
+}
+
+// goldenService builds the service for a golden test case. With -record the
+// calls go to Gemini and are written to the case's fixture; otherwise they
+// are replayed from it.
+func goldenService(t *testing.T, dir string) *GeminiService {
+	t.Helper()
+
+	// Small budgets force the chunked paths, so the merge functions run
+	t.Setenv("TENDERIQ_SINGLE_CALL_MAX_TOKENS", "1")
+	t.Setenv("TENDERIQ_CHUNK_MAX_TOKENS", "250")
+	t.Setenv("TENDERIQ_CHUNK_OVERLAP_PAGES", "0")
+	t.Setenv("TENDERIQ_LLM_MAX_ATTEMPTS", "1")
+	for _, extractor := range []string{"DEFAULT", ExtractorSummary, ExtractorSOW, ExtractorSections} {
+		t.Setenv("TENDERIQ_ROUTE_"+strings.ToUpper(extractor), "")
+	}
+
+	fixture := filepath.Join(dir, "fixture.json")
+	if *recordGolden {
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			t.Fatal("-record needs GEMINI_API_KEY")
+		}
+		client, err := NewGeminiLLMClient(context.Background(), apiKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(fixture)
+		t.Setenv("TENDERIQ_LLM_RECORD", fixture)
+		service := NewGeminiServiceWithClient(client)
+		t.Cleanup(service.Close)
+		return service
+	}
+
+	t.Setenv("TENDERIQ_LLM_RECORD", "")
+	replay, err := LoadReplayLLMClient(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGeminiServiceWithClient(replay)
+}
+
+// compareGolden checks result against a golden file, or rewrites it with
+// -update and -record
+func compareGolden(t *testing.T, path string, result interface{}) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	if *updateGolden || *recordGolden {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+		for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+			var g, w string
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(wantLines) {
+				w = wantLines[i]
+			}
+			if g != w {
+				t.Fatalf("%s differs at line %d:\n got: %s\nwant: %s\nrun go test -run %s -update if the change is intended", path, i+1, g, w, t.Name())
+			}
+		}
+	}
+}
+
+func readGoldenInput(t *testing.T, dir string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, "input.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTenderSummaryGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "golden", "tender_summary")
+	service := goldenService(t, dir)
+	extractor := NewTenderSummaryExtractor(service, nil)
+
+	pages := service.extractTextByPage(readGoldenInput(t, dir))
+	result, err := extractor.ExtractTenderSummaryFromPages(context.Background(), pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Mode != "chunked" {
+		t.Errorf("mode = %s, want chunked", result.Mode)
+	}
+	compareGolden(t, filepath.Join(dir, "golden.json"), result)
+}
+
+func TestScopeOfWorkGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "golden", "scope_of_work")
+	service := goldenService(t, dir)
+	extractor := NewSOWExtractor(service)
+
+	pages := service.extractTextByPage(readGoldenInput(t, dir))
+	result, err := extractor.ExtractSOWFromPages(context.Background(), pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, filepath.Join(dir, "golden.json"), result)
+
+	// The programmatic merge is the fallback when model aggregation fails;
+	// check it on the same chunks whichever path the recording took
+	compareGolden(t, filepath.Join(dir, "programmatic_merge.json"), extractor.programmaticMerge(result.ChunkParsedList))
+}
+
+func TestSectionwiseGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "golden", "sectionwise")
+	service := goldenService(t, dir)
+
+	result, err := service.ExtractSectionwiseFromText(context.Background(), readGoldenInput(t, dir), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, filepath.Join(dir, "golden.json"), result)
+}
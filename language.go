@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Language of a page or paragraph, by script: Devanagari text is taken as
+// Hindi and Latin text as English
+const (
+	LanguageEnglish = "en"
+	LanguageHindi   = "hi"
+	LanguageMixed   = "mixed"
+)
+
+// Language policies: which language's text the extractors prompt with.
+// Bilingual tenders repeat the English text in Hindi, which doubles the
+// prompt without adding information.
+const (
+	LanguagePolicyEnglish = "en"
+	LanguagePolicyHindi   = "hi"
+	LanguagePolicyBoth    = "both"
+)
+
+const (
+	// Text with at least this share of its letters in one script is in that
+	// script's language; anything less is mixed
+	languageDominantShare = 0.8
+	// A policy is ignored for a document with less than this share of its
+	// letters in the policy's language, so a Hindi-only tender read with the
+	// English policy is not emptied
+	languagePolicyMinShare = 0.1
+)
+
+// scriptLetters counts the Devanagari and Latin letters of text. Digits and
+// punctuation of either script are not counted.
+func scriptLetters(text string) (devanagari, latin int) {
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Devanagari, r) && (unicode.IsLetter(r) || unicode.IsMark(r)):
+			devanagari++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	return devanagari, latin
+}
+
+// detectLanguage tags text as English, Hindi or mixed, or "" when it has no
+// letters of either script
+func detectLanguage(text string) string {
+	return languageOf(scriptLetters(text))
+}
+
+func languageOf(devanagari, latin int) string {
+	total := devanagari + latin
+	switch {
+	case total == 0:
+		return ""
+	case float64(latin) >= languageDominantShare*float64(total):
+		return LanguageEnglish
+	case float64(devanagari) >= languageDominantShare*float64(total):
+		return LanguageHindi
+	}
+	return LanguageMixed
+}
+
+// tagPageLanguages sets the language of each page from its text
+func tagPageLanguages(pages []PageText) {
+	for i := range pages {
+		pages[i].Language = detectLanguage(pages[i].TextWithTables())
+	}
+}
+
+// filterLanguage keeps the text of one language. Paragraphs in a single
+// language are kept or dropped whole; in mixed paragraphs each line is
+// judged on its own. Text without letters, such as figures, and markdown
+// tables are kept.
+func filterLanguage(text, language string) string {
+	paragraphs := strings.Split(text, "\n\n")
+	kept := make([]string, 0, len(paragraphs))
+	for _, paragraph := range paragraphs {
+		if strings.HasPrefix(strings.TrimSpace(paragraph), "|") {
+			kept = append(kept, paragraph)
+			continue
+		}
+		switch detectLanguage(paragraph) {
+		case "", language:
+			kept = append(kept, paragraph)
+		case LanguageMixed:
+			var lines []string
+			for _, line := range strings.Split(paragraph, "\n") {
+				if lang := detectLanguage(line); lang == "" || lang == language || lang == LanguageMixed {
+					lines = append(lines, line)
+				}
+			}
+			if len(lines) > 0 {
+				kept = append(kept, strings.Join(lines, "\n"))
+			}
+		}
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// PageLanguageMetadata summarises the languages of pages for document metadata
+func PageLanguageMetadata(pages []PageText) map[string]interface{} {
+	languages := make([]string, len(pages))
+	counts := map[string]int{LanguageEnglish: 0, LanguageHindi: 0, LanguageMixed: 0}
+	for i, page := range pages {
+		languages[i] = page.Language
+		if page.Language != "" {
+			counts[page.Language]++
+		}
+	}
+	return map[string]interface{}{
+		"page_languages": languages,
+		"language_pages": counts,
+	}
+}
+
+// languagePolicyFor returns the policy to apply to pages: policy itself, or
+// both when the pages have too little text in its language
+func languagePolicyFor(pages []PageText, policy string) string {
+	if policy == "" || policy == LanguagePolicyBoth {
+		return LanguagePolicyBoth
+	}
+	devanagari, latin := 0, 0
+	for _, page := range pages {
+		d, l := scriptLetters(page.TextWithTables())
+		devanagari += d
+		latin += l
+	}
+	wanted := latin
+	if policy == LanguagePolicyHindi {
+		wanted = devanagari
+	}
+	if total := devanagari + latin; total > 0 && float64(wanted) < languagePolicyMinShare*float64(total) {
+		log.Printf("Warning: document has little %s text, ignoring the %q language policy", policy, policy)
+		return LanguagePolicyBoth
+	}
+	return policy
+}
+
+func validLanguagePolicy(policy string) bool {
+	switch policy {
+	case LanguagePolicyEnglish, LanguagePolicyHindi, LanguagePolicyBoth:
+		return true
+	}
+	return false
+}
+
+// languagePolicyRequested reads the language query parameter, falling back
+// to TENDERIQ_LANGUAGE_POLICY and then both
+func languagePolicyRequested(c echo.Context) (string, error) {
+	policy := c.QueryParam("language")
+	if policy == "" {
+		policy = os.Getenv("TENDERIQ_LANGUAGE_POLICY")
+	}
+	if policy == "" {
+		return LanguagePolicyBoth, nil
+	}
+	policy = strings.ToLower(policy)
+	if !validLanguagePolicy(policy) {
+		return "", fmt.Errorf("invalid language %q: use en, hi or both", policy)
+	}
+	return policy, nil
+}
+
+type languagePolicyKey struct{}
+
+// WithLanguagePolicy asks the extractors run with ctx to prompt with the
+// text of one language
+func WithLanguagePolicy(ctx context.Context, policy string) context.Context {
+	return context.WithValue(ctx, languagePolicyKey{}, policy)
+}
+
+func languagePolicyFrom(ctx context.Context) string {
+	policy, _ := ctx.Value(languagePolicyKey{}).(string)
+	if policy == "" {
+		return LanguagePolicyBoth
+	}
+	return policy
+}
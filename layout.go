@@ -0,0 +1,143 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gen2brain/go-fitz"
+)
+
+// BBox is a rectangle on a page in PDF points, from the top left corner
+type BBox struct {
+	X0 float64 `json:"x0"`
+	Y0 float64 `json:"y0"`
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+}
+
+// Union returns the smallest box containing b and o
+func (b BBox) Union(o BBox) BBox {
+	if b == (BBox{}) {
+		return o
+	}
+	if o == (BBox{}) {
+		return b
+	}
+	if o.X0 < b.X0 {
+		b.X0 = o.X0
+	}
+	if o.Y0 < b.Y0 {
+		b.Y0 = o.Y0
+	}
+	if o.X1 > b.X1 {
+		b.X1 = o.X1
+	}
+	if o.Y1 > b.Y1 {
+		b.Y1 = o.Y1
+	}
+	return b
+}
+
+// TextLine is one positioned line of text on a page
+type TextLine struct {
+	Page     int     `json:"page"`
+	Text     string  `json:"text"`
+	BBox     BBox    `json:"bbox"`
+	FontSize float64 `json:"font_size"`
+	Bold     bool    `json:"bold,omitempty"`
+}
+
+// Average glyph width as a share of the font size, used to estimate line
+// widths when the HTML output does not give them
+const averageGlyphWidth = 0.5
+
+var (
+	htmlParagraphRe = regexp.MustCompile(`(?s)<p\s+style="([^"]*)"[^>]*>(.*?)</p>`)
+	htmlBreakRe     = regexp.MustCompile(`<br\s*/?>`)
+	htmlTagRe       = regexp.MustCompile(`<[^>]+>`)
+	htmlBoldRe      = regexp.MustCompile(`(?i)<b>|font-weight:\s*(bold|[6-9]00)|font-family:[^;"]*bold`)
+	htmlFontSizeRe  = regexp.MustCompile(`font-size:\s*([\d.]+)pt`)
+)
+
+// pageLines reads the positioned lines of a page from fitz's HTML output,
+// which places every line absolutely in points
+func pageLines(doc *fitz.Document, pageNum int) ([]TextLine, error) {
+	out, err := doc.HTML(pageNum, false)
+	if err != nil {
+		return nil, err
+	}
+	return parsePageHTML(pageNum+1, out), nil
+}
+
+// parsePageHTML turns the <p style="top:..pt;left:..pt;..."> elements of a
+// page into lines sorted top to bottom, left to right
+func parsePageHTML(page int, out string) []TextLine {
+	var lines []TextLine
+	for _, match := range htmlParagraphRe.FindAllStringSubmatch(out, -1) {
+		style, body := match[1], match[2]
+		top, okTop := cssPoints(style, "top")
+		left, okLeft := cssPoints(style, "left")
+		if !okTop || !okLeft {
+			continue
+		}
+
+		fontSize := 0.0
+		if m := htmlFontSizeRe.FindStringSubmatch(body); m != nil {
+			fontSize, _ = strconv.ParseFloat(m[1], 64)
+		}
+		height, ok := cssPoints(style, "line-height")
+		if !ok {
+			height, ok = cssPoints(style, "height")
+		}
+		if !ok || height <= 0 {
+			height = fontSize
+		}
+		width, hasWidth := cssPoints(style, "width")
+		bold := htmlBoldRe.MatchString(body)
+
+		// Some versions put several lines of a block in one element
+		for i, part := range htmlBreakRe.Split(body, -1) {
+			text := strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(part, "")))
+			if text == "" {
+				continue
+			}
+			w := width
+			if !hasWidth {
+				w = float64(utf8.RuneCountInString(text)) * fontSize * averageGlyphWidth
+			}
+			y0 := top + float64(i)*height
+			lines = append(lines, TextLine{
+				Page:     page,
+				Text:     text,
+				BBox:     BBox{X0: left, Y0: y0, X1: left + w, Y1: y0 + height},
+				FontSize: fontSize,
+				Bold:     bold,
+			})
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].BBox.Y0 != lines[j].BBox.Y0 {
+			return lines[i].BBox.Y0 < lines[j].BBox.Y0
+		}
+		return lines[i].BBox.X0 < lines[j].BBox.X0
+	})
+	return lines
+}
+
+// cssPoints reads a "name:12.5pt" property from an inline style
+func cssPoints(style, name string) (float64, bool) {
+	for _, decl := range strings.Split(style, ";") {
+		key, value, ok := strings.Cut(decl, ":")
+		if !ok || strings.TrimSpace(key) != name {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "pt"), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Model names used across the TenderIQ extractors
+const (
+	ModelGeminiPro   = "gemini-2.5-pro"
+	ModelGeminiFlash = "gemini-2.5-flash"
+)
+
+// Normalised finish reasons reported by every LLMClient implementation
+const (
+	FinishReasonStop      = "stop"
+	FinishReasonMaxTokens = "max_tokens"
+	FinishReasonSafety    = "safety"
+	FinishReasonOther     = "other"
+)
+
+// LLMClient is the provider-agnostic interface every model call goes through.
+// Implementations exist for Gemini, OpenAI and an in-process fake for tests.
+type LLMClient interface {
+	// Provider returns a short provider name such as "gemini" or "openai"
+	Provider() string
+	// Generate runs a single text generation against req.Model
+	Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+	// CountTokens returns the number of input tokens text uses for model
+	CountTokens(ctx context.Context, model string, text string) (int, error)
+}
+
+// GenerationConfig holds the sampling parameters for a model call.
+// Zero values mean "use the provider default". Temperature is a pointer,
+// nil for the default, so that an explicit 0 is still sent.
+type GenerationConfig struct {
+	Temperature     *float32 `json:"temperature,omitempty"`
+	TopP            float32  `json:"top_p,omitempty"`
+	TopK            int32    `json:"top_k,omitempty"`
+	MaxOutputTokens int32    `json:"max_output_tokens,omitempty"`
+}
+
+// temperature returns t as a GenerationConfig temperature
+func temperature(t float32) *float32 {
+	return &t
+}
+
+// Roles of the earlier turns in LLMRequest.History
+const (
+	MessageRoleUser      = "user"
+	MessageRoleAssistant = "assistant"
+)
+
+// LLMMessage is one earlier turn of a multi-turn request
+type LLMMessage struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// LLMImage is an image sent along with the prompt to a multimodal model
+type LLMImage struct {
+	MIMEType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// Tokens a model is assumed to spend per image when usage is estimated
+const imageTokenEstimate = 258
+
+// LLMRequest describes one model call. When ResponseSchema is set the
+// backend must constrain the output to JSON matching the schema. History
+// holds earlier turns, oldest first, that Prompt follows on from; Images
+// are sent together with Prompt.
+type LLMRequest struct {
+	Model          string           `json:"model"`
+	System         string           `json:"system,omitempty"`
+	History        []LLMMessage     `json:"history,omitempty"`
+	Prompt         string           `json:"prompt"`
+	Images         []LLMImage       `json:"images,omitempty"`
+	Config         GenerationConfig `json:"config"`
+	ResponseSchema *Schema          `json:"response_schema,omitempty"`
+}
+
+// LLMUsage is the usage metadata reported for a single call
+type LLMUsage struct {
+	PromptTokens int           `json:"prompt_tokens"`
+	OutputTokens int           `json:"output_tokens"`
+	Latency      time.Duration `json:"latency"`
+	// Estimated is set when the provider did not report token counts
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// LLMResponse is the normalised result of a model call
+type LLMResponse struct {
+	Text         string   `json:"text"`
+	Model        string   `json:"model"`
+	FinishReason string   `json:"finish_reason"`
+	Usage        LLMUsage `json:"usage"`
+	// Cached is set when the response was served from the LLM response cache
+	Cached bool `json:"cached,omitempty"`
+}
+
+// estimateTokens gives a rough token count (~4 characters per token) for
+// providers that do not report usage.
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len(text) + 3) / 4
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Default cache location and limits. Set TENDERIQ_LLM_CACHE_MAX_MB=0 to disable.
+const (
+	defaultLLMCacheDir      = "llm_cache"
+	defaultLLMCacheTTLHours = 168
+	defaultLLMCacheMaxMB    = 512
+)
+
+// LLMCacheStats counts cache lookups for one API request
+type LLMCacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Bypassed bool  `json:"bypassed,omitempty"`
+}
+
+type llmCacheContextKey struct{}
+
+type llmCacheOptions struct {
+	bypass bool
+	stats  *LLMCacheStats
+}
+
+// WithLLMCache attaches per-request cache options to ctx. With bypass set,
+// cached responses are ignored but fresh responses are still stored.
+func WithLLMCache(ctx context.Context, bypass bool) (context.Context, *LLMCacheStats) {
+	stats := &LLMCacheStats{Bypassed: bypass}
+	return context.WithValue(ctx, llmCacheContextKey{}, &llmCacheOptions{bypass: bypass, stats: stats}), stats
+}
+
+// llmCacheContext builds the cache context for an HTTP request on top of the
+// request's own context, so model calls stop when the client goes away. The
+// cache is bypassed with ?no_cache=true or a "Cache-Control: no-cache" header.
+func llmCacheContext(c echo.Context) (context.Context, *LLMCacheStats) {
+	bypass, _ := strconv.ParseBool(c.QueryParam("no_cache"))
+	if c.Request().Header.Get("Cache-Control") == "no-cache" {
+		bypass = true
+	}
+	return WithLLMCache(c.Request().Context(), bypass)
+}
+
+func llmCacheOptionsFrom(ctx context.Context) *llmCacheOptions {
+	if opts, ok := ctx.Value(llmCacheContextKey{}).(*llmCacheOptions); ok {
+		return opts
+	}
+	return &llmCacheOptions{}
+}
+
+// llmCacheEntry is the on-disk form of a cached response
+type llmCacheEntry struct {
+	CreatedAt time.Time   `json:"created_at"`
+	Response  LLMResponse `json:"response"`
+}
+
+// LLMCache is a content-addressed response store on local disk. Entries
+// expire after ttl; once the directory grows past maxBytes the least
+// recently written entries are evicted.
+type LLMCache struct {
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+func NewLLMCache(dir string, ttl time.Duration, maxBytes int64) (*LLMCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create LLM cache directory: %w", err)
+	}
+
+	cache := &LLMCache{dir: dir, ttl: ttl, maxBytes: maxBytes}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if info, err := d.Info(); err == nil {
+			cache.size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan LLM cache directory: %w", err)
+	}
+
+	return cache, nil
+}
+
+var (
+	sharedLLMCacheOnce sync.Once
+	sharedLLMCache     *LLMCache
+)
+
+// llmCacheFromEnv returns the process-wide cache configured from the
+// environment, or nil when caching is disabled
+func llmCacheFromEnv() *LLMCache {
+	sharedLLMCacheOnce.Do(func() {
+		maxMB := getEnvInt("TENDERIQ_LLM_CACHE_MAX_MB", defaultLLMCacheMaxMB)
+		if maxMB <= 0 {
+			log.Println("LLM response cache disabled")
+			return
+		}
+
+		dir := os.Getenv("TENDERIQ_LLM_CACHE_DIR")
+		if dir == "" {
+			dir = defaultLLMCacheDir
+		}
+		ttl := time.Duration(getEnvInt("TENDERIQ_LLM_CACHE_TTL_HOURS", defaultLLMCacheTTLHours)) * time.Hour
+
+		cache, err := NewLLMCache(dir, ttl, int64(maxMB)<<20)
+		if err != nil {
+			log.Printf("Warning: LLM response cache disabled: %v", err)
+			return
+		}
+		log.Printf("LLM response cache at %s (ttl %v, max %d MB)", dir, ttl, maxMB)
+		sharedLLMCache = cache
+	})
+	return sharedLLMCache
+}
+
+// Key hashes everything that determines a response: provider, model,
+// generation config, response schema and prompt
+func (c *LLMCache) Key(provider string, req LLMRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(append([]byte(provider+"\x00"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *LLMCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// Get returns a cached response, dropping it if it has expired
+func (c *LLMCache) Get(key string) (*LLMResponse, bool) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry llmCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("Warning: dropping unreadable LLM cache entry %s: %v", key, err)
+		c.remove(path)
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(entry.CreatedAt) > c.ttl {
+		c.remove(path)
+		return nil, false
+	}
+
+	return &entry.Response, true
+}
+
+// Put stores a response and evicts old entries if the cache is over its limit
+func (c *LLMCache) Put(key string, resp *LLMResponse) {
+	data, err := json.Marshal(llmCacheEntry{CreatedAt: time.Now(), Response: *resp})
+	if err != nil {
+		return
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Warning: failed to write LLM cache entry: %v", err)
+		return
+	}
+
+	// Write to a temp file first so concurrent readers never see a partial entry
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		log.Printf("Warning: failed to write LLM cache entry: %v", err)
+		return
+	}
+	tmp := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Printf("Warning: failed to write LLM cache entry: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		log.Printf("Warning: failed to write LLM cache entry: %v", err)
+		return
+	}
+
+	c.size += int64(len(data)) - previous
+	if c.size > c.maxBytes {
+		c.evict()
+	}
+}
+
+func (c *LLMCache) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if info, err := os.Stat(path); err == nil {
+		if os.Remove(path) == nil {
+			c.size -= info.Size()
+		}
+	}
+}
+
+// evict removes the oldest entries until the cache is at 90% of its limit;
+// callers hold c.mu
+func (c *LLMCache) evict() {
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []cacheFile
+	var total int64
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	target := c.maxBytes / 10 * 9
+	removed := 0
+	for _, file := range files {
+		if total <= target {
+			break
+		}
+		if os.Remove(file.path) == nil {
+			total -= file.size
+			removed++
+		}
+	}
+
+	c.size = total
+	log.Printf("LLM cache over limit, evicted %d entries (%d bytes remain)", removed, total)
+}
+
+// CachedLLMClient serves repeated requests from an LLMCache. Only complete
+// responses are stored; errors and truncated or blocked outputs always go
+// to the backend again.
+type CachedLLMClient struct {
+	next  LLMClient
+	cache *LLMCache
+}
+
+func NewCachedLLMClient(next LLMClient, cache *LLMCache) *CachedLLMClient {
+	return &CachedLLMClient{next: next, cache: cache}
+}
+
+func (c *CachedLLMClient) Provider() string {
+	return c.next.Provider()
+}
+
+func (c *CachedLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if c.cache == nil {
+		return c.next.Generate(ctx, req)
+	}
+
+	opts := llmCacheOptionsFrom(ctx)
+	key := c.cache.Key(c.next.Provider(), req)
+
+	if !opts.bypass {
+		if cached, ok := c.cache.Get(key); ok {
+			if opts.stats != nil {
+				atomic.AddInt64(&opts.stats.Hits, 1)
+			}
+			cached.Cached = true
+			cached.Usage.Latency = 0
+			return cached, nil
+		}
+	}
+	if opts.stats != nil {
+		atomic.AddInt64(&opts.stats.Misses, 1)
+	}
+
+	resp, err := c.next.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.FinishReason == FinishReasonStop {
+		c.cache.Put(key, resp)
+	}
+	return resp, nil
+}
+
+func (c *CachedLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return c.next.CountTokens(ctx, model, text)
+}
+
+func (c *CachedLLMClient) Close() {
+	if closer, ok := c.next.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
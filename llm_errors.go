@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/sashabaranov/go-openai"
+	"google.golang.org/api/googleapi"
+)
+
+// Error classes reported for failed model calls
+const (
+	ErrorClassRateLimited    = "rate_limited"
+	ErrorClassUnavailable    = "unavailable"
+	ErrorClassTimeout        = "timeout"
+	ErrorClassCanceled       = "canceled"
+	ErrorClassSafety         = "safety_blocked"
+	ErrorClassTruncated      = "truncated"
+	ErrorClassSchema         = "schema_invalid"
+	ErrorClassAuth           = "auth"
+	ErrorClassInvalidRequest = "invalid_request"
+	ErrorClassCircuitOpen    = "circuit_open"
+	ErrorClassUnknown        = "unknown"
+)
+
+// LLMError is a classified model call failure
+type LLMError struct {
+	Class string
+	Model string
+	Err   error
+}
+
+func (e *LLMError) Error() string {
+	if e.Model != "" {
+		return fmt.Sprintf("%s (%s): %v", e.Model, e.Class, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Class, e.Err)
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the same call may succeed if sent again
+func (e *LLMError) Retryable() bool {
+	switch e.Class {
+	case ErrorClassRateLimited, ErrorClassUnavailable, ErrorClassTimeout:
+		return true
+	}
+	return false
+}
+
+// ClassifyLLMError maps a provider, transport or validation error onto an
+// error class. Errors already wrapped in an LLMError keep their class.
+func ClassifyLLMError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return llmErr.Class
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	}
+
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return ErrorClassSafety
+	}
+
+	var validationErr *SchemaValidationError
+	if errors.As(err, &validationErr) {
+		return ErrorClassSchema
+	}
+
+	// HTTP status from the Google and OpenAI SDKs
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return classifyHTTPStatus(googleErr.Code)
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return classifyHTTPStatus(apiErr.HTTPStatusCode)
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return classifyHTTPStatus(requestErr.HTTPStatusCode)
+	}
+	var httpCoder interface{ HTTPCode() int }
+	if errors.As(err, &httpCoder) && httpCoder.HTTPCode() > 0 {
+		return classifyHTTPStatus(httpCoder.HTTPCode())
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassUnavailable
+	}
+
+	// gRPC errors from the Gemini SDK only carry their status in the message
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "resourceexhausted"), strings.Contains(msg, "resource_exhausted"),
+		strings.Contains(msg, "rate limit"), strings.Contains(msg, "quota"):
+		return ErrorClassRateLimited
+	case strings.Contains(msg, "unavailable"), strings.Contains(msg, "internal error"),
+		strings.Contains(msg, "connection reset"), strings.Contains(msg, "unexpected eof"):
+		return ErrorClassUnavailable
+	case strings.Contains(msg, "deadlineexceeded"), strings.Contains(msg, "deadline_exceeded"):
+		return ErrorClassTimeout
+	case strings.Contains(msg, "permissiondenied"), strings.Contains(msg, "unauthenticated"),
+		strings.Contains(msg, "api key"):
+		return ErrorClassAuth
+	case strings.Contains(msg, "invalidargument"), strings.Contains(msg, "invalid_argument"):
+		return ErrorClassInvalidRequest
+	}
+
+	return ErrorClassUnknown
+}
+
+func classifyHTTPStatus(code int) string {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return ErrorClassAuth
+	case code == http.StatusRequestTimeout, code == http.StatusGatewayTimeout:
+		return ErrorClassTimeout
+	case code >= 500:
+		return ErrorClassUnavailable
+	case code >= 400:
+		return ErrorClassInvalidRequest
+	}
+	return ErrorClassUnknown
+}
+
+// classifyLLMError wraps err in an LLMError for model unless it already is one
+func classifyLLMError(model string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return err
+	}
+	return &LLMError{Class: ClassifyLLMError(err), Model: model, Err: err}
+}
+
+// shouldFallbackModel reports whether retrying with another model could
+// help. Safety blocks, bad requests and auth failures would fail the same way.
+func shouldFallbackModel(err error) bool {
+	switch ClassifyLLMError(err) {
+	case ErrorClassSafety, ErrorClassInvalidRequest, ErrorClassAuth, ErrorClassCanceled:
+		return false
+	}
+	return true
+}
+
+// llmErrorStatus picks the HTTP status for a failed model call. A call
+// cancelled by the request's own deadline is a gateway timeout too.
+func llmErrorStatus(err error) int {
+	switch ClassifyLLMError(err) {
+	case ErrorClassRateLimited, ErrorClassUnavailable, ErrorClassCircuitOpen:
+		return http.StatusServiceUnavailable
+	case ErrorClassTimeout:
+		return http.StatusGatewayTimeout
+	case ErrorClassCanceled:
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout
+		}
+	case ErrorClassSafety:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// llmErrorBody is the JSON error body for a failed model call, including its class
+func llmErrorBody(message string, err error) map[string]string {
+	return map[string]string{
+		"error":       message,
+		"error_class": ClassifyLLMError(err),
+	}
+}
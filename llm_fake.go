@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeReply is one scripted answer of the fake backend
+type FakeReply struct {
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// FakeRule matches requests by model and prompt substring. Replies are
+// handed out in order; the last reply repeats once the script runs out.
+type FakeRule struct {
+	Model    string      `json:"model,omitempty"`
+	Contains string      `json:"contains,omitempty"`
+	Replies  []FakeReply `json:"replies"`
+	used     int
+}
+
+// FakeLLMClient is a deterministic, in-process LLMClient used to run the
+// TenderIQ pipeline offline
+type FakeLLMClient struct {
+	mu      sync.Mutex
+	rules   []*FakeRule
+	calls   []LLMRequest
+	Default *FakeReply
+}
+
+func NewFakeLLMClient() *FakeLLMClient {
+	return &FakeLLMClient{}
+}
+
+// LoadFakeLLMScript builds a fake client from a JSON file containing a list of rules
+func LoadFakeLLMScript(path string) (*FakeLLMClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fake LLM script: %w", err)
+	}
+
+	var rules []*FakeRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse fake LLM script: %w", err)
+	}
+
+	fake := NewFakeLLMClient()
+	fake.rules = rules
+	return fake, nil
+}
+
+// On adds a rule. An empty model or substring matches everything.
+func (f *FakeLLMClient) On(model, contains string, replies ...FakeReply) *FakeLLMClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rules = append(f.rules, &FakeRule{
+		Model:    model,
+		Contains: contains,
+		Replies:  replies,
+	})
+	return f
+}
+
+// Calls returns a copy of every request the fake has received
+func (f *FakeLLMClient) Calls() []LLMRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	calls := make([]LLMRequest, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
+
+func (f *FakeLLMClient) Provider() string {
+	return "fake"
+}
+
+func (f *FakeLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, req)
+	reply := f.match(req)
+	f.mu.Unlock()
+
+	if reply == nil {
+		return nil, fmt.Errorf("fake LLM: no scripted reply for model %s", req.Model)
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("%s", reply.Error)
+	}
+
+	finishReason := reply.FinishReason
+	if finishReason == "" {
+		finishReason = FinishReasonStop
+	}
+
+	return &LLMResponse{
+		Text:         reply.Text,
+		Model:        req.Model,
+		FinishReason: finishReason,
+		Usage: LLMUsage{
+			PromptTokens: estimateTokens(req.System + req.Prompt),
+			OutputTokens: estimateTokens(reply.Text),
+			Estimated:    true,
+		},
+	}, nil
+}
+
+func (f *FakeLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return estimateTokens(text), nil
+}
+
+// match returns the reply of the first matching rule; callers hold f.mu
+func (f *FakeLLMClient) match(req LLMRequest) *FakeReply {
+	for _, rule := range f.rules {
+		if rule.Model != "" && rule.Model != req.Model {
+			continue
+		}
+		if rule.Contains != "" && !strings.Contains(req.Prompt, rule.Contains) {
+			continue
+		}
+		if len(rule.Replies) == 0 {
+			continue
+		}
+
+		idx := rule.used
+		if idx >= len(rule.Replies) {
+			idx = len(rule.Replies) - 1
+		}
+		rule.used++
+		return &rule.Replies[idx]
+	}
+	return f.Default
+}
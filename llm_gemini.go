@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// GeminiLLMClient implements LLMClient on top of the Gemini SDK
+type GeminiLLMClient struct {
+	client *genai.Client
+}
+
+// NewGeminiLLMClient creates a Gemini-backed LLMClient
+func NewGeminiLLMClient(ctx context.Context, apiKey string) (*GeminiLLMClient, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return &GeminiLLMClient{client: client}, nil
+}
+
+func (g *GeminiLLMClient) Provider() string {
+	return "gemini"
+}
+
+// model builds a GenerativeModel configured for a single request
+func (g *GeminiLLMClient) model(name string, cfg GenerationConfig) *genai.GenerativeModel {
+	model := g.client.GenerativeModel(name)
+	if cfg.Temperature != nil {
+		model.SetTemperature(*cfg.Temperature)
+	}
+	if cfg.TopP > 0 {
+		model.SetTopP(cfg.TopP)
+	}
+	if cfg.TopK > 0 {
+		model.SetTopK(cfg.TopK)
+	}
+	if cfg.MaxOutputTokens > 0 {
+		model.SetMaxOutputTokens(cfg.MaxOutputTokens)
+	}
+	return model
+}
+
+func (g *GeminiLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if g.client == nil {
+		return nil, fmt.Errorf("gemini client not initialized")
+	}
+
+	// The SDK version we use has neither system instructions nor
+	// ResponseSchema in its GenerationConfig, so both are sent as a preamble
+	// of the first turn: the schema is enforced only by validating responses
+	// and the repair loop, not by the API.
+	var preamble string
+	if req.System != "" {
+		preamble = req.System + "\n\n"
+	}
+	if req.ResponseSchema != nil {
+		preamble += schemaInstruction(req.ResponseSchema) + "\n\n"
+	}
+
+	model := g.model(req.Model, req.Config)
+	start := time.Now()
+	var resp *genai.GenerateContentResponse
+	var err error
+	prompt := req.Prompt
+	var parts []genai.Part
+	for _, image := range req.Images {
+		parts = append(parts, genai.Blob{MIMEType: image.MIMEType, Data: image.Data})
+	}
+	if len(req.History) == 0 {
+		prompt = preamble + prompt
+		resp, err = model.GenerateContent(ctx, append(parts, genai.Text(prompt))...)
+	} else {
+		// Earlier turns go through a chat session; Gemini calls the assistant "model"
+		cs := model.StartChat()
+		var sent strings.Builder
+		for i, msg := range req.History {
+			role := "user"
+			if msg.Role == MessageRoleAssistant {
+				role = "model"
+			}
+			text := msg.Text
+			if i == 0 {
+				text = preamble + text
+			}
+			cs.History = append(cs.History, &genai.Content{Role: role, Parts: []genai.Part{genai.Text(text)}})
+			sent.WriteString(text)
+		}
+		resp, err = cs.SendMessage(ctx, append(parts, genai.Text(prompt))...)
+		prompt = sent.String() + prompt
+	}
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(start)
+
+	if resp == nil || len(resp.Candidates) == 0 {
+		return nil, fmt.Errorf("no candidates returned from Gemini")
+	}
+
+	candidate := resp.Candidates[0]
+	var text strings.Builder
+	if candidate.Content != nil {
+		for _, part := range candidate.Content.Parts {
+			if textPart, ok := part.(genai.Text); ok {
+				text.WriteString(string(textPart))
+			}
+		}
+	}
+
+	// Nor does the SDK return usage metadata, so token counts are estimated
+	// and the usage ledger keeps them apart from reported counts
+	return &LLMResponse{
+		Text:         text.String(),
+		Model:        req.Model,
+		FinishReason: geminiFinishReason(candidate.FinishReason),
+		Usage: LLMUsage{
+			PromptTokens: estimateTokens(prompt) + len(req.Images)*imageTokenEstimate,
+			OutputTokens: estimateTokens(text.String()),
+			Latency:      latency,
+			Estimated:    true,
+		},
+	}, nil
+}
+
+func (g *GeminiLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	if g.client == nil {
+		return 0, fmt.Errorf("gemini client not initialized")
+	}
+
+	resp, err := g.client.GenerativeModel(model).CountTokens(ctx, genai.Text(text))
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// geminiFinishReason maps the SDK finish reason onto our normalised values
+func geminiFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonStop, genai.FinishReasonUnspecified:
+		return FinishReasonStop
+	case genai.FinishReasonMaxTokens:
+		return FinishReasonMaxTokens
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return FinishReasonSafety
+	default:
+		return FinishReasonOther
+	}
+}
+
+func (g *GeminiLLMClient) Close() {
+	if g.client != nil {
+		g.client.Close()
+	}
+}
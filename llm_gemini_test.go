@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestTranscribePageSendsZeroTemperature(t *testing.T) {
+	t.Setenv("TENDERIQ_LLM_RECORD", "")
+	fake := &scriptedLLMClient{attempts: []func(ctx context.Context) (*LLMResponse, error){
+		func(ctx context.Context) (*LLMResponse, error) {
+			return &LLMResponse{Text: "page text"}, nil
+		},
+	}}
+	service := NewGeminiServiceWithClient(fake)
+	if _, err := service.TranscribePage(context.Background(), []byte("png"), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Fatalf("%d requests sent, want 1", len(fake.requests))
+	}
+
+	client := &GeminiLLMClient{client: &genai.Client{}}
+	model := client.model(fake.requests[0].Model, fake.requests[0].Config)
+	if model.Temperature == nil || *model.Temperature != 0 {
+		t.Errorf("transcription temperature = %v, want 0", model.Temperature)
+	}
+}
+
+func TestGeminiModelConfig(t *testing.T) {
+	client := &GeminiLLMClient{client: &genai.Client{}}
+	tests := []struct {
+		name        string
+		config      GenerationConfig
+		temperature *float32
+		maxTokens   *int32
+	}{
+		{"provider defaults", GenerationConfig{}, nil, nil},
+		{"zero temperature", GenerationConfig{Temperature: temperature(0)}, temperature(0), nil},
+		{"service defaults", GenerationConfig{Temperature: temperature(0.7), MaxOutputTokens: 8192}, temperature(0.7), genai.Ptr[int32](8192)},
+	}
+	for _, tt := range tests {
+		model := client.model("gemini-2.5-flash", tt.config)
+		if !equalPtr(model.Temperature, tt.temperature) {
+			t.Errorf("%s: temperature = %v, want %v", tt.name, model.Temperature, tt.temperature)
+		}
+		if !equalPtr(model.MaxOutputTokens, tt.maxTokens) {
+			t.Errorf("%s: max output tokens = %v, want %v", tt.name, model.MaxOutputTokens, tt.maxTokens)
+		}
+	}
+}
+
+// equalPtr reports whether a and b are both nil or point to equal values
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
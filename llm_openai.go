@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAILLMClient implements LLMClient on top of the OpenAI chat API or
+// any server that speaks it
+type OpenAILLMClient struct {
+	client   *openai.Client
+	provider string
+}
+
+// NewOpenAILLMClient creates an OpenAI-backed LLMClient
+func NewOpenAILLMClient(apiKey string) *OpenAILLMClient {
+	return &OpenAILLMClient{client: openai.NewClient(apiKey), provider: "openai"}
+}
+
+// NewOpenAICompatibleLLMClient creates an LLMClient for an OpenAI-compatible
+// server such as llama.cpp, vLLM or Ollama. Local servers usually ignore the
+// API key, so it may be empty.
+func NewOpenAICompatibleLLMClient(provider, baseURL, apiKey string) *OpenAILLMClient {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = strings.TrimRight(baseURL, "/")
+	return &OpenAILLMClient{client: openai.NewClientWithConfig(config), provider: provider}
+}
+
+func (o *OpenAILLMClient) Provider() string {
+	return o.provider
+}
+
+func (o *OpenAILLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if o.client == nil {
+		return nil, fmt.Errorf("OpenAI client not initialized")
+	}
+
+	system := req.System
+	var responseFormat *openai.ChatCompletionResponseFormat
+	if req.ResponseSchema != nil {
+		// JSON mode guarantees syntactically valid JSON; the schema itself
+		// travels in the system message. JSON mode only produces objects, so
+		// other schemas are wrapped in an object that generateStructured unwraps.
+		schema := req.ResponseSchema
+		if schema.Type != "object" {
+			schema = envelopeSchema(schema)
+		}
+		system = strings.TrimSpace(system + "\n\n" + schemaInstruction(schema))
+		responseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+	}
+
+	var messages []openai.ChatCompletionMessage
+	if system != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: system,
+		})
+	}
+	for _, msg := range req.History {
+		role := openai.ChatMessageRoleUser
+		if msg.Role == MessageRoleAssistant {
+			role = openai.ChatMessageRoleAssistant
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: msg.Text,
+		})
+	}
+	if len(req.Images) == 0 {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: req.Prompt,
+		})
+	} else {
+		// Images travel as data URLs next to the prompt text
+		parts := []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: req.Prompt}}
+		for _, image := range req.Images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL:    "data:" + image.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(image.Data),
+					Detail: openai.ImageURLDetailAuto,
+				},
+			})
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:         openai.ChatMessageRoleUser,
+			MultiContent: parts,
+		})
+	}
+
+	// The SDK omits a zero temperature, so an explicit 0 gets the API's default
+	var temp float32
+	if req.Config.Temperature != nil {
+		temp = *req.Config.Temperature
+	}
+
+	start := time.Now()
+	resp, err := o.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:          req.Model,
+		Messages:       messages,
+		MaxTokens:      int(req.Config.MaxOutputTokens),
+		Temperature:    temp,
+		TopP:           req.Config.TopP,
+		ResponseFormat: responseFormat,
+	})
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(start)
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no response choices returned from OpenAI")
+	}
+
+	choice := resp.Choices[0]
+	return &LLMResponse{
+		Text:         choice.Message.Content,
+		Model:        req.Model,
+		FinishReason: openAIFinishReason(choice.FinishReason),
+		Usage: LLMUsage{
+			PromptTokens: resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+			Latency:      latency,
+		},
+	}, nil
+}
+
+// CountTokens estimates the token count; the chat API has no counting endpoint
+func (o *OpenAILLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return estimateTokens(text), nil
+}
+
+// openAIFinishReason maps the API finish reason onto our normalised values
+func openAIFinishReason(reason openai.FinishReason) string {
+	switch reason {
+	case openai.FinishReasonStop, "":
+		return FinishReasonStop
+	case openai.FinishReasonLength:
+		return FinishReasonMaxTokens
+	case openai.FinishReasonContentFilter:
+		return FinishReasonSafety
+	default:
+		return FinishReasonOther
+	}
+}
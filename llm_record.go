@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LLMInteraction is one recorded model call
+type LLMInteraction struct {
+	Key      string       `json:"key"`
+	Mode     string       `json:"mode,omitempty"`
+	Request  LLMRequest   `json:"request"`
+	Response *LLMResponse `json:"response,omitempty"`
+	// Error and ErrorClass record a call that failed after retries
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// LLMFixture is the file a recording is saved to and replayed from. Token
+// counts are recorded as well so the planner chunks a document the same way
+// on replay.
+type LLMFixture struct {
+	Interactions []LLMInteraction `json:"interactions"`
+	TokenCounts  map[string]int   `json:"token_counts,omitempty"`
+}
+
+// LoadLLMFixture reads a recorded fixture file
+func LoadLLMFixture(path string) (*LLMFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM fixture: %w", err)
+	}
+
+	var fixture LLMFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// Save writes the fixture atomically
+func (f *LLMFixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Fixtures are checked in, so they get the usual file mode rather than
+	// CreateTemp's owner-only one
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// llmCallSite is where a model call is made: the extractor making it and,
+// for calls made per chunk, page or model, the index of that chunk, page or
+// model
+type llmCallSite struct {
+	extractor string
+	index     int
+}
+
+type llmCallSiteKey struct{}
+
+// WithLLMExtractor labels the model calls made with ctx with the extractor
+// making them. Recordings are keyed by the label rather than the request
+// content, so they still replay after a prompt is edited.
+func WithLLMExtractor(ctx context.Context, extractor string) context.Context {
+	return context.WithValue(ctx, llmCallSiteKey{}, llmCallSite{extractor: extractor})
+}
+
+// WithLLMCallIndex labels the model calls made with ctx as those for one
+// chunk, page or model of the extractor
+func WithLLMCallIndex(ctx context.Context, index int) context.Context {
+	site, _ := ctx.Value(llmCallSiteKey{}).(llmCallSite)
+	site.index = index
+	return context.WithValue(ctx, llmCallSiteKey{}, site)
+}
+
+// fixtureKey identifies a request independently of the provider that served
+// it: by extractor, usage mode and index, e.g. "sections/chunk/2". Calls
+// made outside an extractor are identified by their full content.
+func fixtureKey(ctx context.Context, req LLMRequest) string {
+	if site, ok := ctx.Value(llmCallSiteKey{}).(llmCallSite); ok && site.extractor != "" {
+		mode := usageModeFrom(ctx)
+		if mode == "" {
+			mode = "call"
+		}
+		return fmt.Sprintf("%s/%s/%d", site.extractor, mode, site.index)
+	}
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// promptDiff describes where a prompt first differs from a recorded one
+func promptDiff(recorded, prompt string) string {
+	recordedLines, lines := strings.Split(recorded, "\n"), strings.Split(prompt, "\n")
+	for i := 0; i < len(recordedLines) || i < len(lines); i++ {
+		var r, l string
+		if i < len(recordedLines) {
+			r = recordedLines[i]
+		}
+		if i < len(lines) {
+			l = lines[i]
+		}
+		if r != l {
+			return fmt.Sprintf("prompt line %d is %q, recorded %q", i+1, truncateString(l, 80), truncateString(r, 80))
+		}
+	}
+	return "the prompt is the same"
+}
+
+// tokenCountKey identifies a CountTokens call
+func tokenCountKey(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordingLLMClient passes every call through and appends it to a fixture
+// file, which is rewritten after each call so a crashed run keeps what it
+// recorded
+type RecordingLLMClient struct {
+	next LLMClient
+	path string
+
+	mu      sync.Mutex
+	fixture *LLMFixture
+}
+
+func NewRecordingLLMClient(next LLMClient, path string) *RecordingLLMClient {
+	return &RecordingLLMClient{
+		next:    next,
+		path:    path,
+		fixture: &LLMFixture{Interactions: []LLMInteraction{}, TokenCounts: make(map[string]int)},
+	}
+}
+
+func (r *RecordingLLMClient) Provider() string {
+	return r.next.Provider()
+}
+
+func (r *RecordingLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	resp, err := r.next.Generate(ctx, req)
+
+	interaction := LLMInteraction{
+		Key:     fixtureKey(ctx, req),
+		Mode:    usageModeFrom(ctx),
+		Request: req,
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+		interaction.ErrorClass = ClassifyLLMError(err)
+	} else {
+		recorded := *resp
+		recorded.Cached = false
+		interaction.Response = &recorded
+	}
+
+	r.mu.Lock()
+	r.fixture.Interactions = append(r.fixture.Interactions, interaction)
+	r.save()
+	r.mu.Unlock()
+
+	return resp, err
+}
+
+func (r *RecordingLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	n, err := r.next.CountTokens(ctx, model, text)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.fixture.TokenCounts[tokenCountKey(model, text)] = n
+	r.save()
+	r.mu.Unlock()
+
+	return n, nil
+}
+
+// save writes the fixture; callers hold r.mu
+func (r *RecordingLLMClient) save() {
+	if err := r.fixture.Save(r.path); err != nil {
+		log.Printf("Warning: failed to save LLM recording %s: %v", r.path, err)
+	}
+}
+
+func (r *RecordingLLMClient) Close() {
+	if closer, ok := r.next.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// ReplayLLMClient serves recorded responses offline. Requests are matched
+// by fixtureKey: an edited prompt replays the response recorded for the same
+// call, with a logged note of where the prompt changed, while a call the
+// recording does not have fails with a hint of the closest recorded call.
+// Repeated requests get their recorded responses in order; the last one
+// repeats once they run out.
+type ReplayLLMClient struct {
+	mu          sync.Mutex
+	recorded    map[string][]LLMInteraction
+	used        map[string]int
+	tokenCounts map[string]int
+}
+
+func NewReplayLLMClient(fixture *LLMFixture) *ReplayLLMClient {
+	r := &ReplayLLMClient{
+		recorded:    make(map[string][]LLMInteraction),
+		used:        make(map[string]int),
+		tokenCounts: fixture.TokenCounts,
+	}
+	for _, interaction := range fixture.Interactions {
+		r.recorded[interaction.Key] = append(r.recorded[interaction.Key], interaction)
+	}
+	return r
+}
+
+// LoadReplayLLMClient builds a replay client from a fixture file
+func LoadReplayLLMClient(path string) (*ReplayLLMClient, error) {
+	fixture, err := LoadLLMFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayLLMClient(fixture), nil
+}
+
+func (r *ReplayLLMClient) Provider() string {
+	return "replay"
+}
+
+func (r *ReplayLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key := fixtureKey(ctx, req)
+	r.mu.Lock()
+	interactions := r.recorded[key]
+	idx := r.used[key]
+	r.used[key]++
+	r.mu.Unlock()
+
+	if len(interactions) == 0 {
+		return nil, &LLMError{Class: ErrorClassInvalidRequest, Model: req.Model, Err: fmt.Errorf("replay: no recorded response for %s%s; record the fixture again", key, r.closest(ctx, req))}
+	}
+	if idx >= len(interactions) {
+		idx = len(interactions) - 1
+	}
+
+	interaction := interactions[idx]
+	if interaction.Request.Prompt != req.Prompt {
+		log.Printf("replay: %s differs from the recording: %s", key, promptDiff(interaction.Request.Prompt, req.Prompt))
+	}
+	if interaction.Error != "" {
+		return nil, &LLMError{Class: interaction.ErrorClass, Model: req.Model, Err: fmt.Errorf("%s", interaction.Error)}
+	}
+	resp := *interaction.Response
+	return &resp, nil
+}
+
+// closest describes the recorded call of the same mode whose prompt shares
+// the longest start with req's, for a request the recording does not have
+func (r *ReplayLLMClient) closest(ctx context.Context, req LLMRequest) string {
+	mode := usageModeFrom(ctx)
+	var best *LLMInteraction
+	bestShared := -1
+	for _, interactions := range r.recorded {
+		for i := range interactions {
+			interaction := &interactions[i]
+			if interaction.Mode != mode {
+				continue
+			}
+			shared := 0
+			for shared < len(req.Prompt) && shared < len(interaction.Request.Prompt) && req.Prompt[shared] == interaction.Request.Prompt[shared] {
+				shared++
+			}
+			if shared > bestShared || (shared == bestShared && interaction.Key < best.Key) {
+				best, bestShared = interaction, shared
+			}
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (closest recorded call %s: %s)", best.Key, promptDiff(best.Request.Prompt, req.Prompt))
+}
+
+func (r *ReplayLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	if n, ok := r.tokenCounts[tokenCountKey(model, text)]; ok {
+		return n, nil
+	}
+	return estimateTokens(text), nil
+}
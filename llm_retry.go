@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Default retry and circuit breaker policy for model calls
+const (
+	defaultLLMMaxAttempts          = 3
+	defaultLLMBackoffBaseMs        = 500
+	defaultLLMBackoffMaxMs         = 8000
+	defaultLLMAttemptTimeoutSec    = 0
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitCooldownSeconds  = 30
+)
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// CircuitBreaker stops calls to a model after a run of consecutive
+// failures that say the model is unhealthy. After the cooldown a single probe call is let
+// through; its outcome closes the circuit or opens it again.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     circuitClosed,
+	}
+}
+
+// Allow reports whether a call may proceed
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Success closes the circuit
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = circuitClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Release ends a probe whose outcome says nothing about the model's health
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Failure records a failure of the model and reports whether the circuit opened
+func (b *CircuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		opened := b.state != circuitOpen
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+		return opened
+	}
+	return false
+}
+
+// Process-wide breakers, one per model name
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = make(map[string]*CircuitBreaker)
+)
+
+// circuitBreakerFor returns the shared breaker for a model, creating it on first use
+func circuitBreakerFor(model string) *CircuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	if breaker, ok := circuitBreakers[model]; ok {
+		return breaker
+	}
+
+	breaker := NewCircuitBreaker(
+		getEnvInt("TENDERIQ_CIRCUIT_FAILURE_THRESHOLD", defaultCircuitFailureThreshold),
+		time.Duration(getEnvInt("TENDERIQ_CIRCUIT_COOLDOWN_SECONDS", defaultCircuitCooldownSeconds))*time.Second,
+	)
+	circuitBreakers[model] = breaker
+	return breaker
+}
+
+// RetryingLLMClient is the single error policy for model calls. It
+// classifies every failure, retries rate limits, outages and timeouts with
+// jittered exponential backoff, and keeps a circuit breaker per model.
+// Safety blocks come back as errors instead of empty responses.
+type RetryingLLMClient struct {
+	next        LLMClient
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	// attemptTimeout bounds each provider call, so a hung attempt is
+	// retried; zero leaves calls bounded only by the caller's deadline
+	attemptTimeout time.Duration
+}
+
+func NewRetryingLLMClient(next LLMClient) *RetryingLLMClient {
+	maxAttempts := getEnvInt("TENDERIQ_LLM_MAX_ATTEMPTS", defaultLLMMaxAttempts)
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &RetryingLLMClient{
+		next:           next,
+		maxAttempts:    maxAttempts,
+		baseDelay:      time.Duration(getEnvInt("TENDERIQ_LLM_BACKOFF_BASE_MS", defaultLLMBackoffBaseMs)) * time.Millisecond,
+		maxDelay:       time.Duration(getEnvInt("TENDERIQ_LLM_BACKOFF_MAX_MS", defaultLLMBackoffMaxMs)) * time.Millisecond,
+		attemptTimeout: time.Duration(getEnvInt("TENDERIQ_LLM_ATTEMPT_TIMEOUT_SECONDS", defaultLLMAttemptTimeoutSec)) * time.Second,
+	}
+}
+
+type attemptTimeoutKey struct{}
+
+// withAttemptTimeout asks the client that sends a call made with ctx to
+// give it at most d, not counting the wait for the rate limiter
+func withAttemptTimeout(ctx context.Context, d time.Duration) context.Context {
+	if d <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, attemptTimeoutKey{}, d)
+}
+
+// attemptContext applies the attempt timeout set on ctx, if any
+func attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d, ok := ctx.Value(attemptTimeoutKey{}).(time.Duration); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
+
+func (r *RetryingLLMClient) Provider() string {
+	return r.next.Provider()
+}
+
+// backoff returns a full-jitter delay for the given retry (1-based)
+func (r *RetryingLLMClient) backoff(retry int) time.Duration {
+	ceiling := r.baseDelay << (retry - 1)
+	if ceiling <= 0 || ceiling > r.maxDelay {
+		ceiling = r.maxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+func (r *RetryingLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	breaker := circuitBreakerFor(req.Model)
+
+	for attempt := 1; ; attempt++ {
+		if !breaker.Allow() {
+			return nil, &LLMError{Class: ErrorClassCircuitOpen, Model: req.Model, Err: fmt.Errorf("circuit breaker open after repeated failures")}
+		}
+
+		// An attempt that runs out its own timeout is a retryable timeout;
+		// only the caller's ctx ending stops the loop
+		resp, err := r.next.Generate(withAttemptTimeout(ctx, r.attemptTimeout), req)
+		if err == nil && resp.FinishReason == FinishReasonSafety && resp.Text == "" {
+			err = &LLMError{Class: ErrorClassSafety, Model: req.Model, Err: fmt.Errorf("response blocked by safety filters")}
+		}
+		if err == nil {
+			breaker.Success()
+			return resp, nil
+		}
+
+		// The caller cancelled or ran out of time, perhaps while waiting on
+		// the rate limiter; that says nothing about the model's health and
+		// cannot be retried
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			breaker.Release()
+			if !errors.Is(err, ctxErr) {
+				err = fmt.Errorf("%w: %v", ctxErr, err)
+			}
+			return nil, &LLMError{Class: ErrorClassCanceled, Model: req.Model, Err: err}
+		}
+
+		llmErr := classifyLLMError(req.Model, err).(*LLMError)
+		if !countsAgainstCircuit(llmErr.Class) {
+			breaker.Release()
+			return nil, llmErr
+		}
+		if breaker.Failure() {
+			log.Printf("Circuit breaker opened for %s after %s error: %v", req.Model, llmErr.Class, err)
+		}
+		if !llmErr.Retryable() {
+			return nil, llmErr
+		}
+
+		if attempt >= r.maxAttempts {
+			return nil, llmErr
+		}
+
+		delay := r.backoff(attempt)
+		log.Printf("Model %s failed with %s (attempt %d/%d), retrying in %v: %v", req.Model, llmErr.Class, attempt, r.maxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, llmErr
+		case <-timer.C:
+		}
+	}
+}
+
+// countsAgainstCircuit reports whether a failed call says the model is
+// unhealthy. Errors the caller caused, such as a bad request, a blocked
+// prompt or a cancellation, leave the circuit alone. A rejected API key
+// counts, since every call to the model fails until it is fixed.
+func countsAgainstCircuit(class string) bool {
+	switch class {
+	case ErrorClassCanceled, ErrorClassInvalidRequest, ErrorClassSafety, ErrorClassTruncated, ErrorClassSchema:
+		return false
+	}
+	return true
+}
+
+func (r *RetryingLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return r.next.CountTokens(ctx, model, text)
+}
+
+func (r *RetryingLLMClient) Close() {
+	if closer, ok := r.next.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
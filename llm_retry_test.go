@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// scriptedLLMClient answers the nth call with the nth function of attempts
+// and keeps the requests it was sent
+type scriptedLLMClient struct {
+	attempts []func(ctx context.Context) (*LLMResponse, error)
+	calls    int
+	requests []LLMRequest
+}
+
+func (s *scriptedLLMClient) Provider() string {
+	return "scripted"
+}
+
+func (s *scriptedLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	attempt := s.attempts[s.calls]
+	s.calls++
+	s.requests = append(s.requests, req)
+	return attempt(ctx)
+}
+
+func (s *scriptedLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return estimateTokens(text), nil
+}
+
+// testRetryingClient sends calls through the retry and rate limit clients as
+// the services do, with no backoff delay
+func testRetryingClient(t *testing.T, next LLMClient) *RetryingLLMClient {
+	t.Helper()
+	t.Setenv("TENDERIQ_LLM_MAX_ATTEMPTS", "3")
+	t.Setenv("TENDERIQ_LLM_BACKOFF_BASE_MS", "0")
+	t.Setenv("TENDERIQ_LLM_BACKOFF_MAX_MS", "0")
+	return NewRetryingLLMClient(NewRateLimitedLLMClient(next))
+}
+
+func TestRetryAfterAttemptTimeout(t *testing.T) {
+	fake := &scriptedLLMClient{attempts: []func(ctx context.Context) (*LLMResponse, error){
+		func(ctx context.Context) (*LLMResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		func(ctx context.Context) (*LLMResponse, error) {
+			return &LLMResponse{Text: "ok"}, nil
+		},
+	}}
+	client := testRetryingClient(t, fake)
+	client.attemptTimeout = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := client.Generate(ctx, LLMRequest{Model: "test-attempt-timeout", Prompt: "p"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "ok" || fake.calls != 2 {
+		t.Errorf("response %q after %d calls, want %q after 2", resp.Text, fake.calls, "ok")
+	}
+}
+
+func TestNoRetryAfterCallerDeadline(t *testing.T) {
+	fake := &scriptedLLMClient{attempts: []func(ctx context.Context) (*LLMResponse, error){
+		func(ctx context.Context) (*LLMResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}}
+	client := testRetryingClient(t, fake)
+	client.attemptTimeout = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.Generate(ctx, LLMRequest{Model: "test-caller-deadline", Prompt: "p"})
+	if class := ClassifyLLMError(err); class != ErrorClassCanceled || fake.calls != 1 {
+		t.Errorf("error class %q after %d calls, want %q after 1", class, fake.calls, ErrorClassCanceled)
+	}
+}
+
+func TestCircuitBreakerAccounting(t *testing.T) {
+	t.Setenv("TENDERIQ_CIRCUIT_FAILURE_THRESHOLD", "10")
+
+	tests := []struct {
+		class    string
+		calls    int
+		failures int
+	}{
+		{ErrorClassInvalidRequest, 1, 1},
+		{ErrorClassCanceled, 1, 1},
+		{ErrorClassSafety, 1, 1},
+		{ErrorClassSchema, 1, 1},
+		{ErrorClassAuth, 1, 2},
+		{ErrorClassUnknown, 1, 2},
+		{ErrorClassUnavailable, 3, 4},
+	}
+	for _, tt := range tests {
+		fail := func(ctx context.Context) (*LLMResponse, error) {
+			return nil, &LLMError{Class: tt.class, Err: errors.New("provider error")}
+		}
+		fake := &scriptedLLMClient{attempts: []func(ctx context.Context) (*LLMResponse, error){fail, fail, fail}}
+		client := testRetryingClient(t, fake)
+
+		// One earlier failure, which only a successful call may clear
+		model := "test-breaker-" + tt.class
+		breaker := circuitBreakerFor(model)
+		breaker.Failure()
+
+		_, err := client.Generate(context.Background(), LLMRequest{Model: model, Prompt: "p"})
+		if class := ClassifyLLMError(err); class != tt.class || fake.calls != tt.calls {
+			t.Errorf("%s: error class %q after %d calls, want %d calls", tt.class, class, fake.calls, tt.calls)
+		}
+		if breaker.failures != tt.failures {
+			t.Errorf("%s: breaker has %d failures, want %d", tt.class, breaker.failures, tt.failures)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Extractors that can be routed to their own models
+const (
+	ExtractorSummary  = "summary"
+	ExtractorSOW      = "sow"
+	ExtractorSections = "sections"
+	ExtractorAnalyze  = "analyze"
+	ExtractorChat     = "chat"
+	// ExtractorTranscription reads page images and needs a multimodal model
+	ExtractorTranscription = "transcription"
+)
+
+// ModelRoute is the pair of models an extractor uses: Pro for whole-document
+// and aggregation calls, Flash for chunks and fallbacks. A model may carry a
+// backend prefix, e.g. "local:qwen2.5-32b-instruct".
+type ModelRoute struct {
+	Pro   string `json:"pro"`
+	Flash string `json:"flash"`
+}
+
+// routeFor reads TENDERIQ_ROUTE_<EXTRACTOR>, falling back to
+// TENDERIQ_ROUTE_DEFAULT and then def. The value is "model" for both tiers
+// or "pro_model,flash_model".
+func routeFor(extractor string, def ModelRoute) ModelRoute {
+	value := os.Getenv("TENDERIQ_ROUTE_" + strings.ToUpper(extractor))
+	if value == "" {
+		value = os.Getenv("TENDERIQ_ROUTE_DEFAULT")
+	}
+	if value == "" {
+		return def
+	}
+
+	pro, flash, found := strings.Cut(value, ",")
+	route := ModelRoute{Pro: strings.TrimSpace(pro), Flash: strings.TrimSpace(pro)}
+	if found {
+		route.Flash = strings.TrimSpace(flash)
+	}
+	log.Printf("Routing %s extractor to pro=%s flash=%s", extractor, route.Pro, route.Flash)
+	return route
+}
+
+// splitModel separates an optional "backend:" prefix from a model name
+func splitModel(model string) (backend, name string) {
+	if backend, name, found := strings.Cut(model, ":"); found {
+		return backend, name
+	}
+	return "", model
+}
+
+var (
+	llmBackendsOnce sync.Once
+	llmBackends     map[string]LLMClient
+)
+
+// llmBackendsFromEnv returns the named backends models can be routed to:
+// "local" for an OpenAI-compatible server at LOCAL_LLM_BASE_URL (llama.cpp,
+// vLLM, Ollama) and "openai" when OPENAI_API_KEY is set
+func llmBackendsFromEnv() map[string]LLMClient {
+	llmBackendsOnce.Do(func() {
+		llmBackends = make(map[string]LLMClient)
+
+		if baseURL := os.Getenv("LOCAL_LLM_BASE_URL"); baseURL != "" {
+			llmBackends["local"] = NewOpenAICompatibleLLMClient("local", baseURL, os.Getenv("LOCAL_LLM_API_KEY"))
+			log.Printf("Local LLM backend at %s", baseURL)
+		}
+		if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
+			llmBackends["openai"] = NewOpenAILLMClient(apiKey)
+		}
+	})
+	return llmBackends
+}
+
+// LLMRouter sends requests whose model has a known "backend:" prefix to
+// that backend with the prefix stripped; everything else goes to the default
+// client. Decorators above the router see the prefixed name, so caching,
+// rate limits, circuit breakers and usage are kept per routed model.
+type LLMRouter struct {
+	def      LLMClient
+	backends map[string]LLMClient
+}
+
+func NewLLMRouter(def LLMClient, backends map[string]LLMClient) *LLMRouter {
+	return &LLMRouter{def: def, backends: backends}
+}
+
+func (r *LLMRouter) Provider() string {
+	if r.def == nil {
+		return "router"
+	}
+	return r.def.Provider()
+}
+
+// route picks the backend for model and returns the model name it expects
+func (r *LLMRouter) route(model string) (LLMClient, string, error) {
+	// Only known prefixes route; Ollama-style names such as "llama3.1:8b"
+	// contain a colon themselves
+	if backend, name := splitModel(model); backend != "" {
+		if client, ok := r.backends[backend]; ok {
+			return client, name, nil
+		}
+	}
+	if r.def == nil {
+		return nil, "", &LLMError{Class: ErrorClassInvalidRequest, Model: model, Err: fmt.Errorf("no LLM backend configured for model %s", model)}
+	}
+	return r.def, model, nil
+}
+
+func (r *LLMRouter) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	client, name, err := r.route(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	routed := req
+	routed.Model = name
+	resp, err := client.Generate(ctx, routed)
+	if err != nil {
+		return nil, err
+	}
+	resp.Model = req.Model
+	return resp, nil
+}
+
+func (r *LLMRouter) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	client, name, err := r.route(model)
+	if err != nil {
+		return 0, err
+	}
+	return client.CountTokens(ctx, name, text)
+}
+
+// Close closes the default client; named backends are shared process-wide
+func (r *LLMRouter) Close() {
+	if closer, ok := r.def.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
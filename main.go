@@ -5,7 +5,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -39,11 +41,32 @@ func loadEnvFile(filename string) {
 	}
 }
 
+// getEnvInt reads an integer environment variable, returning def when unset or invalid
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %d", value, key, def)
+		return def
+	}
+	return n
+}
+
 func main() {
 	// Load environment variables from .env file first, then .env.example as fallback
 	loadEnvFile(".env")
 	loadEnvFile(".env.example")
 
+	// Load prompt templates and watch the prompt directory for edits
+	prompts := promptRegistry()
+	if interval := getEnvInt("TENDERIQ_PROMPTS_RELOAD_SECONDS", defaultPromptsReloadSeconds); interval > 0 {
+		go prompts.Watch(time.Duration(interval) * time.Second)
+	}
+
 	// Create Echo instance
 	e := echo.New()
 
@@ -53,16 +76,61 @@ func main() {
 	e.Use(middleware.CORS())
 
 	// Initialize services
-	openAIService := NewOpenAIService(os.Getenv("OPENAI_API_KEY"))
+	var openAIService *OpenAIService
+	var geminiService *GeminiService
+	if os.Getenv("LLM_BACKEND") == "fake" {
+		// Offline mode: every model call is answered by the scripted fake backend
+		fake, err := LoadFakeLLMScript(os.Getenv("LLM_FAKE_SCRIPT"))
+		if err != nil {
+			log.Fatalf("Failed to load fake LLM backend: %v", err)
+		}
+		log.Println("Using fake LLM backend")
+		openAIService = NewOpenAIServiceWithClient(fake)
+		geminiService = NewGeminiServiceWithClient(fake)
+	} else if os.Getenv("LLM_BACKEND") == "replay" {
+		// Offline mode: extractor calls are answered from a recorded fixture
+		replay, err := LoadReplayLLMClient(os.Getenv("LLM_REPLAY_FIXTURE"))
+		if err != nil {
+			log.Fatalf("Failed to load replay LLM backend: %v", err)
+		}
+		log.Println("Using replay LLM backend")
+		openAIService = NewOpenAIServiceWithClient(replay)
+		geminiService = NewGeminiServiceWithClient(replay)
+	} else {
+		openAIService = NewOpenAIService(os.Getenv("OPENAI_API_KEY"))
+		geminiService = NewGeminiService(os.Getenv("GEMINI_API_KEY"))
+	}
+	defer geminiService.Close()
 	wsHandler := NewWebSocketHandler(openAIService)
 
 	// Initialize TenderIQ services
-	geminiService := NewGeminiService(os.Getenv("GEMINI_API_KEY"))
-	vectorStore := NewVectorStore()
-	pdfParser := NewPDFParser()
-	tenderIQHandler := NewTenderIQHandler(geminiService, vectorStore, pdfParser)
-	sowExtractor := NewSOWExtractor(geminiService, os.Getenv("GEMINI_API_KEY"))
-	tenderSummaryExtractor := NewTenderSummaryExtractor(geminiService, pdfParser)
+	// Uploaded documents are kept on disk unless TENDERIQ_STORE=memory
+	vectorStore, err := NewVectorStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open document store: %v", err)
+	}
+	defer vectorStore.Close()
+	// Scanned and drawing-only pages are rendered and read by local OCR
+	// and, where OCR is missing or unsure, by a multimodal model
+	var ocr OCREngine
+	if os.Getenv("TENDERIQ_OCR_ENGINE") != "none" {
+		tesseract, err := NewTesseractOCR()
+		if err != nil {
+			log.Printf("Warning: local OCR disabled: %v", err)
+		} else {
+			log.Printf("Using %s for pages without a usable text layer", tesseract.Name())
+			ocr = tesseract
+		}
+	}
+	var transcriber PageTranscriber
+	if os.Getenv("TENDERIQ_TRANSCRIBE_PAGES") != "false" && geminiService.llm != nil {
+		transcriber = geminiService
+	}
+	pdfParser := NewPDFParserWithOCR(ocr, transcriber)
+	documents := NewDocumentLoader(NewPackageIngester(pdfParser), vectorStore)
+	tenderIQHandler := NewTenderIQHandler(geminiService, vectorStore, documents)
+	sowExtractor := NewSOWExtractor(geminiService)
+	tenderSummaryExtractor := NewTenderSummaryExtractor(geminiService, documents)
 
 	// Routes
 	e.GET("/", func(c echo.Context) error {
@@ -78,13 +146,18 @@ func main() {
 	tenderIQGroup.POST("/analyze", tenderIQHandler.AnalyzeDocument)
 	tenderIQGroup.POST("/sections", tenderIQHandler.AnalyzeSections)
 	tenderIQGroup.POST("/scope-of-work", func(c echo.Context) error {
-		return handleScopeOfWorkExtraction(c, sowExtractor, pdfParser)
+		return handleScopeOfWorkExtraction(c, sowExtractor, documents)
 	})
 	tenderIQGroup.POST("/tender-summary", tenderSummaryExtractor.HandleTenderSummaryExtraction)
+	tenderIQGroup.POST("/tables", func(c echo.Context) error {
+		return handleTableExtraction(c, documents)
+	})
 	tenderIQGroup.GET("/documents", tenderIQHandler.ListDocuments)
 	tenderIQGroup.GET("/documents/:id", tenderIQHandler.GetDocument)
+	tenderIQGroup.GET("/documents/:id/pages/:n", tenderIQHandler.GetPageImage)
 	tenderIQGroup.DELETE("/documents/:id", tenderIQHandler.DeleteDocument)
 	tenderIQGroup.GET("/search", tenderIQHandler.SearchDocuments)
+	tenderIQGroup.GET("/usage", HandleUsage)
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Default OCR settings
+const (
+	defaultTesseractPath = "tesseract"
+	defaultOCRLanguages  = "eng+hin"
+	defaultOCRDPI        = 300
+)
+
+// OCRResult is the recognised text of one page image
+type OCRResult struct {
+	Text string
+	// Confidence is the mean word confidence, 0-100
+	Confidence float64
+	// Lines are the recognised lines placed in image pixels
+	Lines []TextLine
+}
+
+// OCREngine recognises text in a rendered page image. Unlike a
+// PageTranscriber it runs locally and gives the same output for the same
+// image.
+type OCREngine interface {
+	Name() string
+	RecognizePage(ctx context.Context, png []byte, dpi int) (*OCRResult, error)
+}
+
+// TesseractOCR runs a locally installed tesseract binary
+type TesseractOCR struct {
+	binary    string
+	languages string
+}
+
+// NewTesseractOCR finds tesseract (TENDERIQ_TESSERACT_PATH or $PATH) and
+// checks the TENDERIQ_OCR_LANGUAGES packs are installed. Missing packs are
+// dropped with a warning; it fails if none are left.
+func NewTesseractOCR() (*TesseractOCR, error) {
+	binary := os.Getenv("TENDERIQ_TESSERACT_PATH")
+	if binary == "" {
+		binary = defaultTesseractPath
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found: %w", err)
+	}
+
+	languages := os.Getenv("TENDERIQ_OCR_LANGUAGES")
+	if languages == "" {
+		languages = defaultOCRLanguages
+	}
+
+	out, err := exec.Command(path, "--list-langs").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tesseract languages: %w", err)
+	}
+	installed := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		installed[strings.TrimSpace(line)] = true
+	}
+
+	var available []string
+	for _, lang := range strings.Split(languages, "+") {
+		lang = strings.TrimSpace(lang)
+		if lang == "" {
+			continue
+		}
+		if !installed[lang] {
+			log.Printf("Warning: tesseract language pack %q is not installed, skipping it", lang)
+			continue
+		}
+		available = append(available, lang)
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("none of the tesseract languages %q are installed", languages)
+	}
+
+	return &TesseractOCR{binary: path, languages: strings.Join(available, "+")}, nil
+}
+
+func (t *TesseractOCR) Name() string {
+	return "tesseract:" + t.languages
+}
+
+// RecognizePage pipes the image through tesseract and reads its TSV output,
+// which carries a confidence for every word
+func (t *TesseractOCR) RecognizePage(ctx context.Context, png []byte, dpi int) (*OCRResult, error) {
+	cmd := exec.CommandContext(ctx, t.binary, "stdin", "stdout", "-l", t.languages, "--dpi", strconv.Itoa(dpi), "tsv")
+	cmd.Stdin = bytes.NewReader(png)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseTesseractTSV(out), nil
+}
+
+// parseTesseractTSV rebuilds the page text from tesseract's word rows, one
+// line per text line and a blank line between paragraphs, with the lines'
+// boxes from their words
+func parseTesseractTSV(data []byte) *OCRResult {
+	var text strings.Builder
+	var lines []TextLine
+	var confSum float64
+	words := 0
+	lastPara, lastLine := "", ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		// level page block par line word left top width height conf text
+		fields := strings.SplitN(scanner.Text(), "\t", 12)
+		if len(fields) < 12 || fields[0] != "5" {
+			continue
+		}
+		word := strings.TrimSpace(fields[11])
+		conf, err := strconv.ParseFloat(fields[10], 64)
+		if word == "" || err != nil || conf < 0 {
+			continue
+		}
+
+		para := fields[2] + "." + fields[3]
+		line := para + "." + fields[4]
+		switch {
+		case text.Len() == 0:
+		case para != lastPara:
+			text.WriteString("\n\n")
+		case line != lastLine:
+			text.WriteString("\n")
+		default:
+			text.WriteString(" ")
+		}
+		text.WriteString(word)
+		if len(lines) == 0 || line != lastLine {
+			lines = append(lines, TextLine{Text: word})
+		} else {
+			lines[len(lines)-1].Text += " " + word
+		}
+		lines[len(lines)-1].BBox = lines[len(lines)-1].BBox.Union(tesseractBox(fields[6:10]))
+		lastPara, lastLine = para, line
+
+		confSum += conf
+		words++
+	}
+
+	result := &OCRResult{Text: text.String(), Lines: lines}
+	if words > 0 {
+		result.Confidence = confSum / float64(words)
+	}
+	return result
+}
+
+// tesseractBox reads the left, top, width and height of a TSV row
+func tesseractBox(fields []string) BBox {
+	var v [4]float64
+	for i, field := range fields {
+		v[i], _ = strconv.ParseFloat(field, 64)
+	}
+	return BBox{X0: v[0], Y0: v[1], X1: v[0] + v[2], Y1: v[1] + v[3]}
+}
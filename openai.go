@@ -9,7 +9,9 @@ import (
 )
 
 type OpenAIService struct {
-	client *openai.Client
+	llm    LLMClient
+	model  string
+	config GenerationConfig
 }
 
 func NewOpenAIService(apiKey string) *OpenAIService {
@@ -17,14 +19,25 @@ func NewOpenAIService(apiKey string) *OpenAIService {
 		log.Println("Warning: OpenAI API key not provided. Set OPENAI_API_KEY environment variable.")
 	}
 	
-	client := openai.NewClient(apiKey)
+	return NewOpenAIServiceWithClient(NewOpenAILLMClient(apiKey))
+}
+
+// NewOpenAIServiceWithClient creates the chat service on top of any LLMClient
+func NewOpenAIServiceWithClient(llm LLMClient) *OpenAIService {
+	// Prefixed models go to their backend, e.g. TENDERIQ_ROUTE_CHAT=local:llama3.1:8b
+	llm = NewLLMRouter(llm, llmBackendsFromEnv())
 	return &OpenAIService{
-		client: client,
+		llm:   NewMeteredLLMClient(NewCachedLLMClient(NewRetryingLLMClient(NewRateLimitedLLMClient(llm)), llmCacheFromEnv())),
+		model: routeFor(ExtractorChat, ModelRoute{Pro: openai.GPT3Dot5Turbo}).Pro,
+		config: GenerationConfig{
+			Temperature:     temperature(0.7),
+			MaxOutputTokens: 500,
+		},
 	}
 }
 
-func (s *OpenAIService) GetChatResponse(userMessage string) (string, error) {
-	if s.client == nil {
+func (s *OpenAIService) GetChatResponse(ctx context.Context, userMessage string) (string, error) {
+	if s.llm == nil {
 		return "Sorry, I'm not properly configured. Please check the server setup.", fmt.Errorf("OpenAI client not initialized")
 	}
 
@@ -44,24 +57,12 @@ Your expertise includes:
 
 Always provide helpful, accurate, and safety-focused responses. If asked about topics outside your expertise, politely redirect the conversation back to road and transportation topics.`
 
-	resp, err := s.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: systemPrompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: userMessage,
-				},
-			},
-			MaxTokens:   500,
-			Temperature: 0.7,
-		},
-	)
+	resp, err := s.llm.Generate(WithUsageMode(ctx, UsageModeChat), LLMRequest{
+		Model:  s.model,
+		System: systemPrompt,
+		Prompt: userMessage,
+		Config: s.config,
+	})
 
 	if err != nil {
 		log.Printf("OpenAI API error: %v", err)
@@ -69,9 +70,9 @@ Always provide helpful, accurate, and safety-focused responses. If asked about t
 		return "I'm having trouble connecting to my AI service right now. This could be due to an API key issue or temporary service unavailability. Please check the server configuration and try again.", fmt.Errorf("failed to get response from OpenAI: %w", err)
 	}
 
-	if len(resp.Choices) == 0 {
+	if resp.Text == "" {
 		return "I received an empty response from the AI service. Please try asking your question again.", fmt.Errorf("no response choices returned from OpenAI")
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return resp.Text, nil
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gen2brain/go-fitz"
+)
+
+// Where a document outline came from
+const (
+	OutlineSourceBookmarks = "bookmarks" // the PDF's own table of contents
+	OutlineSourceLayout    = "layout"    // headings found by font size and weight
+)
+
+// Heading detection thresholds
+const (
+	// Lines this much larger than the body text are headings
+	outlineHeadingScale = 1.15
+	// Longer lines are body text however they are set
+	outlineMaxTitleChars = 120
+	// Heading styles beyond this many share the deepest level
+	outlineMaxLevels = 4
+	// A line repeated on more pages than this is a running header, not a heading
+	outlineMaxRepeats = 3
+)
+
+// OutlineEntry is a heading of a document and the headings under it
+type OutlineEntry struct {
+	Title string `json:"title"`
+	Level int    `json:"level"`
+	Page  int    `json:"page"`
+	// Top is the heading's distance from the top of its page in points
+	Top      float64         `json:"top"`
+	Children []*OutlineEntry `json:"children,omitempty"`
+}
+
+// DocumentOutline is the heading hierarchy of a document
+type DocumentOutline struct {
+	Source  string          `json:"source"`
+	Entries []*OutlineEntry `json:"entries"`
+}
+
+// Flatten lists the entries in document order, without their children
+func (o *DocumentOutline) Flatten() []OutlineEntry {
+	if o == nil {
+		return nil
+	}
+	var flat []OutlineEntry
+	var walk func(entries []*OutlineEntry)
+	walk = func(entries []*OutlineEntry) {
+		for _, entry := range entries {
+			e := *entry
+			e.Children = nil
+			flat = append(flat, e)
+			walk(entry.Children)
+		}
+	}
+	walk(o.Entries)
+	return flat
+}
+
+// ExtractOutline builds the document's outline from its bookmarks, or from
+// the font size and weight of its lines when it has none. It returns nil
+// when neither gives any headings.
+func (p *PDFParser) ExtractOutline(data []byte) (*DocumentOutline, error) {
+	doc, err := fitz.NewFromMemory(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF document: %w", err)
+	}
+	defer doc.Close()
+
+	if toc, err := doc.ToC(); err == nil {
+		var flat []OutlineEntry
+		for _, item := range toc {
+			title := strings.TrimSpace(item.Title)
+			// Bookmarks pointing outside the document have no page
+			if title == "" || item.Page < 0 {
+				continue
+			}
+			flat = append(flat, OutlineEntry{Title: title, Level: item.Level, Page: item.Page + 1, Top: item.Top})
+		}
+		if len(flat) > 0 {
+			return &DocumentOutline{Source: OutlineSourceBookmarks, Entries: buildOutlineTree(flat)}, nil
+		}
+	}
+
+	var lines []TextLine
+	used := make(map[int]bool)
+	for pageNum := 0; pageNum < doc.NumPage(); pageNum++ {
+		pageLines, err := pageLines(doc, pageNum)
+		if err != nil {
+			continue
+		}
+		// Table cells are never headings
+		_, inTables := detectTables(pageNum+1, pageLines)
+		for i := range inTables {
+			used[len(lines)+i] = true
+		}
+		lines = append(lines, pageLines...)
+	}
+
+	flat := detectHeadings(lines, used)
+	if len(flat) == 0 {
+		return nil, nil
+	}
+	return &DocumentOutline{Source: OutlineSourceLayout, Entries: buildOutlineTree(flat)}, nil
+}
+
+// headingStyle is the font size, to the half point, and weight of a heading
+type headingStyle struct {
+	size float64
+	bold bool
+}
+
+// detectHeadings finds heading lines: larger than the body text, or bold and
+// on a row of their own. Each distinct style is a level, largest first.
+func detectHeadings(lines []TextLine, skip map[int]bool) []OutlineEntry {
+	body := bodyFontSize(lines)
+	if body == 0 {
+		return nil
+	}
+
+	// Lines alone on their row; bold text inside a paragraph is emphasis
+	alone := make(map[int]bool)
+	for i, line := range lines {
+		prevSame := i > 0 && lines[i-1].Page == line.Page && line.BBox.Y0-lines[i-1].BBox.Y0 < tableRowTolerance
+		nextSame := i+1 < len(lines) && lines[i+1].Page == line.Page && lines[i+1].BBox.Y0-line.BBox.Y0 < tableRowTolerance
+		alone[i] = !prevSame && !nextSame
+	}
+
+	type candidate struct {
+		entry OutlineEntry
+		style headingStyle
+		last  TextLine
+	}
+	var candidates []candidate
+	for i, line := range lines {
+		if skip[i] || !looksLikeHeading(line.Text) {
+			continue
+		}
+		larger := line.FontSize >= body*outlineHeadingScale
+		boldAlone := line.Bold && alone[i] && line.FontSize >= body-0.5
+		if !larger && !boldAlone {
+			continue
+		}
+		style := headingStyle{size: math.Round(line.FontSize*2) / 2, bold: line.Bold}
+
+		// A heading set over several lines continues on the next line in the same style
+		if n := len(candidates); n > 0 {
+			prev := &candidates[n-1]
+			gap := line.BBox.Y0 - prev.last.BBox.Y1
+			if prev.style == style && prev.last.Page == line.Page && gap >= 0 && gap < 0.6*(line.BBox.Y1-line.BBox.Y0) {
+				prev.entry.Title += " " + line.Text
+				prev.last = line
+				continue
+			}
+		}
+		candidates = append(candidates, candidate{
+			entry: OutlineEntry{Title: line.Text, Page: line.Page, Top: line.BBox.Y0},
+			style: style,
+			last:  line,
+		})
+	}
+
+	// Running headers repeat on many pages
+	pagesWithTitle := make(map[string]map[int]bool)
+	for _, c := range candidates {
+		key := strings.ToLower(c.entry.Title)
+		if pagesWithTitle[key] == nil {
+			pagesWithTitle[key] = make(map[int]bool)
+		}
+		pagesWithTitle[key][c.entry.Page] = true
+	}
+
+	styleSet := make(map[headingStyle]bool)
+	var kept []candidate
+	for _, c := range candidates {
+		if len(pagesWithTitle[strings.ToLower(c.entry.Title)]) > outlineMaxRepeats {
+			continue
+		}
+		kept = append(kept, c)
+		styleSet[c.style] = true
+	}
+
+	styles := make([]headingStyle, 0, len(styleSet))
+	for style := range styleSet {
+		styles = append(styles, style)
+	}
+	sort.Slice(styles, func(i, j int) bool {
+		if styles[i].size != styles[j].size {
+			return styles[i].size > styles[j].size
+		}
+		return styles[i].bold && !styles[j].bold
+	})
+	levels := make(map[headingStyle]int)
+	for i, style := range styles {
+		levels[style] = minInt(i+1, outlineMaxLevels)
+	}
+
+	flat := make([]OutlineEntry, len(kept))
+	for i, c := range kept {
+		flat[i] = c.entry
+		flat[i].Level = levels[c.style]
+	}
+	return flat
+}
+
+// bodyFontSize is the font size most of the text is set in
+func bodyFontSize(lines []TextLine) float64 {
+	chars := make(map[float64]int)
+	for _, line := range lines {
+		if line.FontSize > 0 {
+			chars[math.Round(line.FontSize*2)/2] += utf8.RuneCountInString(line.Text)
+		}
+	}
+	body, most := 0.0, 0
+	for size, n := range chars {
+		if n > most || (n == most && size < body) {
+			body, most = size, n
+		}
+	}
+	return body
+}
+
+var pageNumberLineRe = regexp.MustCompile(`(?i)^(page\s*)?\d+(\s*(of|/)\s*\d+)?$`)
+
+// looksLikeHeading rules out lines that cannot be headings whatever their font
+func looksLikeHeading(text string) bool {
+	n := utf8.RuneCountInString(text)
+	if n < 3 || n > outlineMaxTitleChars || pageNumberLineRe.MatchString(text) {
+		return false
+	}
+	if strings.HasSuffix(text, ",") || strings.HasSuffix(text, ";") {
+		return false
+	}
+	return strings.IndexFunc(text, unicode.IsLetter) >= 0
+}
+
+// buildOutlineTree nests entries listed in document order under the nearest
+// preceding entry of a lower level
+func buildOutlineTree(flat []OutlineEntry) []*OutlineEntry {
+	var roots []*OutlineEntry
+	var stack []*OutlineEntry
+	for i := range flat {
+		entry := flat[i]
+		entry.Children = nil
+		node := &entry
+		for len(stack) > 0 && stack[len(stack)-1].Level >= node.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, node)
+	}
+	return roots
+}
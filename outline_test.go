@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// styledLine is a line of text at the left margin in the given style
+func styledLine(page int, text string, y, size float64, bold bool) TextLine {
+	return TextLine{
+		Page:     page,
+		Text:     text,
+		BBox:     BBox{X0: 50, Y0: y, X1: 50 + float64(len(text))*size/2, Y1: y + size},
+		FontSize: size,
+		Bold:     bold,
+	}
+}
+
+// bodyLine is a long line of 10 point body text
+func bodyLine(page int, y float64) TextLine {
+	return styledLine(page, "The contractor shall complete the works as per the technical specifications.", y, 10, false)
+}
+
+// outlineSummary renders entries as "level page title"
+func outlineSummary(entries []OutlineEntry) []string {
+	var out []string
+	for _, entry := range entries {
+		out = append(out, fmt.Sprintf("%d %d %s", entry.Level, entry.Page, entry.Title))
+	}
+	return out
+}
+
+func TestDetectHeadings(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []TextLine
+		skip  map[int]bool
+		want  []string
+	}{
+		{
+			name: "larger headings by size",
+			lines: []TextLine{
+				styledLine(1, "Section 1: Instructions to Bidders", 50, 16, true),
+				bodyLine(1, 80),
+				styledLine(1, "1.1 Scope of Bid", 100, 13, true),
+				bodyLine(1, 120),
+				bodyLine(1, 135),
+				styledLine(2, "Section 2: Bid Data Sheet", 50, 16, true),
+				bodyLine(2, 80),
+			},
+			want: []string{
+				"1 1 Section 1: Instructions to Bidders",
+				"2 1 1.1 Scope of Bid",
+				"1 2 Section 2: Bid Data Sheet",
+			},
+		},
+		{
+			name: "bold line alone at body size",
+			lines: []TextLine{
+				styledLine(1, "GENERAL CONDITIONS", 50, 14, false),
+				bodyLine(1, 70),
+				styledLine(1, "Definitions", 90, 10, true),
+				bodyLine(1, 105),
+				bodyLine(1, 120),
+			},
+			want: []string{
+				"1 1 GENERAL CONDITIONS",
+				"2 1 Definitions",
+			},
+		},
+		{
+			name: "bold text on a shared row",
+			lines: []TextLine{
+				styledLine(1, "Note:", 50, 10, true),
+				{Page: 1, Text: "bids shall be valid for 120 days.", BBox: BBox{X0: 80, Y0: 50, X1: 250, Y1: 60}, FontSize: 10},
+				bodyLine(1, 70),
+				bodyLine(1, 85),
+			},
+		},
+		{
+			name: "heading over two lines",
+			lines: []TextLine{
+				styledLine(1, "Section 6: Employer's Requirements", 50, 16, true),
+				styledLine(1, "and Technical Specifications", 70, 16, true),
+				bodyLine(1, 100),
+				bodyLine(1, 115),
+				bodyLine(1, 130),
+			},
+			want: []string{
+				"1 1 Section 6: Employer's Requirements and Technical Specifications",
+			},
+		},
+		{
+			name: "running header and page numbers",
+			lines: []TextLine{
+				styledLine(1, "Bid Document", 20, 14, true), styledLine(1, "Page 1 of 4", 30, 14, false), bodyLine(1, 50), bodyLine(1, 65),
+				styledLine(2, "Bid Document", 20, 14, true), styledLine(2, "Page 2 of 4", 30, 14, false), bodyLine(2, 50), bodyLine(2, 65),
+				styledLine(3, "Bid Document", 20, 14, true), styledLine(3, "Page 3 of 4", 30, 14, false), bodyLine(3, 50), bodyLine(3, 65),
+				styledLine(4, "Bid Document", 20, 14, true), styledLine(4, "Page 4 of 4", 30, 14, false), bodyLine(4, 50), bodyLine(4, 65),
+			},
+		},
+		{
+			name: "skipped lines",
+			lines: []TextLine{
+				styledLine(1, "Bill of Quantities", 50, 16, true),
+				styledLine(1, "Item Description", 80, 12, true),
+				bodyLine(1, 100),
+				bodyLine(1, 115),
+			},
+			skip: map[int]bool{1: true},
+			want: []string{"1 1 Bill of Quantities"},
+		},
+		{
+			name: "trailing comma",
+			lines: []TextLine{
+				styledLine(1, "Dear Sir,", 50, 14, false),
+				bodyLine(1, 70),
+				bodyLine(1, 85),
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := outlineSummary(detectHeadings(tt.lines, tt.skip))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: headings = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// outlineShape renders a tree as "A(B,C),D"
+func outlineShape(entries []*OutlineEntry) string {
+	parts := make([]string, len(entries))
+	for i, entry := range entries {
+		parts[i] = entry.Title
+		if len(entry.Children) > 0 {
+			parts[i] += "(" + outlineShape(entry.Children) + ")"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestBuildOutlineTree(t *testing.T) {
+	tests := []struct {
+		levels []int
+		want   string
+	}{
+		{nil, ""},
+		{[]int{1, 1, 1}, "A,B,C"},
+		{[]int{1, 2, 2, 1, 2}, "A(B,C),D(E)"},
+		{[]int{1, 2, 3, 2, 1}, "A(B(C),D),E"},
+		{[]int{1, 3, 2}, "A(B,C)"},
+		{[]int{2, 1, 2}, "A,B(C)"},
+	}
+	for _, tt := range tests {
+		flat := make([]OutlineEntry, len(tt.levels))
+		for i, level := range tt.levels {
+			flat[i] = OutlineEntry{Title: string(rune('A' + i)), Level: level}
+		}
+		if got := outlineShape(buildOutlineTree(flat)); got != tt.want {
+			t.Errorf("buildOutlineTree(%v) = %q, want %q", tt.levels, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Kinds of file a tender package can hold
+const (
+	PackageFilePDF  = "pdf"
+	PackageFileDOCX = "docx"
+	PackageFileXLSX = "xlsx"
+	PackageFileZIP  = "zip"
+)
+
+// Where the outline of a package or a non-PDF file came from
+const (
+	OutlineSourcePackage = "package" // one entry per file, with the file's headings under it
+	OutlineSourceStyles  = "styles"  // DOCX Heading paragraph styles
+	OutlineSourceSheets  = "sheets"  // one entry per XLSX sheet
+)
+
+// Limits on unpacking archives, which guard against archives that expand to
+// far more than their upload size
+const (
+	defaultPackageMaxFiles = 200
+	defaultPackageMaxMB    = 500
+	packageMaxDepth        = 3
+)
+
+// PackageMember is one file of a tender package. Its pages are numbered from
+// 1 within the file; FirstPage is where they start in the package text.
+type PackageMember struct {
+	Filename  string `json:"filename"`
+	Kind      string `json:"kind"`
+	Pages     int    `json:"pages"`
+	FirstPage int    `json:"first_page"`
+
+	pages         []PageText
+	outline       []OutlineEntry
+	outlineSource string
+	// metadata is the document information of a PDF
+	metadata  map[string]interface{}
+	encrypted bool
+	// annotations are a PDF's links, comments, attachments and form fields
+	annotations *PDFAnnotations
+	// source is the content of a PDF, decrypted, for rendering its pages
+	source []byte
+}
+
+// SkippedFile is a file of an archive that was not ingested
+type SkippedFile struct {
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// TenderPackage is the files of one upload: a single document, or the
+// documents found in a ZIP bundle in archive order
+type TenderPackage struct {
+	Members []*PackageMember
+	Skipped []SkippedFile
+}
+
+// PackageIngester unpacks uploads into tender packages, routing PDFs
+// through the PDF parser and reading DOCX and XLSX files directly
+type PackageIngester struct {
+	pdfParser *PDFParser
+	maxFiles  int
+	maxBytes  int64
+}
+
+// NewPackageIngester reads the archive limits from TENDERIQ_PACKAGE_MAX_FILES
+// and TENDERIQ_PACKAGE_MAX_MB
+func NewPackageIngester(pdfParser *PDFParser) *PackageIngester {
+	return &PackageIngester{
+		pdfParser: pdfParser,
+		maxFiles:  getEnvInt("TENDERIQ_PACKAGE_MAX_FILES", defaultPackageMaxFiles),
+		maxBytes:  int64(getEnvInt("TENDERIQ_PACKAGE_MAX_MB", defaultPackageMaxMB)) << 20,
+	}
+}
+
+// packageFileKind returns the kind of a file by its extension, or "" if it
+// cannot be ingested
+func packageFileKind(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".pdf":
+		return PackageFilePDF
+	case ".docx":
+		return PackageFileDOCX
+	case ".xlsx":
+		return PackageFileXLSX
+	case ".zip":
+		return PackageFileZIP
+	}
+	return ""
+}
+
+// IsSupportedUpload reports whether a file can be uploaded
+func IsSupportedUpload(filename string) bool {
+	return packageFileKind(filename) != ""
+}
+
+// Ingest reads an uploaded file into a package, opening encrypted PDFs with
+// password. A failure of the upload itself is an error; a file inside an
+// archive that cannot be read is skipped and listed in the package, except
+// that a PDF whose password is missing or wrong fails the whole package. If
+// ctx ends the error wraps ctx.Err().
+func (ing *PackageIngester) Ingest(ctx context.Context, filename string, data []byte, password string) (*TenderPackage, error) {
+	pkg := &TenderPackage{}
+	budget := ing.maxBytes
+	if packageFileKind(filename) == PackageFileZIP {
+		if err := ing.unpack(ctx, pkg, filename, data, password, 1, &budget); err != nil {
+			return nil, err
+		}
+		if len(pkg.Members) == 0 {
+			return nil, fmt.Errorf("no PDF, DOCX or XLSX files found in %s", filename)
+		}
+	} else if err := ing.addFile(ctx, pkg, filename, data, password, 1, &budget); err != nil {
+		return nil, err
+	}
+
+	page := 1
+	for _, member := range pkg.Members {
+		member.FirstPage = page
+		page += member.Pages
+	}
+	return pkg, nil
+}
+
+// unpack adds the files of an archive to pkg, in name order so volumes and
+// corrigenda keep their numbering. budget is the uncompressed size left.
+func (ing *PackageIngester) unpack(ctx context.Context, pkg *TenderPackage, name string, data []byte, password string, depth int, budget *int64) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", name, err)
+	}
+
+	files := make([]*zip.File, 0, len(archive.File))
+	for _, f := range archive.File {
+		base := path.Base(f.Name)
+		// Directories and the resource forks macOS adds to archives
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "~$") {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("package ingestion interrupted: %w", err)
+		}
+		// Files of nested archives are named by their path through them
+		memberName := f.Name
+		if depth > 1 {
+			memberName = name + "/" + f.Name
+		}
+
+		kind := packageFileKind(f.Name)
+		if kind == "" {
+			pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: memberName, Reason: "unsupported file type"})
+			continue
+		}
+		if len(pkg.Members) >= ing.maxFiles {
+			pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: memberName, Reason: fmt.Sprintf("package has more than %d files", ing.maxFiles)})
+			continue
+		}
+
+		content, err := readZipFile(f, *budget)
+		if err != nil {
+			pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: memberName, Reason: err.Error()})
+			continue
+		}
+		*budget -= int64(len(content))
+
+		if kind == PackageFileZIP {
+			if depth >= packageMaxDepth {
+				pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: memberName, Reason: "archive nested too deeply"})
+				continue
+			}
+			if err := ing.unpack(ctx, pkg, memberName, content, password, depth+1, budget); err != nil {
+				if ctx.Err() != nil || passwordErrorCode(err) != "" {
+					return err
+				}
+				pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: memberName, Reason: err.Error()})
+			}
+			continue
+		}
+
+		if err := ing.addFile(ctx, pkg, memberName, content, password, depth, budget); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			if passwordErrorCode(err) != "" {
+				return fmt.Errorf("%s: %w", memberName, err)
+			}
+			log.Printf("Warning: skipping %s in %s: %v", memberName, name, err)
+			pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: memberName, Reason: err.Error()})
+		}
+	}
+	return nil
+}
+
+// addFile adds one document to pkg, followed by the supported files embedded
+// in it, which are named by their path through the document like the files
+// of nested archives. Embedded files count against the package limits.
+func (ing *PackageIngester) addFile(ctx context.Context, pkg *TenderPackage, filename string, data []byte, password string, depth int, budget *int64) error {
+	member, err := ing.readFile(ctx, filename, data, password)
+	if err != nil {
+		return err
+	}
+	pkg.Members = append(pkg.Members, member)
+	if member.annotations == nil {
+		return nil
+	}
+
+	for i := range member.annotations.Attachments {
+		attachment := &member.annotations.Attachments[i]
+		childName := filename + "/" + attachment.Name
+		kind := packageFileKind(attachment.Name)
+		reason := ""
+		switch {
+		case kind == "":
+			reason = "unsupported file type"
+		case depth >= packageMaxDepth:
+			reason = "attachment nested too deeply"
+		case len(pkg.Members) >= ing.maxFiles:
+			reason = fmt.Sprintf("package has more than %d files", ing.maxFiles)
+		}
+		if reason != "" {
+			pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: childName, Reason: reason})
+			continue
+		}
+
+		content, err := ing.pdfParser.AttachmentData(ctx, data, *attachment)
+		if err == nil && int64(len(content)) > *budget {
+			err = fmt.Errorf("package exceeds its size limit")
+		}
+		if err == nil {
+			*budget -= int64(len(content))
+			if kind == PackageFileZIP {
+				err = ing.unpack(ctx, pkg, childName, content, password, depth+1, budget)
+			} else {
+				err = ing.addFile(ctx, pkg, childName, content, password, depth+1, budget)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil || passwordErrorCode(err) != "" {
+				return err
+			}
+			log.Printf("Warning: skipping attachment %s: %v", childName, err)
+			pkg.Skipped = append(pkg.Skipped, SkippedFile{Filename: childName, Reason: err.Error()})
+			continue
+		}
+		attachment.IngestedAs = childName
+	}
+	return nil
+}
+
+// readFile extracts the pages and outline of one document
+func (ing *PackageIngester) readFile(ctx context.Context, filename string, data []byte, password string) (*PackageMember, error) {
+	member := &PackageMember{Filename: filename, Kind: packageFileKind(filename)}
+
+	var err error
+	switch member.Kind {
+	case PackageFilePDF:
+		data, member.encrypted, err = ing.pdfParser.Decrypt(ctx, data, password)
+		if err != nil {
+			return nil, err
+		}
+		member.pages, err = ing.pdfParser.ExtractPages(ctx, data)
+		if err == nil {
+			member.source = data
+			member.metadata, err = ing.pdfParser.ExtractMetadata(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				log.Printf("Metadata extraction error: %v", err)
+				member.metadata, err = make(map[string]interface{}), nil
+			}
+			outline, outlineErr := ing.pdfParser.ExtractOutline(data)
+			if outlineErr != nil {
+				log.Printf("Warning: outline extraction failed for %s: %v", filename, outlineErr)
+			}
+			if outline != nil {
+				member.outline, member.outlineSource = outline.Flatten(), outline.Source
+			}
+			member.annotations, err = ing.pdfParser.ExtractAnnotations(ctx, data)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				log.Printf("Warning: annotation extraction failed for %s: %v", filename, err)
+				err = nil
+			}
+		}
+	case PackageFileDOCX:
+		member.pages, member.outline, err = ExtractDOCX(data)
+		member.outlineSource = OutlineSourceStyles
+	case PackageFileXLSX:
+		member.pages, member.outline, err = ExtractXLSX(data)
+		member.outlineSource = OutlineSourceSheets
+	default:
+		err = fmt.Errorf("unsupported file type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if member.Kind != PackageFilePDF {
+		tagPageLanguages(member.pages)
+	}
+
+	member.Pages = len(member.pages)
+	return member, nil
+}
+
+// readZipFile reads an archive member, failing once it grows past limit
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if limit <= 0 || f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("package exceeds its size limit")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open: %w", err)
+	}
+	defer rc.Close()
+
+	// The declared size can lie, so the read is bounded as well
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("package exceeds its size limit")
+	}
+	return data, nil
+}
+
+// Encrypted reports whether any file of the package was opened with a password
+func (pkg *TenderPackage) Encrypted() bool {
+	for _, member := range pkg.Members {
+		if member.encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+// Pages returns the pages of every member numbered through the package
+func (pkg *TenderPackage) Pages() []PageText {
+	var pages []PageText
+	for _, member := range pkg.Members {
+		pages = append(pages, member.packagePages()...)
+	}
+	return pages
+}
+
+// Text joins the package pages. With more than one member each file starts
+// with a header giving its name and its page range in the package.
+func (pkg *TenderPackage) Text() string {
+	return joinPackagePages(pkg.Members, pkg.Pages())
+}
+
+// joinPackagePages joins pages numbered through the package, with a header
+// before each file's pages when there are several members
+func joinPackagePages(members []*PackageMember, pages []PageText) string {
+	if len(members) <= 1 {
+		return JoinPages(pages)
+	}
+	var builder strings.Builder
+	for _, member := range members {
+		builder.WriteString(fmt.Sprintf("\n=== File: %s (pages %d-%d) ===\n", member.Filename, member.FirstPage, member.FirstPage+member.Pages-1))
+		builder.WriteString(JoinPages(pages[member.FirstPage-1 : member.FirstPage-1+member.Pages]))
+	}
+	return builder.String()
+}
+
+// packagePages returns the member's pages numbered as in the package
+func (member *PackageMember) packagePages() []PageText {
+	offset := member.FirstPage - 1
+	pages := make([]PageText, len(member.pages))
+	for i, page := range member.pages {
+		if offset > 0 {
+			page.TableText = strings.ReplaceAll(page.TableText, tableLabel(page.Number), tableLabel(page.Number+offset))
+			page.Tables = append([]Table(nil), page.Tables...)
+			for j := range page.Tables {
+				page.Tables[j].Page += offset
+			}
+		}
+		page.Number += offset
+		pages[i] = page
+	}
+	return pages
+}
+
+// Annotations returns the links, comments, attachments and form fields of
+// the package's PDFs with their pages numbered as in the package
+func (pkg *TenderPackage) Annotations() *PDFAnnotations {
+	merged := &PDFAnnotations{}
+	for _, member := range pkg.Members {
+		a := member.annotations
+		if a == nil {
+			continue
+		}
+		offset := member.FirstPage - 1
+		for _, link := range a.Links {
+			link.Page += offset
+			if link.DestPage > 0 {
+				link.DestPage += offset
+			}
+			merged.Links = append(merged.Links, link)
+		}
+		for _, comment := range a.Comments {
+			comment.Page += offset
+			merged.Comments = append(merged.Comments, comment)
+		}
+		for _, attachment := range a.Attachments {
+			if attachment.Page > 0 {
+				attachment.Page += offset
+			}
+			merged.Attachments = append(merged.Attachments, attachment)
+		}
+		for _, field := range a.FormFields {
+			if field.Page > 0 {
+				field.Page += offset
+			}
+			merged.FormFields = append(merged.FormFields, field)
+		}
+	}
+	return merged
+}
+
+// Outline returns the outline of a single document as it is, and for several
+// files one entry per file with the file's own headings under it
+func (pkg *TenderPackage) Outline() *DocumentOutline {
+	if len(pkg.Members) == 1 {
+		member := pkg.Members[0]
+		if len(member.outline) == 0 {
+			return nil
+		}
+		return &DocumentOutline{Source: member.outlineSource, Entries: buildOutlineTree(member.outline)}
+	}
+
+	var flat []OutlineEntry
+	for _, member := range pkg.Members {
+		if member.Pages == 0 {
+			continue
+		}
+		flat = append(flat, OutlineEntry{Title: member.Filename, Level: 1, Page: member.FirstPage})
+		for _, entry := range member.outline {
+			entry.Level++
+			entry.Page += member.FirstPage - 1
+			flat = append(flat, entry)
+		}
+	}
+	if len(flat) == 0 {
+		return nil
+	}
+	return &DocumentOutline{Source: OutlineSourcePackage, Entries: buildOutlineTree(flat)}
+}
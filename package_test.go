@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// zipEntry is a file to put in a test archive
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+// buildZip writes entries into an archive in the order given
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.Create(entry.name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", entry.name, err)
+		}
+		if _, err := f.Write(entry.data); err != nil {
+			t.Fatalf("failed to write %s: %v", entry.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testDOCX is a DOCX file holding one paragraph
+func testDOCX(t *testing.T, text string) []byte {
+	return buildZip(t, zipEntry{"word/document.xml", []byte(docxDocument(`<w:p><w:r><w:t>` + text + `</w:t></w:r></w:p>`))})
+}
+
+func TestIngestPackage(t *testing.T) {
+	docx := testDOCX(t, "Notice inviting tender")
+	large := testDOCX(t, strings.Repeat("Specification text. ", 200))
+	nested := func(depth int) []byte {
+		data := buildZip(t, zipEntry{"inner.docx", docx})
+		for i := 1; i < depth; i++ {
+			data = buildZip(t, zipEntry{"level.zip", data})
+		}
+		return data
+	}
+
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxBytes int64
+		entries  []zipEntry
+		members  []string
+		first    []int
+		skipped  []SkippedFile
+		err      string
+	}{
+		{
+			name: "name order and skipped files",
+			entries: []zipEntry{
+				{"vol2/boq.docx", docx},
+				{"vol1/nit.docx", docx},
+				{"vol1/drawing.dwg", []byte("dwg")},
+				{"__MACOSX/vol1/._nit.docx", []byte("fork")},
+				{"vol1/~$nit.docx", []byte("lock")},
+			},
+			members: []string{"vol1/nit.docx", "vol2/boq.docx"},
+			first:   []int{1, 2},
+			skipped: []SkippedFile{{Filename: "vol1/drawing.dwg", Reason: "unsupported file type"}},
+		},
+		{
+			name:     "file limit",
+			maxFiles: 1,
+			entries:  []zipEntry{{"a.docx", docx}, {"b.docx", docx}},
+			members:  []string{"a.docx"},
+			first:    []int{1},
+			skipped:  []SkippedFile{{Filename: "b.docx", Reason: "package has more than 1 files"}},
+		},
+		{
+			name:     "size limit",
+			maxBytes: int64(len(large)) + 100,
+			entries:  []zipEntry{{"a.docx", large}, {"b.docx", large}},
+			members:  []string{"a.docx"},
+			first:    []int{1},
+			skipped:  []SkippedFile{{Filename: "b.docx", Reason: "package exceeds its size limit"}},
+		},
+		{
+			name:    "nested archives",
+			entries: []zipEntry{{"bundle.zip", nested(2)}},
+			members: []string{"bundle.zip/level.zip/inner.docx"},
+			first:   []int{1},
+		},
+		{
+			name:    "archive nested too deeply",
+			entries: []zipEntry{{"a.docx", docx}, {"bundle.zip", nested(3)}},
+			members: []string{"a.docx"},
+			first:   []int{1},
+			skipped: []SkippedFile{{Filename: "bundle.zip/level.zip/level.zip", Reason: "archive nested too deeply"}},
+		},
+		{
+			name:    "broken member",
+			entries: []zipEntry{{"a.docx", docx}, {"b.xlsx", []byte("not a zip")}},
+			members: []string{"a.docx"},
+			first:   []int{1},
+			skipped: []SkippedFile{{Filename: "b.xlsx", Reason: "failed to open XLSX file: zip: not a valid zip file"}},
+		},
+		{
+			name:    "nothing to ingest",
+			entries: []zipEntry{{"readme.txt", []byte("hello")}},
+			err:     "no PDF, DOCX or XLSX files found in tender.zip",
+		},
+	}
+	for _, tt := range tests {
+		ing := &PackageIngester{maxFiles: defaultPackageMaxFiles, maxBytes: defaultPackageMaxMB << 20}
+		if tt.maxFiles > 0 {
+			ing.maxFiles = tt.maxFiles
+		}
+		if tt.maxBytes > 0 {
+			ing.maxBytes = tt.maxBytes
+		}
+
+		pkg, err := ing.Ingest(context.Background(), "tender.zip", buildZip(t, tt.entries...), "")
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		var members []string
+		var first []int
+		for _, member := range pkg.Members {
+			members = append(members, member.Filename)
+			first = append(first, member.FirstPage)
+		}
+		if !reflect.DeepEqual(members, tt.members) || !reflect.DeepEqual(first, tt.first) {
+			t.Errorf("%s: members = %q starting at %v, want %q starting at %v", tt.name, members, first, tt.members, tt.first)
+		}
+		if !reflect.DeepEqual(pkg.Skipped, tt.skipped) {
+			t.Errorf("%s: skipped = %+v, want %+v", tt.name, pkg.Skipped, tt.skipped)
+		}
+	}
+}
+
+func TestReadZipFileLimit(t *testing.T) {
+	data := buildZip(t, zipEntry{"a.txt", []byte(strings.Repeat("x", 1000))})
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+
+	tests := []struct {
+		limit int64
+		err   bool
+	}{
+		{0, true},
+		{999, true},
+		{1000, false},
+		{1 << 20, false},
+	}
+	for _, tt := range tests {
+		content, err := readZipFile(archive.File[0], tt.limit)
+		if (err != nil) != tt.err {
+			t.Errorf("readZipFile(limit %d) error = %v, want error %v", tt.limit, err, tt.err)
+		}
+		if err == nil && len(content) != 1000 {
+			t.Errorf("readZipFile(limit %d) read %d bytes, want 1000", tt.limit, len(content))
+		}
+	}
+}
+
+func TestPackageFileKind(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"tender.pdf", PackageFilePDF},
+		{"Tender.PDF", PackageFilePDF},
+		{"vol1/boq.xlsx", PackageFileXLSX},
+		{"nit.docx", PackageFileDOCX},
+		{"bundle.zip", PackageFileZIP},
+		{"old.doc", ""},
+		{"drawing.dwg", ""},
+		{"noextension", ""},
+	}
+	for _, tt := range tests {
+		if got := packageFileKind(tt.filename); got != tt.want {
+			t.Errorf("packageFileKind(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
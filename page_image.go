@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gen2brain/go-fitz"
+	"github.com/labstack/echo/v4"
+)
+
+// Page image rendering limits and highlight style
+const (
+	pageImageMinDPI = 36
+	pageImageMaxDPI = 300
+	// Highlights extend this far past the evidence, in points
+	highlightPadding = 2.0
+	// Outline width in pixels
+	highlightBorder = 2
+)
+
+var (
+	highlightFill   = color.NRGBA{R: 255, G: 213, B: 0, A: 90}
+	highlightStroke = color.NRGBA{R: 230, G: 81, B: 0, A: 220}
+)
+
+// PageSource returns the PDF holding a page of the document and the page's
+// zero-based number in it
+func (d *ParsedDocument) PageSource(page int) ([]byte, int, error) {
+	for _, member := range d.Files {
+		if page < member.FirstPage || page >= member.FirstPage+member.Pages {
+			continue
+		}
+		if member.source == nil && member.Kind == PackageFilePDF && member.encrypted {
+			return nil, 0, fmt.Errorf("page %d is from %s, an encrypted PDF whose content is not kept across restarts; upload it again to see its pages", page, member.Filename)
+		}
+		if member.source == nil {
+			return nil, 0, fmt.Errorf("page %d is from %s, a %s file, and has no image", page, member.Filename, strings.ToUpper(member.Kind))
+		}
+		return member.source, page - member.FirstPage, nil
+	}
+	return nil, 0, fmt.Errorf("page %d not found", page)
+}
+
+// RenderPageImage renders a page of a PDF as PNG at dpi, with highlight
+// rectangles drawn over boxes given in points
+func RenderPageImage(pdf []byte, pageNum int, dpi float64, boxes []BBox) ([]byte, error) {
+	doc, err := fitz.NewFromMemory(pdf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF document: %w", err)
+	}
+	defer doc.Close()
+
+	img, err := doc.ImageDPI(pageNum, dpi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render page %d: %w", pageNum+1, err)
+	}
+
+	scale := dpi / 72
+	for _, box := range boxes {
+		rect := image.Rect(
+			int((box.X0-highlightPadding)*scale), int((box.Y0-highlightPadding)*scale),
+			int((box.X1+highlightPadding)*scale), int((box.Y1+highlightPadding)*scale),
+		).Intersect(img.Bounds())
+		if rect.Empty() {
+			continue
+		}
+		draw.Draw(img, rect, image.NewUniform(highlightFill), image.Point{}, draw.Over)
+		inner := rect.Inset(highlightBorder)
+		for _, edge := range []image.Rectangle{
+			{Min: rect.Min, Max: image.Pt(rect.Max.X, inner.Min.Y)},
+			{Min: image.Pt(rect.Min.X, inner.Max.Y), Max: rect.Max},
+			{Min: image.Pt(rect.Min.X, inner.Min.Y), Max: image.Pt(inner.Min.X, inner.Max.Y)},
+			{Min: image.Pt(inner.Max.X, inner.Min.Y), Max: image.Pt(rect.Max.X, inner.Max.Y)},
+		} {
+			draw.Draw(img, edge, image.NewUniform(highlightStroke), image.Point{}, draw.Over)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode page image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeEvidence reduces text to lower-case letters and digits separated
+// by single spaces, so evidence quoted by a model matches the page text
+// whatever its punctuation and line breaks
+func normalizeEvidence(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	}), " ")
+}
+
+// findTextBoxes returns the boxes of the lines holding each occurrence of
+// query, which may run over several lines
+func findTextBoxes(lines []TextLine, query string) []BBox {
+	query = normalizeEvidence(query)
+	if query == "" {
+		return nil
+	}
+
+	// The page's lines joined, with where each starts
+	var joined strings.Builder
+	starts := make([]int, len(lines))
+	for i, line := range lines {
+		if i > 0 {
+			joined.WriteByte(' ')
+		}
+		starts[i] = joined.Len()
+		joined.WriteString(normalizeEvidence(line.Text))
+	}
+	text := joined.String()
+
+	matched := make(map[int]bool)
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], query)
+		if i < 0 {
+			break
+		}
+		start, end := offset+i, offset+i+len(query)
+		for j := range lines {
+			lineEnd := len(text)
+			if j+1 < len(lines) {
+				lineEnd = starts[j+1] - 1
+			}
+			if starts[j] < end && lineEnd > start {
+				matched[j] = true
+			}
+		}
+		offset = end
+	}
+
+	var boxes []BBox
+	for j, line := range lines {
+		if matched[j] {
+			boxes = append(boxes, line.BBox)
+		}
+	}
+	return boxes
+}
+
+// parseBBoxes reads boxes given as "x0,y0,x1,y1" in points, several
+// separated by ";"
+func parseBBoxes(value string) ([]BBox, error) {
+	var boxes []BBox
+	for _, part := range strings.Split(value, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		fields := strings.Split(part, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid bbox %q: use x0,y0,x1,y1", part)
+		}
+		var v [4]float64
+		for i, field := range fields {
+			n, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bbox %q: use x0,y0,x1,y1", part)
+			}
+			v[i] = n
+		}
+		boxes = append(boxes, BBox{X0: v[0], Y0: v[1], X1: v[2], Y1: v[3]})
+	}
+	return boxes, nil
+}
+
+// GetPageImage renders a page of a stored document as PNG. The text query
+// highlights the lines holding that text and bbox highlights boxes given in
+// points; the X-Highlight-Count header gives the number of highlights.
+func (h *TenderIQHandler) GetPageImage(c echo.Context) error {
+	document, exists := h.vectorStore.GetDocument(c.Param("id"))
+	if !exists || document.Parsed == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Document not found",
+		})
+	}
+	pageNum, err := strconv.Atoi(c.Param("n"))
+	if err != nil || pageNum < 1 || pageNum > len(document.Parsed.Pages) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	dpi := defaultPageImageDPI
+	if value := c.QueryParam("dpi"); value != "" {
+		dpi, err = strconv.Atoi(value)
+		if err != nil || dpi < pageImageMinDPI || dpi > pageImageMaxDPI {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("dpi must be between %d and %d", pageImageMinDPI, pageImageMaxDPI),
+			})
+		}
+	}
+	boxes, err := parseBBoxes(c.QueryParam("bbox"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if text := c.QueryParam("text"); text != "" {
+		boxes = append(boxes, findTextBoxes(document.Parsed.Pages[pageNum-1].Lines, text)...)
+	}
+
+	pdf, index, err := document.Parsed.PageSource(pageNum)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	rendered, err := RenderPageImage(pdf, index, float64(dpi), boxes)
+	if err != nil {
+		log.Printf("Page rendering error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to render page: " + err.Error(),
+		})
+	}
+
+	c.Response().Header().Set("X-Highlight-Count", strconv.Itoa(len(boxes)))
+	return c.Blob(http.StatusOK, "image/png", rendered)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindTextBoxes(t *testing.T) {
+	lines := []TextLine{
+		testLine("3.2 Bid Security", 50, 100),
+		testLine("The bidder shall furnish a bid security of", 50, 115),
+		testLine("Rs. 25,00,000/- (Rupees Twenty Five Lakh).", 50, 130),
+		testLine("Bid security shall be valid for 180 days.", 50, 145),
+	}
+	box := func(i int) BBox { return lines[i].BBox }
+
+	tests := []struct {
+		name  string
+		query string
+		want  []BBox
+	}{
+		{"one line", "Twenty Five Lakh", []BBox{box(2)}},
+		{"over two lines", "a bid security of Rs. 25,00,000", []BBox{box(1), box(2)}},
+		{"punctuation and case ignored", "BID-SECURITY; shall be valid", []BBox{box(3)}},
+		{"every occurrence", "bid security", []BBox{box(0), box(1), box(3)}},
+		{"not on page", "performance security", nil},
+		{"nothing to find", " ... ", nil},
+	}
+	for _, tt := range tests {
+		if got := findTextBoxes(lines, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: findTextBoxes(%q) = %+v, want %+v", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseBBoxes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []BBox
+		err   bool
+	}{
+		{"", nil, false},
+		{"10,20,110,40", []BBox{{X0: 10, Y0: 20, X1: 110, Y1: 40}}, false},
+		{" 10, 20.5 ,110,40 ; 0,0,5,5;", []BBox{{X0: 10, Y0: 20.5, X1: 110, Y1: 40}, {X0: 0, Y0: 0, X1: 5, Y1: 5}}, false},
+		{"10,20,110", nil, true},
+		{"10,20,110,forty", nil, true},
+		{"10,20,110,40;1,2", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBBoxes(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("parseBBoxes(%q) error = %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBBoxes(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeEvidence(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Rs. 25,00,000/-", "rs 25 00 000"},
+		{"  Bid\nSecurity ", "bid security"},
+		{"बोली सुरक्षा", "बोली सुरक्षा"},
+		{"---", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeEvidence(tt.text); got != tt.want {
+			t.Errorf("normalizeEvidence(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Default number of parsed documents kept in memory
+const defaultParseCacheEntries = 32
+
+// ParsedDocument is an uploaded file parsed once: its pages, metadata,
+// outline and tables. Every endpoint works from it, and it is cached by the
+// hash of the file so a file sent to several endpoints is parsed only once.
+// It is shared between requests and must not be modified.
+type ParsedDocument struct {
+	// Hash is the SHA-256 of the file content
+	Hash     string                 `json:"hash"`
+	Filename string                 `json:"filename"`
+	Pages    []PageText             `json:"pages"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Outline  *DocumentOutline       `json:"outline,omitempty"`
+	Files    []*PackageMember       `json:"files,omitempty"`
+	// Encrypted documents were opened with a password and are not cached,
+	// so their content is never served to a request without it
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	text string
+}
+
+// Text is the document text with page markers, and with file headers for a
+// package of several files
+func (d *ParsedDocument) Text() string {
+	return d.text
+}
+
+// TextFor is the document text keeping only the language of policy, or all
+// of it for the both policy
+func (d *ParsedDocument) TextFor(policy string) string {
+	policy = languagePolicyFor(d.Pages, policy)
+	if policy == LanguagePolicyBoth {
+		return d.text
+	}
+	pages := make([]PageText, len(d.Pages))
+	for i, page := range d.Pages {
+		page.Text = filterLanguage(page.Text, policy)
+		pages[i] = page
+	}
+	return joinPackagePages(d.Files, pages)
+}
+
+// PageTexts returns the text of each page with its tables as markdown,
+// keeping only the language of policy. Pages keep their places when all of
+// their text is dropped, so page numbers are unchanged.
+func (d *ParsedDocument) PageTexts(policy string) []string {
+	policy = languagePolicyFor(d.Pages, policy)
+	texts := make([]string, len(d.Pages))
+	for i, page := range d.Pages {
+		texts[i] = page.TextWithTables()
+		if policy != LanguagePolicyBoth {
+			texts[i] = filterLanguage(texts[i], policy)
+		}
+	}
+	return texts
+}
+
+// Tables returns the tables of every page in page order
+func (d *ParsedDocument) Tables() []Table {
+	var tables []Table
+	for _, page := range d.Pages {
+		tables = append(tables, page.Tables...)
+	}
+	return tables
+}
+
+// withFilename returns the document under another upload's filename; the
+// cache holds whichever name the content was first uploaded as
+func (d *ParsedDocument) withFilename(filename string) *ParsedDocument {
+	if d.Filename == filename {
+		return d
+	}
+	renamed := *d
+	renamed.Filename = filename
+	renamed.Metadata = make(map[string]interface{}, len(d.Metadata))
+	for key, value := range d.Metadata {
+		renamed.Metadata[key] = value
+	}
+	renamed.Metadata["filename"] = filename
+	return &renamed
+}
+
+// Parse reads an uploaded file into a parsed document, opening encrypted
+// PDFs with password. PDFs get their own metadata; a package lists its files
+// and the files it skipped.
+func (ing *PackageIngester) Parse(ctx context.Context, filename string, data []byte, password string) (*ParsedDocument, error) {
+	pkg, err := ing.Ingest(ctx, filename, data, password)
+	if err != nil {
+		return nil, err
+	}
+	pages := pkg.Pages()
+
+	metadata := make(map[string]interface{})
+	if packageFileKind(filename) == PackageFilePDF {
+		for key, value := range pkg.Members[0].metadata {
+			metadata[key] = value
+		}
+	}
+	// A package, or a PDF with embedded files, lists its files
+	if packageFileKind(filename) != PackageFilePDF || len(pkg.Members) > 1 {
+		metadata["num_pages"] = len(pages)
+		metadata["files"] = pkg.Members
+	}
+	if len(pkg.Skipped) > 0 {
+		metadata["skipped_files"] = pkg.Skipped
+	}
+	for key, value := range pkg.Annotations().Metadata() {
+		metadata[key] = value
+	}
+
+	// Flag each page as text-native or image-derived, and tag its language
+	for key, value := range PageSourceMetadata(pages) {
+		metadata[key] = value
+	}
+	for key, value := range PageLanguageMetadata(pages) {
+		metadata[key] = value
+	}
+	// Headers, footers and watermarks removed from the text, for audit
+	for key, value := range BoilerplateMetadata(pages) {
+		metadata[key] = value
+	}
+	metadata["filename"] = filename
+	metadata["file_size"] = len(data)
+	if pkg.Encrypted() {
+		metadata["encrypted"] = true
+	}
+
+	sum := sha256.Sum256(data)
+	return &ParsedDocument{
+		Hash:      hex.EncodeToString(sum[:]),
+		Filename:  filename,
+		Pages:     pages,
+		Metadata:  metadata,
+		Outline:   pkg.Outline(),
+		Files:     pkg.Members,
+		Encrypted: pkg.Encrypted(),
+		text:      pkg.Text(),
+	}, nil
+}
+
+// ParsedDocumentCache keeps the most recently used parsed documents in
+// memory, keyed by file hash and kind
+type ParsedDocumentCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // most recently used first
+}
+
+type parsedDocumentEntry struct {
+	key string
+	doc *ParsedDocument
+}
+
+func NewParsedDocumentCache(maxEntries int) *ParsedDocumentCache {
+	return &ParsedDocumentCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (pc *ParsedDocumentCache) Get(key string) (*ParsedDocument, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	element, ok := pc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	pc.order.MoveToFront(element)
+	return element.Value.(*parsedDocumentEntry).doc, true
+}
+
+func (pc *ParsedDocumentCache) Put(key string, doc *ParsedDocument) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if element, ok := pc.entries[key]; ok {
+		element.Value.(*parsedDocumentEntry).doc = doc
+		pc.order.MoveToFront(element)
+		return
+	}
+	pc.entries[key] = pc.order.PushFront(&parsedDocumentEntry{key: key, doc: doc})
+	for pc.order.Len() > pc.maxEntries {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*parsedDocumentEntry).key)
+	}
+}
+
+var (
+	sharedParseCacheOnce sync.Once
+	sharedParseCache     *ParsedDocumentCache
+)
+
+// parseCacheFromEnv returns the process-wide parsed document cache sized by
+// TENDERIQ_PARSE_CACHE_ENTRIES, or nil when it is disabled
+func parseCacheFromEnv() *ParsedDocumentCache {
+	sharedParseCacheOnce.Do(func() {
+		entries := getEnvInt("TENDERIQ_PARSE_CACHE_ENTRIES", defaultParseCacheEntries)
+		if entries <= 0 {
+			log.Println("Parsed document cache disabled")
+			return
+		}
+		sharedParseCache = NewParsedDocumentCache(entries)
+	})
+	return sharedParseCache
+}
+
+// DocumentLoader gives handlers the parsed document of a request: a stored
+// document named by document_id, or an uploaded file parsed through the cache
+type DocumentLoader struct {
+	ingester    *PackageIngester
+	vectorStore *VectorStore
+	cache       *ParsedDocumentCache
+}
+
+func NewDocumentLoader(ingester *PackageIngester, vectorStore *VectorStore) *DocumentLoader {
+	return &DocumentLoader{
+		ingester:    ingester,
+		vectorStore: vectorStore,
+		cache:       parseCacheFromEnv(),
+	}
+}
+
+// Parse returns the parsed document of a file, parsing it only if the same
+// content has not been parsed recently. An encrypted file is parsed every
+// time, as it is only cached when it needs no password.
+func (l *DocumentLoader) Parse(ctx context.Context, filename string, data []byte, password string) (*ParsedDocument, error) {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:]) + ":" + packageFileKind(filename)
+	if l.cache != nil {
+		if doc, ok := l.cache.Get(key); ok {
+			log.Printf("Using cached parse of %s (%s)", filename, doc.Hash[:12])
+			return doc.withFilename(filename), nil
+		}
+	}
+
+	doc, err := l.ingester.Parse(ctx, filename, data, password)
+	if err != nil {
+		return nil, err
+	}
+	if l.cache != nil && !doc.Encrypted {
+		l.cache.Put(key, doc)
+	}
+	return doc, nil
+}
+
+// DocumentRequestError is a request that names no usable document
+type DocumentRequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *DocumentRequestError) Error() string {
+	return e.Message
+}
+
+// documentErrorStatus is the HTTP status for a failure to load a document
+func documentErrorStatus(err error) int {
+	var requestErr *DocumentRequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.Status
+	}
+	if passwordErrorCode(err) != "" {
+		return http.StatusUnauthorized
+	}
+	return llmErrorStatus(err)
+}
+
+// documentErrorBody is the JSON error body for a failure to load a document.
+// Encrypted PDFs with a missing or wrong password get an error_code.
+func documentErrorBody(err error) map[string]string {
+	var requestErr *DocumentRequestError
+	if errors.As(err, &requestErr) {
+		return map[string]string{"error": requestErr.Message}
+	}
+	if code := passwordErrorCode(err); code != "" {
+		return map[string]string{
+			"error":      err.Error(),
+			"error_code": code,
+		}
+	}
+	return map[string]string{"error": "Failed to extract text from document: " + err.Error()}
+}
+
+// queryPasswordError rejects a password sent in the URL, where proxies and
+// access logs would keep it
+func queryPasswordError(c echo.Context) error {
+	if _, ok := c.Request().URL.Query()["password"]; ok {
+		return &DocumentRequestError{Status: http.StatusBadRequest, Message: "The password must be sent as a form field, not in the URL"}
+	}
+	return nil
+}
+
+// FromRequest loads the document a request names: the stored document in
+// the document_id form field, or else the file uploaded in fileField
+func (l *DocumentLoader) FromRequest(ctx context.Context, c echo.Context, fileField string) (*ParsedDocument, error) {
+	if err := queryPasswordError(c); err != nil {
+		return nil, err
+	}
+	if docID := c.FormValue("document_id"); docID != "" {
+		doc, exists := l.vectorStore.GetDocument(docID)
+		if !exists || doc.Parsed == nil {
+			return nil, &DocumentRequestError{Status: http.StatusNotFound, Message: "Document not found"}
+		}
+		return doc.Parsed, nil
+	}
+	return l.FromUpload(ctx, c, fileField)
+}
+
+// RecordUsage adds the model usage of an analysis to the total of the stored
+// document the request named in document_id. Uploads are not stored, so
+// their usage is only reported in the response.
+func (l *DocumentLoader) RecordUsage(c echo.Context, usage *UsageSummary) {
+	if docID := c.FormValue("document_id"); docID != "" {
+		l.vectorStore.RecordUsage(docID, usage)
+	}
+}
+
+// FromUpload parses the file uploaded in fileField, with the password form
+// field for encrypted PDFs
+func (l *DocumentLoader) FromUpload(ctx context.Context, c echo.Context, fileField string) (*ParsedDocument, error) {
+	if err := queryPasswordError(c); err != nil {
+		return nil, err
+	}
+	file, err := c.FormFile(fileField)
+	if err != nil {
+		return nil, &DocumentRequestError{Status: http.StatusBadRequest, Message: "No file uploaded or invalid file"}
+	}
+	// PDFs, office files and ZIP bundles of them are accepted
+	if !IsSupportedUpload(file.Filename) {
+		return nil, &DocumentRequestError{Status: http.StatusBadRequest, Message: "Only PDF, DOCX, XLSX and ZIP files are supported"}
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, &DocumentRequestError{Status: http.StatusInternalServerError, Message: "Failed to open uploaded file"}
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, &DocumentRequestError{Status: http.StatusInternalServerError, Message: "Failed to read file content"}
+	}
+
+	doc, err := l.Parse(ctx, file.Filename, data, c.Request().PostFormValue("password"))
+	if err != nil {
+		log.Printf("Document parsing error: %v", err)
+		return nil, err
+	}
+	if strings.TrimSpace(doc.Text()) == "" {
+		return nil, &DocumentRequestError{Status: http.StatusBadRequest, Message: "No text content found in document"}
+	}
+	return doc, nil
+}
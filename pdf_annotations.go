@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gen2brain/go-fitz"
+)
+
+// PDFLink is a link annotation: to a web page such as an e-procurement
+// portal or corrigendum, to another file, or to a page of the document
+type PDFLink struct {
+	Page     int    `json:"page"`
+	URI      string `json:"uri,omitempty"`
+	File     string `json:"file,omitempty"`
+	DestPage int    `json:"dest_page,omitempty"`
+	BBox     *BBox  `json:"bbox,omitempty"`
+}
+
+// PDFComment is an annotation other than a link, attachment or form field:
+// a note, stamp or markup
+type PDFComment struct {
+	Page     int    `json:"page"`
+	Type     string `json:"type"`
+	Contents string `json:"contents,omitempty"`
+	Author   string `json:"author,omitempty"`
+}
+
+// PDFAttachment is a file embedded in a PDF, on a page or in the document's
+// list of embedded files (page 0). Supported files are ingested as child
+// documents of the package, under IngestedAs.
+type PDFAttachment struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Page        int    `json:"page,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	IngestedAs  string `json:"ingested_as,omitempty"`
+
+	// object is the stream holding the file
+	object string
+}
+
+// PDFFormField is an interactive form field and its filled-in value
+type PDFFormField struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+	Page  int    `json:"page,omitempty"`
+}
+
+// PDFAnnotations are the links, comments, attachments and form fields of a PDF
+type PDFAnnotations struct {
+	Links       []PDFLink       `json:"links,omitempty"`
+	Comments    []PDFComment    `json:"comments,omitempty"`
+	Attachments []PDFAttachment `json:"attachments,omitempty"`
+	FormFields  []PDFFormField  `json:"form_fields,omitempty"`
+}
+
+// Metadata returns the non-empty lists for document metadata
+func (a *PDFAnnotations) Metadata() map[string]interface{} {
+	metadata := make(map[string]interface{})
+	if a == nil {
+		return metadata
+	}
+	if len(a.Links) > 0 {
+		metadata["links"] = a.Links
+	}
+	if len(a.Comments) > 0 {
+		metadata["annotations"] = a.Comments
+	}
+	if len(a.Attachments) > 0 {
+		metadata["attachments"] = a.Attachments
+	}
+	if len(a.FormFields) > 0 {
+		metadata["form_fields"] = a.FormFields
+	}
+	return metadata
+}
+
+// Form field types by their /FT name
+var formFieldTypes = map[string]string{
+	"Tx":  "text",
+	"Btn": "button",
+	"Ch":  "choice",
+	"Sig": "signature",
+}
+
+// ExtractAnnotations lists the links, comments, attachments and form fields
+// of a PDF with their pages. go-fitz only gives link targets, so the PDF's
+// objects are read through qpdf's JSON output; without qpdf only the links
+// are listed.
+func (p *PDFParser) ExtractAnnotations(ctx context.Context, data []byte) (*PDFAnnotations, error) {
+	out, stderr, err := runQPDF(ctx, data, "", []string{"--json=2", "--json-key=qpdf"})
+	if errors.Is(err, errQPDFNotFound) {
+		log.Printf("Warning: %v, reading PDF links only", err)
+		return fitzLinks(data)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("qpdf failed to read the PDF objects: %w: %s", err, stderr)
+	}
+
+	objects, err := parseQPDFJSON(out)
+	if err != nil {
+		return nil, err
+	}
+	return objects.annotations(), nil
+}
+
+// AttachmentData reads the content of an embedded file
+func (p *PDFParser) AttachmentData(ctx context.Context, data []byte, attachment PDFAttachment) ([]byte, error) {
+	id, gen, ok := parseObjectRef(attachment.object)
+	if !ok {
+		return nil, fmt.Errorf("attachment %s has no content", attachment.Name)
+	}
+	out, stderr, err := runQPDF(ctx, data, "", []string{fmt.Sprintf("--show-object=%d,%d", id, gen), "--filtered-stream-data"})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("qpdf failed to read attachment %s: %w: %s", attachment.Name, err, stderr)
+	}
+	return out, nil
+}
+
+// fitzLinks lists the web links of each page
+func fitzLinks(data []byte) (*PDFAnnotations, error) {
+	doc, err := fitz.NewFromMemory(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF document: %w", err)
+	}
+	defer doc.Close()
+
+	annotations := &PDFAnnotations{}
+	for pageNum := 0; pageNum < doc.NumPage(); pageNum++ {
+		links, err := doc.Links(pageNum)
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			if link.URI != "" {
+				annotations.Links = append(annotations.Links, PDFLink{Page: pageNum + 1, URI: link.URI})
+			}
+		}
+	}
+	return annotations, nil
+}
+
+// qpdfObjects are the objects of a PDF from qpdf's JSON output (version 2),
+// where references are "N G R", names "/Name" and strings "u:text" or
+// "b:hex", by "obj:N G R", with the trailer under "trailer"
+type qpdfObjects map[string]interface{}
+
+var objectRefRe = regexp.MustCompile(`^(\d+) (\d+) R$`)
+
+func parseObjectRef(ref string) (id, gen int, ok bool) {
+	m := objectRefRe.FindStringSubmatch(ref)
+	if m == nil {
+		return 0, 0, false
+	}
+	fmt.Sscanf(m[1], "%d", &id)
+	fmt.Sscanf(m[2], "%d", &gen)
+	return id, gen, true
+}
+
+func parseQPDFJSON(out []byte) (qpdfObjects, error) {
+	var doc struct {
+		QPDF []json.RawMessage `json:"qpdf"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse qpdf output: %w", err)
+	}
+	if len(doc.QPDF) < 2 {
+		return nil, fmt.Errorf("qpdf output has no objects")
+	}
+	var objects qpdfObjects
+	if err := json.Unmarshal(doc.QPDF[1], &objects); err != nil {
+		return nil, fmt.Errorf("failed to parse qpdf objects: %w", err)
+	}
+	return objects, nil
+}
+
+// resolve follows a reference to its object; streams resolve to their
+// dictionary. Other values are returned as they are.
+func (objs qpdfObjects) resolve(v interface{}) interface{} {
+	ref, ok := v.(string)
+	if !ok || !objectRefRe.MatchString(ref) {
+		return v
+	}
+	object, _ := objs["obj:"+ref].(map[string]interface{})
+	if value, ok := object["value"]; ok {
+		return value
+	}
+	if stream, ok := object["stream"].(map[string]interface{}); ok {
+		return stream["dict"]
+	}
+	return nil
+}
+
+func (objs qpdfObjects) dict(v interface{}) map[string]interface{} {
+	d, _ := objs.resolve(v).(map[string]interface{})
+	return d
+}
+
+func (objs qpdfObjects) array(v interface{}) []interface{} {
+	a, _ := objs.resolve(v).([]interface{})
+	return a
+}
+
+// text decodes a string or name value
+func (objs qpdfObjects) text(v interface{}) string {
+	s, _ := objs.resolve(v).(string)
+	switch {
+	case strings.HasPrefix(s, "u:"):
+		return s[2:]
+	case strings.HasPrefix(s, "b:"):
+		raw, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return ""
+		}
+		return decodePDFBytes(raw)
+	case strings.HasPrefix(s, "/"):
+		return s[1:]
+	}
+	return ""
+}
+
+func (objs qpdfObjects) number(v interface{}) (float64, bool) {
+	n, ok := objs.resolve(v).(float64)
+	return n, ok
+}
+
+// decodePDFBytes decodes a binary PDF string, which is UTF-16 with a byte
+// order mark or else taken as Latin-1
+func decodePDFBytes(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, binary.BigEndian.Uint16(raw[i:]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// qpdfPage is a page of the page tree with its inherited media box
+type qpdfPage struct {
+	dict     map[string]interface{}
+	mediaBox []interface{}
+}
+
+// pages walks the page tree in order
+func (objs qpdfObjects) pages() ([]qpdfPage, map[string]int) {
+	trailer, _ := objs["trailer"].(map[string]interface{})
+	root := objs.dict(objs.dict(trailer["value"])["/Root"])
+
+	var pages []qpdfPage
+	numbers := make(map[string]int)
+	visited := make(map[string]bool)
+	var walk func(node interface{}, mediaBox []interface{})
+	walk = func(node interface{}, mediaBox []interface{}) {
+		if ref, ok := node.(string); ok {
+			if visited[ref] {
+				return
+			}
+			visited[ref] = true
+		}
+		d := objs.dict(node)
+		if d == nil {
+			return
+		}
+		if box := objs.array(d["/MediaBox"]); len(box) == 4 {
+			mediaBox = box
+		}
+		if kids, ok := d["/Kids"]; ok {
+			for _, kid := range objs.array(kids) {
+				walk(kid, mediaBox)
+			}
+			return
+		}
+		pages = append(pages, qpdfPage{dict: d, mediaBox: mediaBox})
+		if ref, ok := node.(string); ok {
+			numbers[ref] = len(pages)
+		}
+	}
+	walk(root["/Pages"], nil)
+	return pages, numbers
+}
+
+// annotations reads the page annotations, the document's embedded files and
+// its form fields
+func (objs qpdfObjects) annotations() *PDFAnnotations {
+	annotations := &PDFAnnotations{}
+	pages, pageNumbers := objs.pages()
+
+	// Widgets are placed on pages by their annotation objects
+	widgetPages := make(map[string]int)
+	for i, page := range pages {
+		for _, ref := range objs.array(page.dict["/Annots"]) {
+			annot := objs.dict(ref)
+			if annot == nil {
+				continue
+			}
+			switch objs.text(annot["/Subtype"]) {
+			case "Link":
+				if link, ok := objs.link(annot, i+1, page.mediaBox, pageNumbers); ok {
+					annotations.Links = append(annotations.Links, link)
+				}
+			case "FileAttachment":
+				if attachment, ok := objs.attachment(annot["/FS"], i+1); ok {
+					annotations.Attachments = append(annotations.Attachments, attachment)
+				}
+			case "Widget":
+				if s, ok := ref.(string); ok {
+					widgetPages[s] = i + 1
+				}
+			case "Popup", "":
+			default:
+				annotations.Comments = append(annotations.Comments, PDFComment{
+					Page:     i + 1,
+					Type:     objs.text(annot["/Subtype"]),
+					Contents: strings.TrimSpace(objs.text(annot["/Contents"])),
+					Author:   objs.text(annot["/T"]),
+				})
+			}
+		}
+	}
+
+	trailer, _ := objs["trailer"].(map[string]interface{})
+	root := objs.dict(objs.dict(trailer["value"])["/Root"])
+	embedded := objs.dict(objs.dict(root["/Names"])["/EmbeddedFiles"])
+	objs.walkNameTree(embedded, make(map[string]bool), func(value interface{}) {
+		if attachment, ok := objs.attachment(value, 0); ok {
+			annotations.Attachments = append(annotations.Attachments, attachment)
+		}
+	})
+
+	acroForm := objs.dict(root["/AcroForm"])
+	visited := make(map[string]bool)
+	for _, field := range objs.array(acroForm["/Fields"]) {
+		objs.formFields(field, "", "", widgetPages, visited, &annotations.FormFields)
+	}
+	return annotations
+}
+
+// link reads a link annotation's target and position
+func (objs qpdfObjects) link(annot map[string]interface{}, page int, mediaBox []interface{}, pageNumbers map[string]int) (PDFLink, bool) {
+	link := PDFLink{Page: page}
+	dest := annot["/Dest"]
+	if action := objs.dict(annot["/A"]); action != nil {
+		switch objs.text(action["/S"]) {
+		case "URI":
+			link.URI = objs.text(action["/URI"])
+		case "GoToR", "Launch":
+			link.File = objs.fileName(action["/F"])
+		case "GoTo":
+			dest = action["/D"]
+		}
+	}
+	// Named destinations are left unresolved
+	if target := objs.array(dest); len(target) > 0 {
+		if ref, ok := target[0].(string); ok {
+			link.DestPage = pageNumbers[ref]
+		}
+	}
+	if link.URI == "" && link.File == "" && link.DestPage == 0 {
+		return link, false
+	}
+
+	rect := objs.array(annot["/Rect"])
+	if len(rect) == 4 && len(mediaBox) == 4 {
+		var r, m [4]float64
+		for i := range r {
+			r[i], _ = objs.number(rect[i])
+			m[i], _ = objs.number(mediaBox[i])
+		}
+		// PDF space starts at the bottom left, layout boxes at the top left
+		link.BBox = &BBox{X0: r[0] - m[0], Y0: m[3] - r[3], X1: r[2] - m[0], Y1: m[3] - r[1]}
+	}
+	return link, true
+}
+
+// fileName reads a file specification, which is a string or a dictionary
+func (objs qpdfObjects) fileName(spec interface{}) string {
+	if d := objs.dict(spec); d != nil {
+		if name := objs.text(d["/UF"]); name != "" {
+			return name
+		}
+		return objs.text(d["/F"])
+	}
+	return objs.text(spec)
+}
+
+// attachment reads an embedded file's specification
+func (objs qpdfObjects) attachment(spec interface{}, page int) (PDFAttachment, bool) {
+	d := objs.dict(spec)
+	if d == nil {
+		return PDFAttachment{}, false
+	}
+	files := objs.dict(d["/EF"])
+	stream, _ := files["/UF"].(string)
+	if stream == "" {
+		stream, _ = files["/F"].(string)
+	}
+	if stream == "" {
+		return PDFAttachment{}, false
+	}
+	attachment := PDFAttachment{
+		Name:        objs.fileName(spec),
+		Description: objs.text(d["/Desc"]),
+		Page:        page,
+		object:      stream,
+	}
+	if size, ok := objs.number(objs.dict(objs.dict(stream)["/Params"])["/Size"]); ok {
+		attachment.Size = int(size)
+	}
+	return attachment, true
+}
+
+// walkNameTree calls fn with every value of a name tree
+func (objs qpdfObjects) walkNameTree(node map[string]interface{}, visited map[string]bool, fn func(value interface{})) {
+	if node == nil {
+		return
+	}
+	names := objs.array(node["/Names"])
+	for i := 1; i < len(names); i += 2 {
+		fn(names[i])
+	}
+	for _, kid := range objs.array(node["/Kids"]) {
+		if ref, ok := kid.(string); ok {
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+		}
+		objs.walkNameTree(objs.dict(kid), visited, fn)
+	}
+}
+
+// formFields adds a field and its descendants to fields. Names are joined
+// with dots and the type is inherited, as in the field tree.
+func (objs qpdfObjects) formFields(ref interface{}, parentName, parentType string, widgetPages map[string]int, visited map[string]bool, fields *[]PDFFormField) {
+	if s, ok := ref.(string); ok {
+		if visited[s] {
+			return
+		}
+		visited[s] = true
+	}
+	d := objs.dict(ref)
+	if d == nil {
+		return
+	}
+	name := parentName
+	if partial := objs.text(d["/T"]); partial != "" {
+		if name != "" {
+			name += "."
+		}
+		name += partial
+	}
+	fieldType := parentType
+	if ft := objs.text(d["/FT"]); ft != "" {
+		fieldType = formFieldTypes[ft]
+	}
+
+	// Kids with names are fields of their own; kids without are the
+	// widgets placing this field on pages
+	var childFields []interface{}
+	page := 0
+	if s, ok := ref.(string); ok {
+		page = widgetPages[s]
+	}
+	for _, kid := range objs.array(d["/Kids"]) {
+		if objs.text(objs.dict(kid)["/T"]) != "" {
+			childFields = append(childFields, kid)
+		} else if s, ok := kid.(string); ok && page == 0 {
+			page = widgetPages[s]
+		}
+	}
+	if len(childFields) > 0 {
+		for _, kid := range childFields {
+			objs.formFields(kid, name, fieldType, widgetPages, visited, fields)
+		}
+		return
+	}
+	*fields = append(*fields, PDFFormField{Name: name, Type: fieldType, Value: objs.fieldValue(d["/V"], fieldType), Page: page})
+}
+
+// fieldValue reads a field's value: text, a chosen option or button state,
+// or whether a signature field is signed
+func (objs qpdfObjects) fieldValue(v interface{}, fieldType string) string {
+	if v == nil {
+		return ""
+	}
+	if fieldType == "signature" {
+		if objs.dict(v) != nil {
+			return "signed"
+		}
+		return ""
+	}
+	if values := objs.array(v); values != nil {
+		texts := make([]string, 0, len(values))
+		for _, value := range values {
+			texts = append(texts, objs.text(value))
+		}
+		return strings.Join(texts, ", ")
+	}
+	if value := objs.text(v); value != "Off" {
+		return value
+	}
+	return ""
+}
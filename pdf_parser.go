@@ -1,51 +1,343 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gen2brain/go-fitz"
 )
 
-type PDFParser struct{}
+// Where the text of a page came from
+const (
+	PageSourceText  = "text"  // the PDF's own text layer
+	PageSourceOCR   = "ocr"   // recognised by the local OCR engine
+	PageSourceImage = "image" // transcribed from the rendered page image
+	PageSourceEmpty = "empty" // no text layer and no transcription
+)
+
+// Default resolution scanned pages are rendered at for transcription
+const defaultPageImageDPI = 150
+
+// Pages whose own text has fewer characters than this are recognised from
+// the rendered image, and OCR below this confidence is handed to the
+// transcriber when there is one
+const (
+	defaultOCRMinChars      = 30
+	defaultOCRMinConfidence = 60
+)
+
+// Time allowed for local OCR of one page image. Transcription is a model
+// call and runs under the upload deadline, like every other model call.
+const pageOCRTimeout = time.Minute
+
+// PageText is the text of one page and where it came from
+type PageText struct {
+	Number int    `json:"page"`
+	Text   string `json:"-"`
+	Source string `json:"source"`
+	// Confidence is the OCR confidence (0-100) of an ocr page
+	Confidence float64 `json:"confidence,omitempty"`
+	// Lines are the positioned lines of the text layer, or of OCR, for
+	// finding text on the page image
+	Lines []TextLine `json:"-"`
+	// Boilerplate is the header, footer and watermark lines removed from the page
+	Boilerplate []BoilerplateLine `json:"-"`
+	// Language is en, hi or mixed by the script of the text, or empty for a
+	// page without letters
+	Language string `json:"language,omitempty"`
+	// TableText is the text with the page's tables as markdown, when the
+	// tables were found in its layout rather than written into Text
+	TableText string  `json:"-"`
+	Tables    []Table `json:"-"`
+}
+
+// TextWithTables is the page text with its tables as markdown, which the
+// extractors prompt with
+func (p PageText) TextWithTables() string {
+	if p.TableText != "" {
+		return p.TableText
+	}
+	return p.Text
+}
+
+// PageTranscriber turns a rendered page image into text, e.g. with a
+// multimodal model
+type PageTranscriber interface {
+	TranscribePage(ctx context.Context, png []byte, pageNum int) (string, error)
+}
+
+type PDFParser struct {
+	// ocr and transcriber read pages with too little text of their own;
+	// OCR runs first and the transcriber takes the pages it cannot read.
+	// Either may be nil.
+	ocr           OCREngine
+	transcriber   PageTranscriber
+	dpi           float64
+	ocrDPI        float64
+	minChars      int
+	minConfidence float64
+	// keepBoilerplate leaves repeated headers, footers and watermarks in
+	// the page text
+	keepBoilerplate bool
+}
 
 func NewPDFParser() *PDFParser {
 	return &PDFParser{}
 }
 
-func (p *PDFParser) ExtractText(reader io.ReaderAt, size int64) (string, error) {
-	// Read all data from ReaderAt
-	data := make([]byte, size)
-	_, err := reader.ReadAt(data, 0)
-	if err != nil {
-		return "", fmt.Errorf("failed to read PDF data: %w", err)
+// NewPDFParserWithTranscriber creates a parser that renders pages without a
+// text layer (scans, drawings) and transcribes the images
+func NewPDFParserWithTranscriber(transcriber PageTranscriber) *PDFParser {
+	return NewPDFParserWithOCR(nil, transcriber)
+}
+
+// NewPDFParserWithOCR creates a parser that recognises pages with little or
+// no text of their own with a local OCR engine, falling back to the
+// transcriber for pages the engine reads poorly
+func NewPDFParserWithOCR(ocr OCREngine, transcriber PageTranscriber) *PDFParser {
+	minChars := 1
+	if ocr != nil {
+		minChars = getEnvInt("TENDERIQ_OCR_MIN_CHARS", defaultOCRMinChars)
 	}
+	return &PDFParser{
+		ocr:             ocr,
+		transcriber:     transcriber,
+		dpi:             float64(getEnvInt("TENDERIQ_PAGE_IMAGE_DPI", defaultPageImageDPI)),
+		ocrDPI:          float64(getEnvInt("TENDERIQ_OCR_DPI", defaultOCRDPI)),
+		minChars:        minChars,
+		minConfidence:   float64(getEnvInt("TENDERIQ_OCR_MIN_CONFIDENCE", defaultOCRMinConfidence)),
+		keepBoilerplate: os.Getenv("TENDERIQ_STRIP_BOILERPLATE") == "false",
+	}
+}
 
+// ExtractPages returns the text of every page, with the tables of pages
+// with a text layer. Pages with less text than the threshold are rendered
+// and read by the OCR engine or transcriber, when the parser has them; if
+// ctx ends first the error wraps ctx.Err().
+func (p *PDFParser) ExtractPages(ctx context.Context, data []byte) ([]PageText, error) {
 	// Open PDF document from memory
 	doc, err := fitz.NewFromMemory(data)
 	if err != nil {
-		return "", fmt.Errorf("failed to open PDF document: %w", err)
+		return nil, fmt.Errorf("failed to open PDF document: %w", err)
 	}
 	defer doc.Close()
 
-	var textBuilder strings.Builder
-	
-	// Extract text from each page
-	for pageNum := 0; pageNum < doc.NumPage(); pageNum++ {
+	pages := make([]PageText, doc.NumPage())
+	layouts := make([][]TextLine, len(pages))
+	heights := make([]float64, len(pages))
+	for pageNum := range pages {
+		pages[pageNum] = PageText{Number: pageNum + 1, Source: PageSourceEmpty}
+
 		text, err := doc.Text(pageNum)
+		if err == nil && strings.TrimSpace(text) != "" {
+			pages[pageNum].Text = text
+			pages[pageNum].Source = PageSourceText
+			layouts[pageNum], heights[pageNum] = pageLayout(doc, pageNum)
+		}
+	}
+
+	// Letterheads, footers and watermarks repeat on every page; they are
+	// removed before tables are found and before pages count as scanned
+	if !p.keepBoilerplate {
+		stripBoilerplate(pages, layouts, heights)
+	}
+
+	var scanned []int
+	for pageNum := range pages {
+		if strings.TrimSpace(pages[pageNum].Text) == "" {
+			pages[pageNum].Text, pages[pageNum].Source = "", PageSourceEmpty
+		} else {
+			pages[pageNum].TableText, pages[pageNum].Tables = pageTables(pageNum, layouts[pageNum])
+			pages[pageNum].Lines = layouts[pageNum]
+		}
+		if pageChars(pages[pageNum].Text) < p.minChars {
+			scanned = append(scanned, pageNum)
+		}
+	}
+
+	if len(scanned) == 0 || (p.ocr == nil && p.transcriber == nil) {
+		tagPageLanguages(pages)
+		return pages, nil
+	}
+
+	// fitz documents are not safe for concurrent use, so rendering is
+	// serialised while recognition runs in parallel
+	log.Printf("Reading %d page(s) with little or no text layer from their images", len(scanned))
+	var renderMu sync.Mutex
+	render := func(pageNum int, dpi float64) ([]byte, error) {
+		renderMu.Lock()
+		defer renderMu.Unlock()
+		return doc.ImagePNG(pageNum, dpi)
+	}
+	runOrdered(ctx, len(scanned), chunkWorkers(), func(ctx context.Context, i int) PageText {
+		return p.recognizePage(ctx, pages[scanned[i]], render)
+	}, func(i int, page PageText) bool {
+		pages[scanned[i]] = page
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("page transcription interrupted: %w", err)
+	}
+
+	tagPageLanguages(pages)
+	return pages, nil
+}
+
+// recognizePage reads a page from its image: OCR first, then the
+// transcriber if OCR failed or is not confident. The page keeps its own
+// text unless a reading is longer.
+func (p *PDFParser) recognizePage(ctx context.Context, page PageText, render func(pageNum int, dpi float64) ([]byte, error)) PageText {
+	nativeChars := pageChars(page.Text)
+
+	if p.ocr != nil {
+		png, err := render(page.Number-1, p.ocrDPI)
 		if err != nil {
-			continue // Skip pages with errors
+			log.Printf("Warning: failed to render page %d: %v", page.Number, err)
+			return page
 		}
-		
-		if strings.TrimSpace(text) != "" {
-			textBuilder.WriteString(fmt.Sprintf("\n--- Page %d ---\n", pageNum+1))
-			textBuilder.WriteString(text)
+
+		ocrCtx, cancel := context.WithTimeout(ctx, pageOCRTimeout)
+		result, err := p.ocr.RecognizePage(ocrCtx, png, int(p.ocrDPI))
+		cancel()
+		switch {
+		case err != nil:
+			log.Printf("Warning: %s failed on page %d: %v", p.ocr.Name(), page.Number, err)
+		case pageChars(result.Text) > nativeChars:
+			page.Text, page.Source, page.Confidence = result.Text, PageSourceOCR, result.Confidence
+			page.TableText, page.Tables = "", nil
+			page.Lines = scaleLines(result.Lines, page.Number, 72/p.ocrDPI)
+			if result.Confidence >= p.minConfidence || p.transcriber == nil {
+				return page
+			}
+			log.Printf("OCR confidence %.0f on page %d is below %.0f, transcribing it", result.Confidence, page.Number, p.minConfidence)
+		}
+	}
+
+	if p.transcriber == nil {
+		return page
+	}
+	png, err := render(page.Number-1, p.dpi)
+	if err != nil {
+		log.Printf("Warning: failed to render page %d: %v", page.Number, err)
+		return page
+	}
+
+	text, err := p.transcriber.TranscribePage(ctx, png, page.Number)
+	if err != nil {
+		log.Printf("Warning: failed to transcribe page %d (%s): %v", page.Number, ClassifyLLMError(err), err)
+		return page
+	}
+	if pageChars(text) > nativeChars {
+		// Lines from OCR are kept; a transcription has no positions
+		page.Text, page.Source, page.Confidence = text, PageSourceImage, 0
+		page.TableText, page.Tables = "", nil
+	}
+	return page
+}
+
+// scaleLines places lines read from a page image in points
+func scaleLines(lines []TextLine, page int, scale float64) []TextLine {
+	scaled := make([]TextLine, len(lines))
+	for i, line := range lines {
+		line.Page = page
+		line.BBox = BBox{X0: line.BBox.X0 * scale, Y0: line.BBox.Y0 * scale, X1: line.BBox.X1 * scale, Y1: line.BBox.Y1 * scale}
+		scaled[i] = line
+	}
+	return scaled
+}
+
+// pageLayout reads the positioned lines of a page and its height in points;
+// both are zero when fitz cannot give them
+func pageLayout(doc *fitz.Document, pageNum int) ([]TextLine, float64) {
+	lines, err := pageLines(doc, pageNum)
+	if err != nil {
+		log.Printf("Warning: failed to read the layout of page %d: %v", pageNum+1, err)
+		return nil, 0
+	}
+	bounds, err := doc.Bound(pageNum)
+	if err != nil {
+		return lines, 0
+	}
+	return lines, float64(bounds.Dy())
+}
+
+// pageTables finds the tables of a page and returns them with the page text
+// rebuilt around them as markdown; the text is "" when the page has none
+func pageTables(pageNum int, lines []TextLine) (string, []Table) {
+	tables, used := detectTables(pageNum+1, lines)
+	if len(tables) == 0 {
+		return "", nil
+	}
+	return pageTextWithTables(lines, tables, used), tables
+}
+
+// pageChars counts the characters of a page's text, ignoring surrounding space
+func pageChars(text string) int {
+	return utf8.RuneCountInString(strings.TrimSpace(text))
+}
+
+// JoinPages renders pages with "--- Page N ---" markers, skipping empty ones
+func JoinPages(pages []PageText) string {
+	var textBuilder strings.Builder
+	for _, page := range pages {
+		if strings.TrimSpace(page.Text) != "" {
+			textBuilder.WriteString(fmt.Sprintf("\n--- Page %d ---\n", page.Number))
+			textBuilder.WriteString(page.Text)
 			textBuilder.WriteString("\n")
 		}
 	}
+	return textBuilder.String()
+}
+
+// PageSourceMetadata summarises where page texts came from for document metadata
+func PageSourceMetadata(pages []PageText) map[string]interface{} {
+	counts := make(map[string]int)
+	sources := make([]string, len(pages))
+	confidence := make(map[string]float64)
+	for i, page := range pages {
+		sources[i] = page.Source
+		counts[page.Source]++
+		if page.Source == PageSourceOCR {
+			confidence[strconv.Itoa(page.Number)] = math.Round(page.Confidence*10) / 10
+		}
+	}
+	metadata := map[string]interface{}{
+		"page_sources": sources,
+		"text_pages":   counts[PageSourceText],
+		"ocr_pages":    counts[PageSourceOCR],
+		"image_pages":  counts[PageSourceImage],
+		"empty_pages":  counts[PageSourceEmpty],
+	}
+	if len(confidence) > 0 {
+		// OCR confidence by page number
+		metadata["ocr_confidence"] = confidence
+	}
+	return metadata
+}
+
+func (p *PDFParser) ExtractText(ctx context.Context, reader io.ReaderAt, size int64) (string, error) {
+	// Read all data from ReaderAt
+	data := make([]byte, size)
+	_, err := reader.ReadAt(data, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PDF data: %w", err)
+	}
+
+	pages, err := p.ExtractPages(ctx, data)
+	if err != nil {
+		return "", err
+	}
 
-	extractedText := textBuilder.String()
+	extractedText := JoinPages(pages)
 	if len(strings.TrimSpace(extractedText)) == 0 {
 		return "", fmt.Errorf("no text content found in PDF")
 	}
@@ -121,33 +413,3 @@ func (p *PDFParser) ExtractMetadata(reader io.ReaderAt, size int64) (map[string]
 
 	return metadata, nil
 }
-
-// ExtractTextByPage extracts text from each page separately
-func (p *PDFParser) ExtractTextByPage(reader io.Reader) ([]string, error) {
-	// Read all data from reader
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read PDF data: %w", err)
-	}
-
-	// Open PDF document from memory
-	doc, err := fitz.NewFromMemory(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open PDF document: %w", err)
-	}
-	defer doc.Close()
-
-	var pages []string
-	
-	// Extract text from each page
-	for pageNum := 0; pageNum < doc.NumPage(); pageNum++ {
-		text, err := doc.Text(pageNum)
-		if err != nil {
-			pages = append(pages, "") // Add empty page on error
-			continue
-		}
-		pages = append(pages, text)
-	}
-
-	return pages, nil
-}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gen2brain/go-fitz"
+)
+
+// Errors for encrypted PDFs; handlers return them with their own error codes
+var (
+	ErrPasswordRequired  = errors.New("PDF is encrypted and needs a password")
+	ErrPasswordIncorrect = errors.New("PDF password is incorrect")
+)
+
+// Error codes of encrypted PDF failures in HTTP error bodies
+const (
+	ErrorCodePasswordRequired  = "password_required"
+	ErrorCodePasswordIncorrect = "password_incorrect"
+)
+
+const defaultQPDFPath = "qpdf"
+
+// passwordErrorCode returns the error code of an encrypted PDF failure, or ""
+func passwordErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrPasswordRequired):
+		return ErrorCodePasswordRequired
+	case errors.Is(err, ErrPasswordIncorrect):
+		return ErrorCodePasswordIncorrect
+	}
+	return ""
+}
+
+// Decrypt returns the content of a PDF that fitz can open. An encrypted PDF
+// is decrypted with password and reported as encrypted. go-fitz can tell
+// that a PDF needs a password but cannot authenticate one, so decryption
+// goes through qpdf (TENDERIQ_QPDF_PATH). The password is passed to qpdf on
+// stdin, never on its command line, and is not logged or kept.
+func (p *PDFParser) Decrypt(ctx context.Context, data []byte, password string) ([]byte, bool, error) {
+	doc, err := fitz.NewFromMemory(data)
+	if err == nil {
+		doc.Close()
+		return data, false, nil
+	}
+	if !errors.Is(err, fitz.ErrNeedsPassword) {
+		// Not an encryption problem; opening it later reports the error
+		return data, false, nil
+	}
+	if password == "" {
+		return nil, true, ErrPasswordRequired
+	}
+
+	decrypted, err := qpdfDecrypt(ctx, data, password)
+	if err != nil {
+		return nil, true, err
+	}
+	return decrypted, true, nil
+}
+
+// qpdfDecrypt decrypts a PDF with qpdf, reading the decrypted PDF from its
+// stdout
+func qpdfDecrypt(ctx context.Context, data []byte, password string) ([]byte, error) {
+	out, stderr, err := runQPDF(ctx, data, password+"\n", []string{"--password-file=-", "--decrypt"}, "-")
+	if err != nil {
+		if errors.Is(err, errQPDFNotFound) {
+			return nil, fmt.Errorf("encrypted PDFs need qpdf: %w", err)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if strings.Contains(strings.ToLower(stderr), "invalid password") {
+			return nil, ErrPasswordIncorrect
+		}
+		return nil, fmt.Errorf("qpdf failed to decrypt the PDF: %w: %s", err, stderr)
+	}
+	return out, nil
+}
+
+var errQPDFNotFound = errors.New("qpdf was not found")
+
+// runQPDF runs qpdf (TENDERIQ_QPDF_PATH) on a PDF written to a private temp
+// file, as qpdf needs a seekable input, and returns its stdout and stderr.
+// args go before the input file and after it; stdin is passed to qpdf as is.
+func runQPDF(ctx context.Context, data []byte, stdin string, args []string, after ...string) ([]byte, string, error) {
+	binary := os.Getenv("TENDERIQ_QPDF_PATH")
+	if binary == "" {
+		binary = defaultQPDFPath
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errQPDFNotFound, err)
+	}
+
+	in, err := os.CreateTemp("", "tenderiq_qpdf_*.pdf")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(in.Name())
+	_, err = in.Write(data)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, path, append(append(args, in.Name()), after...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Exit status 3 means qpdf succeeded with warnings
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 3) {
+		return nil, strings.TrimSpace(stderr.String()), err
+	}
+	return stdout.Bytes(), strings.TrimSpace(stderr.String()), nil
+}
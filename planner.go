@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Plan modes
+const (
+	PlanModeSingleCall = "single_call"
+	PlanModeChunked    = "chunked"
+)
+
+// How chunks are cut
+const (
+	ChunkingPages   = "pages"   // overlapping page windows
+	ChunkingOutline = "outline" // along the document outline
+)
+
+// Default token budgets. A single call is only attempted when prompt plus
+// document fit in the single-call budget; chunks are packed up to the chunk budget.
+const (
+	defaultSingleCallTokenBudget = 200000
+	defaultChunkTokenBudget      = 24000
+	defaultChunkOverlapPages     = 1
+)
+
+// Chunk is a contiguous page window of a document
+type Chunk struct {
+	StartPage int
+	EndPage   int
+	Text      string
+	PageRange string
+	Tokens    int
+	// Sections are the outline headings the chunk starts at, if it was cut along an outline
+	Sections []string
+}
+
+// ExtractionPlan records how an extractor decided to process a document
+type ExtractionPlan struct {
+	Mode             string  `json:"mode"`
+	Model            string  `json:"model"`
+	Pages            int     `json:"pages"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	DocumentTokens   int     `json:"document_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	SingleCallBudget int     `json:"single_call_budget"`
+	ChunkTokenBudget int     `json:"chunk_token_budget"`
+	ChunkCount       int     `json:"chunk_count,omitempty"`
+	Chunking         string  `json:"chunking"`
+	Estimated        bool    `json:"estimated,omitempty"`
+	Chunks           []Chunk `json:"-"`
+}
+
+// Planner counts prompt and document tokens up front and decides between a
+// single call and token-budgeted chunks
+type Planner struct {
+	llm              LLMClient
+	singleCallBudget int
+	chunkBudget      int
+	overlapPages     int
+
+	promptTokens sync.Map // model + template -> int
+}
+
+func NewPlanner(llm LLMClient) *Planner {
+	return &Planner{
+		llm:              llm,
+		singleCallBudget: getEnvInt("TENDERIQ_SINGLE_CALL_MAX_TOKENS", defaultSingleCallTokenBudget),
+		chunkBudget:      getEnvInt("TENDERIQ_CHUNK_MAX_TOKENS", defaultChunkTokenBudget),
+		overlapPages:     getEnvInt("TENDERIQ_CHUNK_OVERLAP_PAGES", defaultChunkOverlapPages),
+	}
+}
+
+// formatPages renders pages[start:end] with [PAGE:n] markers
+func formatPages(pages []string, start, end int) string {
+	var builder strings.Builder
+	for idx := start; idx < end; idx++ {
+		builder.WriteString(fmt.Sprintf("[PAGE:%d]\n%s\n\n", idx+1, pages[idx]))
+	}
+	return builder.String()
+}
+
+// countTokens asks the backend for a token count and falls back to an estimate
+func (p *Planner) countTokens(ctx context.Context, model, text string) (int, bool) {
+	if p.llm != nil {
+		n, err := p.llm.CountTokens(ctx, model, text)
+		if err == nil {
+			return n, false
+		}
+		log.Printf("Token count failed for %s, using estimate: %v", model, err)
+	}
+	return estimateTokens(text), true
+}
+
+// promptOverhead returns the token count of a prompt template, counted once per model
+func (p *Planner) promptOverhead(ctx context.Context, model, promptTemplate string) int {
+	cacheKey := model + "\x00" + promptTemplate
+	if cached, ok := p.promptTokens.Load(cacheKey); ok {
+		return cached.(int)
+	}
+
+	n, estimated := p.countTokens(ctx, model, promptTemplate)
+	if !estimated {
+		p.promptTokens.Store(cacheKey, n)
+	}
+	return n
+}
+
+// Plan decides how to process pages with page-window chunks
+func (p *Planner) Plan(ctx context.Context, model string, singleTemplate, chunkTemplate string, pages []string) *ExtractionPlan {
+	return p.PlanWithOutline(ctx, model, singleTemplate, chunkTemplate, pages, nil)
+}
+
+// PlanWithOutline decides how to process pages. singleTemplate is the prompt used for a
+// whole-document call and chunkTemplate the one used per chunk. The document
+// is counted as a whole and the total is spread over pages by length, so the
+// chunks are ready whether the plan is chunked or a single call falls back.
+// With an outline the chunks follow its sections instead of page windows.
+func (p *Planner) PlanWithOutline(ctx context.Context, model string, singleTemplate, chunkTemplate string, pages []string, outline []OutlineEntry) *ExtractionPlan {
+	plan := &ExtractionPlan{
+		Model:            model,
+		Pages:            len(pages),
+		SingleCallBudget: p.singleCallBudget,
+		ChunkTokenBudget: p.chunkBudget,
+	}
+
+	plan.PromptTokens = p.promptOverhead(ctx, model, singleTemplate)
+
+	fullText := formatPages(pages, 0, len(pages))
+	plan.DocumentTokens, plan.Estimated = p.countTokens(ctx, model, fullText)
+	plan.TotalTokens = plan.PromptTokens + plan.DocumentTokens
+
+	chunkPrompt := p.promptOverhead(ctx, model, chunkTemplate)
+	if len(outline) > 0 {
+		plan.Chunking = ChunkingOutline
+		plan.Chunks = p.ChunkSections(pages, outline, chunkPrompt, plan.DocumentTokens)
+	} else {
+		plan.Chunking = ChunkingPages
+		plan.Chunks = p.Chunk(pages, chunkPrompt, plan.DocumentTokens)
+	}
+	if plan.TotalTokens <= p.singleCallBudget {
+		plan.Mode = PlanModeSingleCall
+	} else {
+		plan.Mode = PlanModeChunked
+		plan.ChunkCount = len(plan.Chunks)
+	}
+
+	log.Printf("Plan for %s: mode=%s pages=%d prompt_tokens=%d document_tokens=%d budget=%d chunks=%d",
+		model, plan.Mode, plan.Pages, plan.PromptTokens, plan.DocumentTokens, p.singleCallBudget, plan.ChunkCount)
+
+	return plan
+}
+
+// Chunk packs consecutive pages into chunks whose prompt plus text stay
+// within the chunk budget, overlapping by the configured number of pages
+func (p *Planner) Chunk(pages []string, promptTokens, documentTokens int) []Chunk {
+	if len(pages) == 0 {
+		return make([]Chunk, 0)
+	}
+	return p.chunkPages(pages, p.pageTokens(pages, documentTokens), 0, len(pages), p.pageBudget(promptTokens))
+}
+
+// pageTokens spreads the measured document total over pages by length
+func (p *Planner) pageTokens(pages []string, documentTokens int) []int {
+	pageTokens := make([]int, len(pages))
+	estimatedTotal := 0
+	for i, page := range pages {
+		pageTokens[i] = estimateTokens(fmt.Sprintf("[PAGE:%d]\n%s\n\n", i+1, page))
+		estimatedTotal += pageTokens[i]
+	}
+	if estimatedTotal > 0 && documentTokens > 0 {
+		scale := float64(documentTokens) / float64(estimatedTotal)
+		for i := range pageTokens {
+			pageTokens[i] = int(float64(pageTokens[i])*scale) + 1
+		}
+	}
+	return pageTokens
+}
+
+// pageBudget is the chunk budget left for page text after the prompt
+func (p *Planner) pageBudget(promptTokens int) int {
+	pageBudget := p.chunkBudget - promptTokens
+	if pageBudget <= 0 {
+		pageBudget = p.chunkBudget / 2
+	}
+	return pageBudget
+}
+
+// chunkPages packs pages[from:to] into overlapping page windows
+func (p *Planner) chunkPages(pages []string, pageTokens []int, from, to, pageBudget int) []Chunk {
+	chunks := make([]Chunk, 0)
+	i := from
+	for i < to {
+		start := i
+		end := start
+		tokens := 0
+		for end < to && (end == start || tokens+pageTokens[end] <= pageBudget) {
+			tokens += pageTokens[end]
+			end++
+		}
+		if end-start == 1 && tokens > pageBudget {
+			log.Printf("Page %d alone exceeds the chunk budget (%d > %d tokens)", start+1, tokens, pageBudget)
+		}
+
+		chunks = append(chunks, newChunk(pages, pageTokens, start, end))
+
+		if end == to {
+			break
+		}
+
+		// Overlap pages, but always make progress
+		next := end - p.overlapPages
+		if next <= start {
+			next = start + 1
+		}
+		i = next
+	}
+
+	return chunks
+}
+
+// newChunk is the chunk of pages[start:end]
+func newChunk(pages []string, pageTokens []int, start, end int) Chunk {
+	tokens := 0
+	for i := start; i < end; i++ {
+		tokens += pageTokens[i]
+	}
+	return Chunk{
+		StartPage: start + 1,
+		EndPage:   end,
+		Text:      formatPages(pages, start, end),
+		PageRange: fmt.Sprintf("%d-%d", start+1, end),
+		Tokens:    tokens,
+	}
+}
+
+// Headings closer than this to the top of their page start a section on
+// that page; the page before then belongs to the previous section alone
+const outlinePageTopMargin = 100.0
+
+// outlineSpan is the pages of one outline entry, from its heading to the
+// next heading at its level or above
+type outlineSpan struct {
+	title      string
+	start, end int // page indices, end exclusive
+	// children are the entries nested under this one, in document order
+	children []OutlineEntry
+}
+
+// ChunkSections packs the sections of an outline into chunks that start at a
+// heading. Consecutive sections share a chunk while they fit the budget; a
+// section too large for one chunk is split at its subheadings, or into page
+// windows when it has none. The page a section ends on is shared with the
+// next section when that one starts lower down the page.
+func (p *Planner) ChunkSections(pages []string, outline []OutlineEntry, promptTokens, documentTokens int) []Chunk {
+	if len(pages) == 0 || len(outline) == 0 {
+		return p.Chunk(pages, promptTokens, documentTokens)
+	}
+	pageTokens := p.pageTokens(pages, documentTokens)
+	pageBudget := p.pageBudget(promptTokens)
+
+	var chunks []Chunk
+	spans := outlineSpans(outline, 0, len(pages), "")
+	p.packSpans(pages, pageTokens, pageBudget, spans, &chunks)
+	return chunks
+}
+
+// outlineSpans splits pages [from, to) at the top-level entries of outline.
+// Pages before the first heading get a leading span titled leading.
+func outlineSpans(outline []OutlineEntry, from, to int, leading string) []outlineSpan {
+	top := outline[0].Level
+	for _, entry := range outline {
+		if entry.Level < top {
+			top = entry.Level
+		}
+	}
+
+	var spans []outlineSpan
+	for i, entry := range outline {
+		if entry.Level != top {
+			if n := len(spans); n > 0 {
+				spans[n-1].children = append(spans[n-1].children, entry)
+			}
+			continue
+		}
+		start := entry.Page - 1
+		if start < from {
+			start = from
+		}
+		if start >= to {
+			continue
+		}
+		if len(spans) == 0 && start > from {
+			spans = append(spans, outlineSpan{title: leading, start: from, end: start})
+		}
+		spans = append(spans, outlineSpan{title: entry.Title, start: start, end: to})
+
+		// The span ends where the next top-level heading starts
+		for _, next := range outline[i+1:] {
+			if next.Level != top {
+				continue
+			}
+			end := next.Page
+			if next.Top <= outlinePageTopMargin {
+				end = next.Page - 1
+			}
+			spans[len(spans)-1].end = clampInt(end, start+1, to)
+			break
+		}
+	}
+	if len(spans) == 0 {
+		spans = append(spans, outlineSpan{title: leading, start: from, end: to})
+	}
+	return spans
+}
+
+// packSpans adds chunks for spans, merging small neighbours and splitting
+// large spans
+func (p *Planner) packSpans(pages []string, pageTokens []int, pageBudget int, spans []outlineSpan, chunks *[]Chunk) {
+	spanTokens := func(start, end int) int {
+		tokens := 0
+		for i := start; i < end; i++ {
+			tokens += pageTokens[i]
+		}
+		return tokens
+	}
+
+	var group []outlineSpan
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		chunk := newChunk(pages, pageTokens, group[0].start, group[len(group)-1].end)
+		for _, span := range group {
+			if span.title != "" {
+				chunk.Sections = append(chunk.Sections, span.title)
+			}
+		}
+		*chunks = append(*chunks, chunk)
+		group = nil
+	}
+
+	for _, span := range spans {
+		if spanTokens(span.start, span.end) > pageBudget {
+			flush()
+			if len(span.children) > 0 {
+				p.packSpans(pages, pageTokens, pageBudget, outlineSpans(span.children, span.start, span.end, span.title), chunks)
+			} else {
+				*chunks = append(*chunks, p.chunkPages(pages, pageTokens, span.start, span.end, pageBudget)...)
+			}
+			continue
+		}
+		if len(group) > 0 && spanTokens(group[0].start, span.end) > pageBudget {
+			flush()
+		}
+		group = append(group, span)
+	}
+	flush()
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
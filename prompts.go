@@ -0,0 +1,404 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Prompt template names
+const (
+	PromptSectionsSingle    = "sections_single"
+	PromptSectionsChunk     = "sections_chunk"
+	PromptSectionsAggregate = "sections_aggregate"
+	PromptSummarySingle     = "summary_single"
+	PromptSummaryChunk      = "summary_chunk"
+	PromptSOWSingle         = "sow_single"
+	PromptSOWChunk          = "sow_chunk"
+	PromptSOWAggregate      = "sow_aggregate"
+	PromptAnalyze           = "analyze"
+	PromptSchemaRepair      = "schema_repair"
+	PromptContinuation      = "continuation"
+	PromptCriticalFields    = "critical_fields"
+	PromptPageTranscription = "page_transcription"
+	// PromptCriticalFieldsVerify is the second prompt of a cross-check when
+	// both sides run on the same model
+	PromptCriticalFieldsVerify = "critical_fields_verify"
+)
+
+// promptPlaceholders lists the data each prompt is rendered with. A
+// template, embedded or on disk, must declare exactly these placeholders.
+var promptPlaceholders = map[string][]string{
+	PromptSectionsSingle:       {"Document"},
+	PromptSectionsChunk:        {"Document"},
+	PromptSectionsAggregate:    {"Chunks"},
+	PromptSummarySingle:        {"Document"},
+	PromptSummaryChunk:         {"Document"},
+	PromptSOWSingle:            {"Document"},
+	PromptSOWChunk:             {"Document"},
+	PromptSOWAggregate:         {"Chunks"},
+	PromptAnalyze:              {"Document", "Query"},
+	PromptSchemaRepair:         {"Errors", "Response"},
+	PromptContinuation:         {},
+	PromptCriticalFields:       {"Document"},
+	PromptPageTranscription:    {"Page"},
+	PromptCriticalFieldsVerify: {"Document"},
+}
+
+// Default prompt directory and reload interval
+const (
+	defaultPromptsDir           = "prompts"
+	defaultPromptsReloadSeconds = 10
+)
+
+// Built-in templates, used for any name not overridden on disk
+//
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+// PromptRef identifies the template version an extraction used
+type PromptRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// PromptTemplate is a named, versioned text/template. The file starts with a
+// front matter block declaring name, version and placeholders:
+//
+//	---
+//	name: sections_chunk
+//	version: 3
+//	placeholders: Document
+//	---
+//	... {{.Document}} ...
+type PromptTemplate struct {
+	Name         string
+	Version      string
+	Description  string
+	Placeholders []string
+	// Text is the template body, used to measure prompt overhead
+	Text   string
+	Source string
+
+	tmpl *template.Template
+}
+
+// Ref returns the template's name and version
+func (t *PromptTemplate) Ref() PromptRef {
+	return PromptRef{Name: t.Name, Version: t.Version}
+}
+
+// Render fills the placeholders; every declared placeholder must be given
+func (t *PromptTemplate) Render(data map[string]string) (string, error) {
+	for _, name := range t.Placeholders {
+		if _, ok := data[name]; !ok {
+			return "", fmt.Errorf("prompt %s v%s: missing placeholder %s", t.Name, t.Version, name)
+		}
+	}
+
+	var builder strings.Builder
+	if err := t.tmpl.Execute(&builder, data); err != nil {
+		return "", fmt.Errorf("prompt %s v%s: %w", t.Name, t.Version, err)
+	}
+	return builder.String(), nil
+}
+
+// ParsePromptTemplate parses a template file and checks that the
+// placeholders used in the body match the declared ones exactly, and that
+// those are the ones the prompt is rendered with
+func ParsePromptTemplate(source string, data []byte) (*PromptTemplate, error) {
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(content, "---\n") {
+		return nil, fmt.Errorf("%s: missing front matter", source)
+	}
+	header, body, found := strings.Cut(content[len("---\n"):], "\n---\n")
+	if !found {
+		return nil, fmt.Errorf("%s: unterminated front matter", source)
+	}
+
+	t := &PromptTemplate{
+		Text:   strings.TrimSuffix(body, "\n"),
+		Source: source,
+	}
+	for _, line := range strings.Split(header, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "name":
+			t.Name = value
+		case "version":
+			t.Version = value
+		case "description":
+			t.Description = value
+		case "placeholders":
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					t.Placeholders = append(t.Placeholders, name)
+				}
+			}
+		}
+	}
+	if t.Name == "" || t.Version == "" {
+		return nil, fmt.Errorf("%s: front matter needs name and version", source)
+	}
+	expected, ok := promptPlaceholders[t.Name]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown prompt template %q", source, t.Name)
+	}
+	if !sameStrings(t.Placeholders, expected) {
+		return nil, fmt.Errorf("%s: prompt %s declares placeholders %q, but is rendered with %q", source, t.Name, t.Placeholders, expected)
+	}
+
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(t.Text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	t.tmpl = tmpl
+
+	// Placeholders used in the body must match the declared ones
+	used := make(map[string]bool)
+	collectTemplateFields(tmpl.Tree.Root, used)
+	declared := make(map[string]bool)
+	for _, name := range t.Placeholders {
+		declared[name] = true
+		if !used[name] {
+			return nil, fmt.Errorf("%s: placeholder %s is declared but never used", source, name)
+		}
+	}
+	for name := range used {
+		if !declared[name] {
+			return nil, fmt.Errorf("%s: placeholder %s is used but not declared", source, name)
+		}
+	}
+
+	return t, nil
+}
+
+// sameStrings reports whether a and b hold the same strings in any order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string(nil), a...)
+	sortedB := append([]string(nil), b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// collectTemplateFields records the top-level fields ({{.Name}}) a template references
+func collectTemplateFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateFields(child, fields)
+		}
+	case *parse.ActionNode:
+		collectTemplateFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectTemplateFields(cmd, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectTemplateFields(arg, fields)
+		}
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.IfNode:
+		collectTemplateFields(n.Pipe, fields)
+		collectTemplateFields(n.List, fields)
+		collectTemplateFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		collectTemplateFields(n.Pipe, fields)
+		collectTemplateFields(n.List, fields)
+		collectTemplateFields(n.ElseList, fields)
+	case *parse.WithNode:
+		collectTemplateFields(n.Pipe, fields)
+		collectTemplateFields(n.List, fields)
+		collectTemplateFields(n.ElseList, fields)
+	case *parse.TemplateNode:
+		collectTemplateFields(n.Pipe, fields)
+	}
+}
+
+// PromptRegistry holds the current version of every prompt template. The
+// embedded defaults are loaded first; .tmpl files in dir override them by
+// name and are reloaded when they change.
+type PromptRegistry struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[string]*PromptTemplate
+	modTimes  map[string]time.Time
+}
+
+func NewPromptRegistry(dir string) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		dir:       dir,
+		templates: make(map[string]*PromptTemplate),
+		modTimes:  make(map[string]time.Time),
+	}
+
+	entries, err := fs.Glob(embeddedPrompts, "prompts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range entries {
+		data, err := embeddedPrompts.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		t, err := ParsePromptTemplate("embedded:"+path, data)
+		if err != nil {
+			return nil, err
+		}
+		r.templates[t.Name] = t
+	}
+
+	r.Reload()
+	return r, nil
+}
+
+var (
+	promptRegistryOnce sync.Once
+	sharedPrompts      *PromptRegistry
+)
+
+// promptRegistry returns the process-wide registry for TENDERIQ_PROMPTS_DIR
+func promptRegistry() *PromptRegistry {
+	promptRegistryOnce.Do(func() {
+		dir := os.Getenv("TENDERIQ_PROMPTS_DIR")
+		if dir == "" {
+			dir = defaultPromptsDir
+		}
+
+		registry, err := NewPromptRegistry(dir)
+		if err != nil {
+			// The embedded templates are part of the build and must parse
+			panic(fmt.Sprintf("invalid embedded prompt templates: %v", err))
+		}
+		sharedPrompts = registry
+	})
+	return sharedPrompts
+}
+
+// Get returns the current version of a template
+func (r *PromptRegistry) Get(name string) *PromptTemplate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.templates[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown prompt template %q", name))
+	}
+	return t
+}
+
+// List returns every template sorted by name
+func (r *PromptRegistry) List() []*PromptTemplate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*PromptTemplate, 0, len(r.templates))
+	for _, t := range r.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Reload loads new or changed .tmpl files from the directory. A file that
+// fails to parse or validate is logged and the previous version kept.
+func (r *PromptRegistry) Reload() {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.tmpl"))
+	if err != nil || len(paths) == 0 {
+		return
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		r.mu.RLock()
+		seen, known := r.modTimes[path]
+		r.mu.RUnlock()
+		if known && info.ModTime().Equal(seen) {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: failed to read prompt template %s: %v", path, err)
+			continue
+		}
+		t, err := ParsePromptTemplate(path, data)
+
+		r.mu.Lock()
+		r.modTimes[path] = info.ModTime()
+		if err != nil {
+			r.mu.Unlock()
+			log.Printf("Warning: keeping previous prompt template, %v", err)
+			continue
+		}
+		previous := r.templates[t.Name]
+		r.templates[t.Name] = t
+		r.mu.Unlock()
+
+		if previous == nil || previous.Version != t.Version || previous.Text != t.Text {
+			log.Printf("Loaded prompt template %s v%s from %s", t.Name, t.Version, path)
+		}
+	}
+}
+
+// Watch reloads the directory every interval; run it in its own goroutine
+func (r *PromptRegistry) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.Reload()
+	}
+}
+
+// renderPrompt renders the current version of a template and returns its reference
+func renderPrompt(name string, data map[string]string) (string, PromptRef, error) {
+	t := promptRegistry().Get(name)
+	text, err := t.Render(data)
+	return text, t.Ref(), err
+}
+
+// appendPromptRef adds ref to refs unless it is already there
+func appendPromptRef(refs []PromptRef, ref PromptRef) []PromptRef {
+	for _, existing := range refs {
+		if existing == ref {
+			return refs
+		}
+	}
+	return append(refs, ref)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// promptFile is a template file for name declaring placeholders, with a
+// body that uses each of them
+func promptFile(name, version, placeholders, body string) []byte {
+	return []byte("---\nname: " + name + "\nversion: " + version + "\nplaceholders: " + placeholders + "\n---\n" + body + "\n")
+}
+
+func TestPromptRegistryOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    []byte
+		prompt  string
+		version string
+	}{
+		{"matching placeholders", promptFile(PromptAnalyze, "9", "Query, Document", "{{.Document}} {{.Query}}"), PromptAnalyze, "9"},
+		{"placeholder missing", promptFile(PromptAnalyze, "9", "Document", "{{.Document}}"), PromptAnalyze, ""},
+		{"placeholder not rendered", promptFile(PromptSOWChunk, "9", "Document, Tender", "{{.Document}} {{.Tender}}"), PromptSOWChunk, ""},
+		{"placeholder renamed", promptFile(PromptSOWChunk, "9", "Text", "{{.Text}}"), PromptSOWChunk, ""},
+		{"no placeholders", promptFile(PromptContinuation, "9", "", "Continue."), PromptContinuation, "9"},
+	}
+	for _, tt := range tests {
+		embedded, err := NewPromptRegistry(t.TempDir())
+		if err != nil {
+			t.Fatalf("%s: failed to load embedded templates: %v", tt.name, err)
+		}
+		want := tt.version
+		if want == "" {
+			want = embedded.Get(tt.prompt).Version
+		}
+
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, tt.prompt+".tmpl"), tt.file, 0644)
+		registry, err := NewPromptRegistry(dir)
+		if err != nil {
+			t.Fatalf("%s: failed to load templates: %v", tt.name, err)
+		}
+		if got := registry.Get(tt.prompt).Version; got != want {
+			t.Errorf("%s: version %s loaded, want %s", tt.name, got, want)
+		}
+	}
+}
+
+func TestPromptRegistryReloadKeepsPrevious(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "analyze.tmpl")
+	os.WriteFile(path, promptFile(PromptAnalyze, "9", "Document, Query", "{{.Document}} {{.Query}}"), 0644)
+	registry, err := NewPromptRegistry(dir)
+	if err != nil {
+		t.Fatalf("failed to load templates: %v", err)
+	}
+
+	// A later edit drops a placeholder the handler renders with
+	os.WriteFile(path, promptFile(PromptAnalyze, "10", "Document", "{{.Document}}"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	registry.Reload()
+	if got := registry.Get(PromptAnalyze).Version; got != "9" {
+		t.Errorf("version %s after a bad reload, want 9", got)
+	}
+
+	// A file under a name no code renders is not loaded
+	os.WriteFile(filepath.Join(dir, "extra.tmpl"), promptFile("extra", "1", "Document", "{{.Document}}"), 0644)
+	registry.Reload()
+	for _, prompt := range registry.List() {
+		if prompt.Name == "extra" {
+			t.Errorf("unknown template %s was loaded", prompt.Name)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default per-model quotas (requests/min, tokens/min). Override with
+// TENDERIQ_RATE_LIMITS="model=rpm:tpm,model=rpm:tpm".
+var defaultRateLimits = map[string][2]float64{
+	ModelGeminiPro:   {150, 2000000},
+	ModelGeminiFlash: {1000, 1000000},
+}
+
+var fallbackRateLimit = [2]float64{60, 1000000}
+
+// TokenBucketLimiter enforces a requests-per-minute and a tokens-per-minute
+// budget with two token buckets that refill continuously
+type TokenBucketLimiter struct {
+	mu             sync.Mutex
+	requestsPerMin float64
+	tokensPerMin   float64
+	requests       float64
+	tokens         float64
+	last           time.Time
+}
+
+func NewTokenBucketLimiter(requestsPerMin, tokensPerMin float64) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		requestsPerMin: requestsPerMin,
+		tokensPerMin:   tokensPerMin,
+		requests:       requestsPerMin,
+		tokens:         tokensPerMin,
+		last:           time.Now(),
+	}
+}
+
+// refill adds the budget accrued since the last call; callers hold l.mu
+func (l *TokenBucketLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Minutes()
+	l.last = now
+	l.requests = minFloat(l.requestsPerMin, l.requests+elapsed*l.requestsPerMin)
+	l.tokens = minFloat(l.tokensPerMin, l.tokens+elapsed*l.tokensPerMin)
+}
+
+// Wait blocks until one request of the given token size fits in both
+// buckets, or ctx is done
+func (l *TokenBucketLimiter) Wait(ctx context.Context, tokens int) error {
+	need := float64(tokens)
+	// A request larger than the whole bucket would never fit; let it through
+	// once the bucket is full instead of blocking forever
+	if need > l.tokensPerMin {
+		need = l.tokensPerMin
+	}
+
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.requests >= 1 && l.tokens >= need {
+			l.requests--
+			l.tokens -= need
+			l.mu.Unlock()
+			return nil
+		}
+
+		// Time until both buckets hold enough
+		var wait time.Duration
+		if l.requests < 1 {
+			wait = time.Duration((1 - l.requests) / l.requestsPerMin * float64(time.Minute))
+		}
+		if l.tokens < need {
+			tokenWait := time.Duration((need - l.tokens) / l.tokensPerMin * float64(time.Minute))
+			if tokenWait > wait {
+				wait = tokenWait
+			}
+		}
+		l.mu.Unlock()
+
+		if wait < 10*time.Millisecond {
+			wait = 10 * time.Millisecond
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Process-wide limiters, one per model name
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*TokenBucketLimiter)
+)
+
+// rateLimiterFor returns the shared limiter for a model, creating it on first use
+func rateLimiterFor(model string) *TokenBucketLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	if limiter, ok := rateLimiters[model]; ok {
+		return limiter
+	}
+
+	limits := rateLimitFromEnv(model)
+	limiter := NewTokenBucketLimiter(limits[0], limits[1])
+	rateLimiters[model] = limiter
+	log.Printf("Rate limiter for %s: %.0f requests/min, %.0f tokens/min", model, limits[0], limits[1])
+	return limiter
+}
+
+// rateLimitFromEnv looks up a model in TENDERIQ_RATE_LIMITS, then the defaults
+func rateLimitFromEnv(model string) [2]float64 {
+	for _, entry := range strings.Split(os.Getenv("TENDERIQ_RATE_LIMITS"), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name != model {
+			continue
+		}
+
+		rpm, tpm, ok := strings.Cut(value, ":")
+		requests, err1 := strconv.ParseFloat(rpm, 64)
+		tokens, err2 := strconv.ParseFloat(tpm, 64)
+		if !ok || err1 != nil || err2 != nil || requests <= 0 || tokens <= 0 {
+			log.Printf("Warning: invalid rate limit %q in TENDERIQ_RATE_LIMITS", entry)
+			break
+		}
+		return [2]float64{requests, tokens}
+	}
+
+	if limits, ok := defaultRateLimits[model]; ok {
+		return limits
+	}
+	return fallbackRateLimit
+}
+
+// RateLimitedLLMClient makes every call wait for the model's shared limiter,
+// then starts the attempt timeout set by the retry client
+type RateLimitedLLMClient struct {
+	next LLMClient
+}
+
+func NewRateLimitedLLMClient(next LLMClient) *RateLimitedLLMClient {
+	return &RateLimitedLLMClient{next: next}
+}
+
+func (r *RateLimitedLLMClient) Provider() string {
+	return r.next.Provider()
+}
+
+func (r *RateLimitedLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	// Budget the prompt plus the maximum output the call may produce
+	tokens := estimateTokens(req.System+req.Prompt) + int(req.Config.MaxOutputTokens)
+	if err := rateLimiterFor(req.Model).Wait(ctx, tokens); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := attemptContext(ctx)
+	defer cancel()
+	return r.next.Generate(ctx, req)
+}
+
+func (r *RateLimitedLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return r.next.CountTokens(ctx, model, text)
+}
+
+func (r *RateLimitedLLMClient) Close() {
+	if closer, ok := r.next.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
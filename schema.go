@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schema is the subset of JSON Schema we generate from Go structs and send
+// to the models as a structured-output constraint
+type Schema struct {
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+}
+
+var schemaCache sync.Map // reflect.Type -> *Schema
+
+// SchemaFor builds (and caches) the response schema for the type of v.
+// Field names follow the json tags; fields without omitempty are required.
+func SchemaFor(v interface{}) *Schema {
+	t := reflect.TypeOf(v)
+	if cached, ok := schemaCache.Load(t); ok {
+		return cached.(*Schema)
+	}
+
+	schema := schemaForType(t)
+	schemaCache.Store(t, schema)
+	return schema
+}
+
+func schemaForType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			// schema:"-" marks fields the service fills in after decoding
+			name, omitempty := jsonFieldName(field)
+			if name == "-" || field.Tag.Get("schema") == "-" {
+				continue
+			}
+
+			schema.Properties[name] = schemaForType(field.Type)
+			if !omitempty {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema
+	default:
+		// interface{} and friends accept any value
+		return &Schema{}
+	}
+}
+
+// jsonFieldName returns the JSON name of a struct field and whether it is omitempty
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+
+	omitempty := false
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+// String renders the schema as indented JSON for prompts and logs
+func (s *Schema) String() string {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// Validate checks that data is a single JSON value matching the schema and
+// returns one message per violation
+func (s *Schema) Validate(data []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("$: invalid JSON: %v", err)}
+	}
+	if _, err := dec.Token(); err == nil {
+		return []string{"$: unexpected content after the JSON value"}
+	}
+
+	var errs []string
+	s.validateValue("$", value, &errs)
+	return errs
+}
+
+func (s *Schema) validateValue(path string, value interface{}, errs *[]string) {
+	if s.Type == "" {
+		return
+	}
+
+	switch s.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected string, got %s", path, jsonTypeName(value)))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected boolean, got %s", path, jsonTypeName(value)))
+		}
+	case "integer":
+		num, ok := value.(json.Number)
+		if ok {
+			_, err := num.Int64()
+			ok = err == nil
+		}
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected integer, got %s", path, jsonTypeName(value)))
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected number, got %s", path, jsonTypeName(value)))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected array, got %s", path, jsonTypeName(value)))
+			return
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				s.Items.validateValue(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: expected object, got %s", path, jsonTypeName(value)))
+			return
+		}
+		for _, name := range s.Required {
+			if _, present := obj[name]; !present {
+				*errs = append(*errs, fmt.Sprintf("%s.%s: required property is missing", path, name))
+			}
+		}
+
+		// Sort keys so the messages are stable across runs
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			propSchema, known := s.Properties[key]
+			if !known {
+				if s.Properties != nil {
+					*errs = append(*errs, fmt.Sprintf("%s.%s: unknown property", path, key))
+				}
+				continue
+			}
+			propSchema.validateValue(path+"."+key, obj[key], errs)
+		}
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
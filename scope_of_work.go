@@ -6,13 +6,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
 	"strings"
-	"time"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/labstack/echo/v4"
-	"google.golang.org/api/option"
 )
 
 // Scope of Work data structures
@@ -44,213 +40,56 @@ type ScopeOfWorkData struct {
 }
 
 type SOWExtractionResult struct {
-	Mode                string              `json:"mode"`
-	Final               ScopeOfWorkData     `json:"final"`
-	ChunkParsedList     []ScopeOfWorkData   `json:"chunk_parsed_list,omitempty"`
-	RawSingle           string              `json:"raw_single,omitempty"`
-	Error               string              `json:"error,omitempty"`
+	Mode            string            `json:"mode"`
+	Status          string            `json:"status"`
+	Final           ScopeOfWorkData   `json:"final"`
+	ChunkParsedList []ScopeOfWorkData `json:"chunk_parsed_list,omitempty"`
+	RawSingle       string            `json:"raw_single,omitempty"`
+	Repairs         int               `json:"repairs,omitempty"`
+	Plan            *ExtractionPlan   `json:"plan,omitempty"`
+	Cache           *LLMCacheStats    `json:"cache,omitempty"`
+	Usage           *UsageSummary     `json:"usage,omitempty"`
+	Prompts         []PromptRef       `json:"prompts,omitempty"`
+	Error           string            `json:"error,omitempty"`
 }
 
 // Prompts
-const SINGLE_CALL_PROMPT = `You are an expert document parser. Extract ONLY the following three structured fields from the DOCUMENT (do not invent anything):
-
-1) project_overview: JSON object with keys:
-   - project_name
-   - location
-   - total_length
-   - project_duration
-   - contract_value
-
-   If a field is not present, set it to an empty string.
-
-2) major_work_components: array of objects:
-   [
-     { "s_no":"...", "work_description":"...", "quantity_specification":"...", "unit":"..." },
-     ...
-   ]
-   If none present, return an empty list.
-
-3) technical_standards: array of objects:
-   [
-     { "component":"...", "standard_specification":"...", "compliance_required":"..." },
-     ...
-   ]
-   If none present, return an empty list.
-
-IMPORTANT:
-• RESPOND IN VALID JSON ONLY with EXACT KEYS: {"project_overview": {...}, "major_work_components": [...], "technical_standards": [...]}
-• Do NOT add explanations, do NOT include any text outside the single JSON object.
-• Include brief page references where you can (e.g., "(page 4)") in values when the source is clear.
-
-DOCUMENT:
-<<<DOC>>>`
-
-const CHUNK_EXTRACTION_PROMPT = `You are a document parser. From the provided DOCUMENT CHUNK extract ONLY the three structured fields as JSON:
-• project_overview: {project_name, location, total_length, project_duration, contract_value}
-• major_work_components: [{"s_no","work_description","quantity_specification","unit"}]
-• technical_standards: [{"component","standard_specification","compliance_required"}]
-
-Return a single VALID JSON object only.
-
-DOCUMENT CHUNK:
-<<<DOC>>>`
-
-const AGGREGATION_PROMPT = `You are given multiple JSON extraction results (chunk-level). Combine them into a single consolidated JSON with schema:
-{
-  "project_overview": { "project_name":"...", "location":"...", "total_length":"...", "project_duration":"...", "contract_value":"..." },
-  "major_work_components": [ ... ],
-  "technical_standards": [ ... ]
-}
-
-Rules:
-• Prefer non-empty values for project_overview; if multiple conflicting non-empty values exist prefer page-referenced values or the value that appears most frequently.
-• Merge lists and deduplicate exact duplicates (case-insensitive).
-• Do not invent values not present in chunk results.
-
-Chunk findings:
-<<<CHUNKS_JSON>>>`
-
 type SOWExtractor struct {
 	geminiService *GeminiService
-	apiKey        string
+	llm           LLMClient
+	config        GenerationConfig
 }
 
-func NewSOWExtractor(geminiService *GeminiService, apiKey string) *SOWExtractor {
+func NewSOWExtractor(geminiService *GeminiService) *SOWExtractor {
 	return &SOWExtractor{
 		geminiService: geminiService,
-		apiKey:        apiKey,
-	}
-}
-
-// Clean and parse JSON response
-func (s *SOWExtractor) cleanModelOutput(raw string) string {
-	if raw == "" {
-		return ""
-	}
-	// Remove markdown code blocks
-	re := regexp.MustCompile("```(?:json)?\\s*")
-	cleaned := re.ReplaceAllString(raw, "")
-	cleaned = strings.ReplaceAll(cleaned, "```", "")
-	return strings.TrimSpace(cleaned)
-}
-
-func (s *SOWExtractor) extractJSONBlock(text string) string {
-	if text == "" {
-		return ""
+		llm:           geminiService.llm,
+		config: GenerationConfig{
+			Temperature:     temperature(0.1),
+			TopP:            0.8,
+			TopK:            40,
+			MaxOutputTokens: 8192,
+		},
 	}
-	// Find JSON objects
-	re := regexp.MustCompile(`(\{[\s\S]*\})`)
-	matches := re.FindAllString(text, -1)
-	if len(matches) == 0 {
-		return ""
-	}
-	// Return the longest match
-	longest := ""
-	for _, match := range matches {
-		if len(match) > len(longest) {
-			longest = match
-		}
-	}
-	return longest
 }
 
-func (s *SOWExtractor) safeParseJSON(raw string) (*ScopeOfWorkData, error) {
-	if raw == "" {
-		return nil, fmt.Errorf("empty response")
-	}
-	
-	cleaned := s.cleanModelOutput(raw)
-	
-	// Try direct parsing first
-	var result ScopeOfWorkData
-	if err := json.Unmarshal([]byte(cleaned), &result); err == nil {
-		return &result, nil
-	}
-	
-	// Extract JSON block and try again
-	block := s.extractJSONBlock(cleaned)
-	if block != "" {
-		if err := json.Unmarshal([]byte(block), &result); err == nil {
-			return &result, nil
-		}
+// Call the configured model with the scope of work schema
+func (s *SOWExtractor) callModelForPrompt(ctx context.Context, prompt string, modelName string) (*ScopeOfWorkData, *StructuredResult, error) {
+	if s.llm == nil {
+		return nil, nil, fmt.Errorf("gemini client not initialized")
 	}
-	
-	return nil, fmt.Errorf("failed to parse JSON response")
-}
 
-// Call Gemini model
-func (s *SOWExtractor) callModelForPrompt(ctx context.Context, prompt string, modelName string) (*ScopeOfWorkData, string, error) {
-	client, err := genai.NewClient(ctx, option.WithAPIKey(s.apiKey))
+	var parsed ScopeOfWorkData
+	result, err := generateStructured(ctx, s.llm, LLMRequest{
+		Model:  modelName,
+		Prompt: prompt,
+		Config: s.config,
+	}, &parsed)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create Gemini client: %v", err)
-	}
-	defer client.Close()
-
-	model := client.GenerativeModel(modelName)
-	
-	// Configure model parameters
-	model.SetTemperature(0.1)
-	model.SetTopP(0.8)
-	model.SetTopK(40)
-	model.SetMaxOutputTokens(8192)
-
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		return nil, "", fmt.Errorf("model generation failed: %v", err)
-	}
-
-	if len(resp.Candidates) == 0 {
-		return nil, "", fmt.Errorf("no response candidates")
-	}
-
-	var rawText strings.Builder
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if txt, ok := part.(genai.Text); ok {
-			rawText.WriteString(string(txt))
-		}
-	}
-
-	raw := rawText.String()
-	parsed, err := s.safeParseJSON(raw)
-	
-	return parsed, raw, err
-}
-
-// Chunk-based extraction
-func (s *SOWExtractor) makeChunksFromPages(pages []string, pagesPerChunk int, overlapPages int) []map[string]interface{} {
-	var chunks []map[string]interface{}
-	n := len(pages)
-	if n == 0 {
-		return chunks
-	}
-
-	i := 0
-	for i < n {
-		start := i
-		end := i + pagesPerChunk
-		if end > n {
-			end = n
-		}
-
-		var textParts []string
-		for idx := start; idx < end; idx++ {
-			textParts = append(textParts, fmt.Sprintf("[PAGE:%d]\n%s", idx+1, pages[idx]))
-		}
-
-		chunk := map[string]interface{}{
-			"start_page": start + 1,
-			"end_page":   end,
-			"text":       strings.Join(textParts, "\n\n"),
-		}
-		chunks = append(chunks, chunk)
-
-		if end == n {
-			break
-		}
-		i = end - overlapPages
+		return nil, result, fmt.Errorf("model generation failed: %w", err)
 	}
 
-	return chunks
+	return &parsed, result, nil
 }
 
 // Programmatic merge for fallback
@@ -307,77 +146,167 @@ func (s *SOWExtractor) programmaticMerge(chunkResults []ScopeOfWorkData) ScopeOf
 	return final
 }
 
-// Main extraction function with fallback
-func (s *SOWExtractor) ExtractSOW(ctx context.Context, pages []string) (*SOWExtractionResult, error) {
+// ExtractSOW extracts the scope of work of a parsed document, reading its
+// tables as markdown and the text of the language policy of ctx
+func (s *SOWExtractor) ExtractSOW(ctx context.Context, doc *ParsedDocument) (*SOWExtractionResult, error) {
+	return s.ExtractSOWFromPages(ctx, doc.PageTexts(languagePolicyFrom(ctx)))
+}
+
+// ExtractSOWFromPages is the main extraction function with fallback, on page texts
+func (s *SOWExtractor) ExtractSOWFromPages(ctx context.Context, pages []string) (*SOWExtractionResult, error) {
 	if len(pages) == 0 {
 		return nil, fmt.Errorf("no pages provided")
 	}
 
 	log.Printf("Starting SOW extraction for %d pages", len(pages))
+	ctx = WithLLMExtractor(ctx, ExtractorSOW)
+
+	// Count tokens up front and decide between a single call and chunks
+	models := s.geminiService.routes[ExtractorSOW]
+
+	// Templates are fetched once so a reload cannot mix versions within one extraction
+	prompts := promptRegistry()
+	singleTmpl := prompts.Get(PromptSOWSingle)
+	chunkTmpl := prompts.Get(PromptSOWChunk)
+	aggregateTmpl := prompts.Get(PromptSOWAggregate)
+	plan := s.geminiService.planner.Plan(ctx, models.Pro, singleTmpl.Text, chunkTmpl.Text, pages)
+
+	repairs := 0
+	var promptRefs []PromptRef
+	if plan.Mode == PlanModeSingleCall {
+		// 1. Try single-call with gemini-2.5-pro
+		log.Println("Attempting single-call extraction with gemini-2.5-pro")
+		fullText := formatPages(pages, 0, len(pages))
+		singlePrompt, err := singleTmpl.Render(map[string]string{"Document": fullText})
+		if err != nil {
+			return nil, err
+		}
+		promptRefs = append(promptRefs, singleTmpl.Ref())
+
+		parsed, single, err := s.callModelForPrompt(WithUsageMode(ctx, UsageModeSingleCall), singlePrompt, models.Pro)
+		if err == nil {
+			log.Println("Single-call extraction successful")
+			return &SOWExtractionResult{
+				Mode:      "single_call",
+				Status:    ResultStatusComplete,
+				Final:     *parsed,
+				RawSingle: single.Raw,
+				Repairs:   single.Repairs,
+				Plan:      plan,
+				Prompts:   promptRefs,
+			}, nil
+		}
 
-	// Prepare full document text
-	var fullTextParts []string
-	for i, page := range pages {
-		fullTextParts = append(fullTextParts, fmt.Sprintf("[PAGE:%d]\n%s", i+1, page))
-	}
-	fullText := strings.Join(fullTextParts, "\n\n")
-
-	// 1. Try single-call with gemini-2.5-pro
-	log.Println("Attempting single-call extraction with gemini-2.5-pro")
-	singlePrompt := strings.ReplaceAll(SINGLE_CALL_PROMPT, "<<<DOC>>>", fullText)
-	
-	parsed, rawSingle, err := s.callModelForPrompt(ctx, singlePrompt, "gemini-2.5-pro")
-	if err == nil && parsed != nil {
-		log.Println("Single-call extraction successful")
-		return &SOWExtractionResult{
-			Mode:      "single_call",
-			Final:     *parsed,
-			RawSingle: rawSingle,
-		}, nil
+		if single != nil {
+			repairs += single.Repairs
+		}
+		if ctx.Err() != nil {
+			// No budget left for chunks; return the empty result with its status
+			return &SOWExtractionResult{
+				Mode:   "single_call",
+				Status: logInterrupted(ctx, "Scope of work extraction"),
+				Final: ScopeOfWorkData{
+					MajorWorkComponents: []MajorWorkComponent{},
+					TechnicalStandards:  []TechnicalStandard{},
+				},
+				Repairs: repairs,
+				Plan:    plan,
+				Prompts: promptRefs,
+			}, nil
+		}
+		log.Printf("Single-call failed (%v), falling back to chunked extraction", err)
+		plan.ChunkCount = len(plan.Chunks)
 	}
 
-	log.Printf("Single-call failed (%v), falling back to chunked extraction", err)
-
-	// 2. Fallback: chunked extraction with gemini-2.5-flash
+	// 2. Chunked extraction with gemini-2.5-flash
 	log.Println("Running chunked extraction with gemini-2.5-flash")
-	chunks := s.makeChunksFromPages(pages, 6, 1)
-	log.Printf("Created %d chunks", len(chunks))
+	chunks := plan.Chunks
+	log.Printf("Created %d chunks within %d tokens each", len(chunks), plan.ChunkTokenBudget)
+
+	type chunkOutcome struct {
+		data    ScopeOfWorkData
+		repairs int
+	}
 
+	// Chunks run concurrently under the shared per-model rate limiter and are
+	// collected in page order
 	var chunkResults []ScopeOfWorkData
-	for i, chunk := range chunks {
-		log.Printf("Processing chunk %d/%d (pages %v-%v)", i+1, len(chunks), chunk["start_page"], chunk["end_page"])
-		
-		chunkPrompt := strings.ReplaceAll(CHUNK_EXTRACTION_PROMPT, "<<<DOC>>>", chunk["text"].(string))
-		parsed, _, err := s.callModelForPrompt(ctx, chunkPrompt, "gemini-2.5-flash")
-		
+	promptRefs = append(promptRefs, chunkTmpl.Ref())
+	runOrdered(ctx, len(chunks), chunkWorkers(), func(ctx context.Context, i int) chunkOutcome {
+		chunk := chunks[i]
+		log.Printf("Processing chunk %d/%d (pages %s)", i+1, len(chunks), chunk.PageRange)
+
+		var outcome chunkOutcome
+		chunkPrompt, err := chunkTmpl.Render(map[string]string{"Document": chunk.Text})
+		if err != nil {
+			log.Printf("Chunk %d extraction failed: %v", i+1, err)
+			outcome.data = ScopeOfWorkData{
+				ProjectOverview:     ProjectOverview{},
+				MajorWorkComponents: []MajorWorkComponent{},
+				TechnicalStandards:  []TechnicalStandard{},
+			}
+			return outcome
+		}
+		parsed, chunkResult, err := s.callModelForPrompt(WithLLMCallIndex(WithUsageMode(ctx, UsageModeChunk), i), chunkPrompt, models.Flash)
+		if chunkResult != nil {
+			outcome.repairs = chunkResult.Repairs
+		}
 		if err != nil {
 			log.Printf("Chunk %d extraction failed: %v", i+1, err)
 			// Add empty placeholder
-			chunkResults = append(chunkResults, ScopeOfWorkData{
+			outcome.data = ScopeOfWorkData{
 				ProjectOverview:     ProjectOverview{},
 				MajorWorkComponents: []MajorWorkComponent{},
 				TechnicalStandards:  []TechnicalStandard{},
-			})
+			}
 		} else {
-			chunkResults = append(chunkResults, *parsed)
+			outcome.data = *parsed
 		}
-
-		// Throttle requests
-		time.Sleep(400 * time.Millisecond)
+		return outcome
+	}, func(i int, outcome chunkOutcome) bool {
+		repairs += outcome.repairs
+		chunkResults = append(chunkResults, outcome.data)
+		return true
+	})
+
+	// An interrupted extraction skips the model call and merges what it has
+	status := logInterrupted(ctx, "Scope of work extraction")
+	if status != ResultStatusComplete {
+		return &SOWExtractionResult{
+			Mode:            "chunk_aggregate_programmatic",
+			Status:          status,
+			Final:           s.programmaticMerge(chunkResults),
+			ChunkParsedList: chunkResults,
+			Repairs:         repairs,
+			Plan:            plan,
+			Prompts:         promptRefs,
+		}, nil
 	}
 
 	// 3. Try model-based aggregation
 	log.Println("Attempting model-based aggregation")
 	chunksJSON, _ := json.Marshal(chunkResults)
-	aggPrompt := strings.ReplaceAll(AGGREGATION_PROMPT, "<<<CHUNKS_JSON>>>", string(chunksJSON))
-	
-	aggregated, _, aggErr := s.callModelForPrompt(ctx, aggPrompt, "gemini-2.5-flash")
-	if aggErr == nil && aggregated != nil {
+	aggPrompt, aggErr := aggregateTmpl.Render(map[string]string{"Chunks": string(chunksJSON)})
+	promptRefs = append(promptRefs, aggregateTmpl.Ref())
+
+	var aggregated *ScopeOfWorkData
+	var aggResult *StructuredResult
+	if aggErr == nil {
+		aggregated, aggResult, aggErr = s.callModelForPrompt(WithUsageMode(ctx, UsageModeAggregate), aggPrompt, models.Flash)
+	}
+	if aggResult != nil {
+		repairs += aggResult.Repairs
+	}
+	if aggErr == nil {
 		log.Println("Model-based aggregation successful")
 		return &SOWExtractionResult{
 			Mode:            "chunk_aggregate_model",
+			Status:          ResultStatusComplete,
 			Final:           *aggregated,
 			ChunkParsedList: chunkResults,
+			Repairs:         repairs,
+			Plan:            plan,
+			Prompts:         promptRefs,
 		}, nil
 	}
 
@@ -387,68 +316,47 @@ func (s *SOWExtractor) ExtractSOW(ctx context.Context, pages []string) (*SOWExtr
 	final := s.programmaticMerge(chunkResults)
 	return &SOWExtractionResult{
 		Mode:            "chunk_aggregate_programmatic",
+		Status:          resultStatus(ctx),
 		Final:           final,
 		ChunkParsedList: chunkResults,
+		Repairs:         repairs,
+		Plan:            plan,
+		Prompts:         promptRefs,
 	}, nil
 }
 
-// HTTP handler for scope of work extraction
-func handleScopeOfWorkExtraction(c echo.Context, sowExtractor *SOWExtractor, pdfParser *PDFParser) error {
-	// Parse multipart form
-	form, err := c.MultipartForm()
+// HTTP handler for scope of work extraction, on an uploaded file or a
+// stored document
+func handleScopeOfWorkExtraction(c echo.Context, sowExtractor *SOWExtractor, documents *DocumentLoader) error {
+	language, err := languagePolicyRequested(c)
 	if err != nil {
-		log.Printf("Error parsing multipart form: %v", err)
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Invalid multipart form data",
-		})
-	}
-
-	// Get the uploaded file
-	files := form.File["file"]
-	if len(files) == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "No file uploaded",
-		})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	file := files[0]
-	log.Printf("Processing scope of work extraction for file: %s", file.Filename)
+	// The deadline covers parsing as well as the model calls
+	ctx, cacheStats := llmCacheContext(c)
+	ctx, cancel := withRequestDeadline(ctx, ExtractorSOW)
+	defer cancel()
 
-	// Open the uploaded file
-	src, err := file.Open()
+	doc, err := documents.FromRequest(ctx, c, "file")
 	if err != nil {
-		log.Printf("Error opening uploaded file: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to open uploaded file",
-		})
-	}
-	defer src.Close()
-
-	// Extract text from PDF
-	pages, err := pdfParser.ExtractTextByPage(src)
-	if err != nil {
-		log.Printf("Error extracting text from PDF: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to extract text from PDF",
-		})
-	}
-
-	if len(pages) == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "No text content found in PDF",
-		})
+		log.Printf("Error loading document for scope of work: %v", err)
+		return c.JSON(documentErrorStatus(err), withRequestStatus(ctx, documentErrorBody(err)))
 	}
+	log.Printf("Processing scope of work extraction for file: %s", doc.Filename)
 
 	// Extract scope of work
-	ctx := context.Background()
-	result, err := sowExtractor.ExtractSOW(ctx, pages)
+	ctx, usage := WithUsageCollector(ctx, c.Path())
+	ctx = WithLanguagePolicy(ctx, language)
+	result, err := sowExtractor.ExtractSOW(ctx, doc)
+	documents.RecordUsage(c, usage.Summary())
 	if err != nil {
 		log.Printf("Error extracting scope of work: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to extract scope of work: %v", err),
-		})
+		return c.JSON(llmErrorStatus(err), withRequestStatus(ctx, llmErrorBody(fmt.Sprintf("Failed to extract scope of work: %v", err), err)))
 	}
 
+	result.Cache = cacheStats
+	result.Usage = usage.Summary()
 	log.Printf("Scope of work extraction completed successfully using mode: %s", result.Mode)
 	return c.JSON(http.StatusOK, result)
 }
@@ -6,10 +6,8 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strconv"
 	"strings"
-	"time"
-
-	"github.com/google/generative-ai-go/genai"
 )
 
 type SectionAnalysis struct {
@@ -26,270 +24,259 @@ type KeyConsideration struct {
 
 type SectionwiseResult struct {
 	Mode                  string            `json:"mode"`
+	Status                string            `json:"status"`
 	Final                 []SectionAnalysis `json:"final"`
 	RawSingle             string            `json:"raw_single,omitempty"`
+	Repairs               int               `json:"repairs,omitempty"`
+	Plan                  *ExtractionPlan   `json:"plan,omitempty"`
+	Cache                 *LLMCacheStats    `json:"cache,omitempty"`
+	Usage                 *UsageSummary     `json:"usage,omitempty"`
+	Prompts               []PromptRef       `json:"prompts,omitempty"`
 	ProcessedChunks       int               `json:"processed_chunks,omitempty"`
 	SectionsCount         int               `json:"sections_count,omitempty"`
 	CompletedSectionCount int               `json:"completed_section_count,omitempty"`
 }
 
-type OptimizedChunk struct {
-	StartPage int
-	EndPage   int
-	Text      string
-	PageRange string
+// ExtractSectionwiseAnalysis analyses a parsed document section by section,
+// with chunks following its outline when it has one, on the text of the
+// language policy of ctx
+func (g *GeminiService) ExtractSectionwiseAnalysis(ctx context.Context, doc *ParsedDocument) (*SectionwiseResult, error) {
+	return g.ExtractSectionwiseFromText(ctx, doc.TextFor(languagePolicyFrom(ctx)), doc.Outline)
 }
 
-const SINGLE_DOC_PROMPT = `You are an expert document parser. From the DOCUMENT extract every logical section and return a JSON array of section objects.
-
-Each section object must have exactly these keys:
-- "section_name": short title of the section (string)
-- "section_summary": concise 1-3 sentence summary of the section content (do NOT invent; summarize only what's present)
-- "key_considerations": array of objects, each with:
-    { "consideration": "...", "is_critical": true|false, "page_numbers": [1, 2, 3] }
-
-Rules:
-- RESPOND WITH A SINGLE VALID JSON ARRAY (list) and nothing else.
-- Mark "is_critical": true if the language explicitly flags it as critical/mandatory/penalty/limit OR if it contains strong actionable items (deadlines, penalties, percentages, "CRITICAL", "must", "shall", "mandatory").
-- Include page numbers wherever you can (use page markers in the DOCUMENT).
-- If you cannot find sections, return an empty list [].
-
-DOCUMENT:
-%s`
-
-const CHUNK_PROMPT = `You are an expert document parser. From the DOCUMENT CHUNK extract all sections found in this chunk and return a single VALID JSON ARRAY of section objects with keys:
-- section_name
-- section_summary  
-- key_considerations: [ { "consideration": "...", "is_critical": true|false, "page_numbers": [1, 2, 3] }, ... ]
-
-Rules:
-- Return JSON ONLY (no explanation).
-- Use page markers in the chunk for provenance.
-
-DOCUMENT CHUNK:
-%s`
-
-const AGGREGATE_PROMPT = `You are given multiple JSON arrays (chunk-level extractions) representing sections found across a document. Combine them into one consolidated JSON array of sections.
-
-Rules:
-- For sections with the same or very similar names, merge them into one section; keep the longest/best summary, and combine key_considerations, deduplicating exact duplicate items (case-insensitive).
-- Keep page provenance for every key_consideration.
-- Do NOT invent facts.
-- Return a single VALID JSON ARRAY of section objects with keys: section_name, section_summary, key_considerations.
-
-Chunk-level JSON arrays (one per chunk):
-%s`
-
-func (g *GeminiService) ExtractSectionwiseAnalysis(documentText string) (*SectionwiseResult, error) {
-	if g.client == nil || g.proModel == nil || g.flashModel == nil {
+// ExtractSectionwiseFromText analyses document text with page markers section
+// by section. With an outline, chunks follow its sections; otherwise they
+// are page windows.
+func (g *GeminiService) ExtractSectionwiseFromText(ctx context.Context, documentText string, outline *DocumentOutline) (*SectionwiseResult, error) {
+	if g.llm == nil {
 		return nil, fmt.Errorf("gemini client not initialized")
 	}
+	ctx = WithLLMExtractor(ctx, ExtractorSections)
 
-	// Create context with overall timeout for the entire operation
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
-
-	// 1. Attempt full-document single-call with Gemini 2.5 Pro
-	log.Printf("=== Attempting single-call full-document with Gemini 2.5 Pro ===")
-	log.Printf("(If this fails or returns unparsable JSON, we'll try fallback single-call then chunked extraction.)")
-
-	prompt := fmt.Sprintf(SINGLE_DOC_PROMPT, documentText)
-
-	// Create timeout context for primary call
-	primaryCtx, primaryCancel := context.WithTimeout(ctx, 30*time.Second)
-	resp, err := g.proModel.GenerateContent(primaryCtx, genai.Text(prompt))
-	primaryCancel()
-
-	if err == nil && len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		var result string
-		for _, part := range resp.Candidates[0].Content.Parts {
-			if textPart, ok := part.(genai.Text); ok {
-				result += string(textPart)
-			}
+	// Split into pages and count tokens up front to decide between a single call and chunks
+	pages := g.extractTextByPage(documentText)
+	log.Printf("PDF pages: %d", len(pages))
+	models := g.routes[ExtractorSections]
+
+	// Templates are fetched once so a reload cannot mix versions within one extraction
+	prompts := promptRegistry()
+	singleTmpl := prompts.Get(PromptSectionsSingle)
+	chunkTmpl := prompts.Get(PromptSectionsChunk)
+	aggregateTmpl := prompts.Get(PromptSectionsAggregate)
+	plan := g.planner.PlanWithOutline(ctx, models.Pro, singleTmpl.Text, chunkTmpl.Text, pages, outline.Flatten())
+
+	repairs := 0
+	var promptRefs []PromptRef
+	if plan.Mode == PlanModeSingleCall {
+		// 1. Attempt full-document single-call with Gemini 2.5 Pro
+		log.Printf("=== Attempting single-call full-document with Gemini 2.5 Pro ===")
+		log.Printf("(If this fails or returns unparsable JSON, we'll try fallback single-call then chunked extraction.)")
+
+		prompt, err := singleTmpl.Render(map[string]string{"Document": documentText})
+		if err != nil {
+			return nil, err
 		}
+		promptRefs = append(promptRefs, singleTmpl.Ref())
 
-		result = cleanJSONResponse(result)
-
+		// The call runs under the request deadline; a plan may put a whole
+		// document into it, so no shorter fixed timeout is applied
+		singleCtx := WithUsageMode(ctx, UsageModeSingleCall)
 		var sections []SectionAnalysis
-		if json.Unmarshal([]byte(result), &sections) == nil && len(sections) > 0 {
-			log.Printf("Primary single-call parsed as list. Returning result.")
+		resp, err := g.generateStructured(singleCtx, models.Pro, prompt, &sections)
+		if resp != nil {
+			repairs += resp.Repairs
+		}
+
+		if err == nil && len(sections) > 0 {
+			log.Printf("Primary single-call validated as list. Returning result.")
 			return &SectionwiseResult{
 				Mode:      "single_primary",
+				Status:    ResultStatusComplete,
 				Final:     sections,
-				RawSingle: result,
+				RawSingle: resp.Raw,
+				Repairs:   repairs,
+				Plan:      plan,
+				Prompts:   promptRefs,
 			}, nil
 		}
-		log.Printf("Primary single-call failed or returned unparsable output. Preview (truncated): %s", truncateStringForSections(result, 2000))
-	} else {
-		log.Printf("Primary single-call failed: %v", err)
-	}
+		log.Printf("Primary single-call failed or returned no sections (%s): %v", ClassifyLLMError(err), err)
 
-	// 2. Try single-call with Gemini 2.5 Flash fallback
-	log.Printf("=== Attempting single-call full-document with fallback model Gemini 2.5 Flash ===")
+		// 2. Try single-call with Gemini 2.5 Flash fallback, unless Flash would fail the same way
+		if ctx.Err() == nil && (err == nil || shouldFallbackModel(err)) {
+			log.Printf("=== Attempting single-call full-document with fallback model Gemini 2.5 Flash ===")
 
-	// Create timeout context for secondary call
-	secondaryCtx, secondaryCancel := context.WithTimeout(ctx, 20*time.Second)
-	resp, err = g.flashModel.GenerateContent(secondaryCtx, genai.Text(prompt))
-	secondaryCancel()
+			sections = nil
+			resp, err = g.generateStructured(singleCtx, models.Flash, prompt, &sections)
+			if resp != nil {
+				repairs += resp.Repairs
+			}
 
-	if err == nil && len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		var result string
-		for _, part := range resp.Candidates[0].Content.Parts {
-			if textPart, ok := part.(genai.Text); ok {
-				result += string(textPart)
+			if err == nil && len(sections) > 0 {
+				log.Printf("Secondary single-call validated as list. Returning result.")
+				return &SectionwiseResult{
+					Mode:      "single_secondary",
+					Status:    ResultStatusComplete,
+					Final:     sections,
+					RawSingle: resp.Raw,
+					Repairs:   repairs,
+					Plan:      plan,
+					Prompts:   promptRefs,
+				}, nil
 			}
+			log.Printf("Secondary single-call failed or returned no sections (%s): %v", ClassifyLLMError(err), err)
 		}
 
-		result = cleanJSONResponse(result)
-
-		var sections []SectionAnalysis
-		if json.Unmarshal([]byte(result), &sections) == nil && len(sections) > 0 {
-			log.Printf("Secondary single-call parsed as list. Returning result.")
+		if ctx.Err() != nil {
+			// No budget left for chunks
 			return &SectionwiseResult{
-				Mode:      "single_secondary",
-				Final:     sections,
-				RawSingle: result,
+				Mode:    "single_failed",
+				Status:  logInterrupted(ctx, "Section-wise analysis"),
+				Final:   []SectionAnalysis{},
+				Repairs: repairs,
+				Plan:    plan,
+				Prompts: promptRefs,
 			}, nil
 		}
-		log.Printf("Secondary single-call failed or returned unparsable output. Preview (truncated): %s", truncateStringForSections(result, 2000))
-	} else {
-		log.Printf("Secondary single-call failed: %v", err)
+		plan.ChunkCount = len(plan.Chunks)
 	}
 
-	// 3. Fallback: optimized chunked extraction using Gemini 2.5 Flash
-	log.Printf("=== Falling back to optimized chunked extraction using Gemini 2.5 Flash ===")
+	// 3. Chunked extraction using Gemini 2.5 Flash, planned or as fallback
+	log.Printf("=== Running optimized chunked extraction using Gemini 2.5 Flash ===")
 
-	// Extract text by pages and create optimized chunks
-	pages := g.extractTextByPage(documentText)
-	log.Printf("PDF pages: %d", len(pages))
-
-	chunks := g.makeChunksFromPages(pages, 3, 1) // 3 pages per chunk, 1 page overlap (optimized for speed)
-	log.Printf("Built %d chunk(s) (pages_per_chunk=3, overlap=1)", len(chunks))
+	chunks := plan.Chunks
+	log.Printf("Built %d chunk(s) within %d tokens each", len(chunks), plan.ChunkTokenBudget)
 
-	// Prefilter chunks to only those likely containing sections
-	candidateChunks := g.filterCandidateChunks(chunks)
+	// Prefilter page windows to only those likely containing sections;
+	// outline chunks all start at a heading
+	candidateChunks := chunks
+	if plan.Chunking != ChunkingOutline {
+		candidateChunks = g.filterCandidateChunks(chunks)
+	}
 	log.Printf("Candidate chunks to call model on (after prefilter): %d", len(candidateChunks))
 
-	chunkResults := [][]SectionAnalysis{}
-	processedCount := 0
-	consecutiveNoNew := 0
-	maxConsecutiveNoNew := 4 // Reduced from 6 for faster early stopping
-	maxRetries := 1                          // Reduce retries for faster processing
-	processedChunks := make(map[string]bool) // Track processed chunks to avoid duplicates
-
-	for i, chunk := range candidateChunks {
-		// Skip if already processed
-		chunkKey := fmt.Sprintf("%s", chunk.PageRange)
-		if processedChunks[chunkKey] {
+	// Skip duplicate chunks
+	processedChunks := make(map[string]bool)
+	uniqueChunks := make([]Chunk, 0, len(candidateChunks))
+	for _, chunk := range candidateChunks {
+		if processedChunks[chunk.Text] {
 			log.Printf("Skipping already processed chunk %s", chunk.PageRange)
 			continue
 		}
+		processedChunks[chunk.Text] = true
+		uniqueChunks = append(uniqueChunks, chunk)
+	}
 
-		processedCount++
-		log.Printf("--- chunk %d/%d pages %s ---", processedCount, len(candidateChunks), chunk.PageRange)
-
-		// Mark as processed immediately to prevent retries
-		processedChunks[chunkKey] = true
+	type chunkOutcome struct {
+		sections []SectionAnalysis
+		repairs  int
+	}
 
-		// Retry logic with exponential backoff
-		var chunkSections []SectionAnalysis
-		success := false
-		for retry := 0; retry <= maxRetries; retry++ {
-			if retry > 0 {
-				backoffTime := 1 * time.Second // Fixed 1s backoff for speed
-				log.Printf("Retrying chunk %s (attempt %d/%d) after %v", chunk.PageRange, retry+1, maxRetries+1, backoffTime)
-				time.Sleep(backoffTime)
-			}
+	chunkResults := [][]SectionAnalysis{}
+	processedCount := 0
+	consecutiveNoNew := 0
+	maxConsecutiveNoNew := 4 // Reduced from 6 for faster early stopping
 
-			chunkPrompt := fmt.Sprintf(CHUNK_PROMPT, chunk.Text)
+	// Chunks run concurrently under the shared per-model rate limiter; results
+	// are consumed in page order so early stopping behaves as in a serial loop
+	workers := chunkWorkers()
+	log.Printf("Processing %d chunk(s) with %d worker(s)", len(uniqueChunks), workers)
 
-			// Create context with aggressive timeout for speed
-			chunkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			chunkResp, err := g.flashModel.GenerateContent(chunkCtx, genai.Text(chunkPrompt))
-			cancel()
+	processChunk := func(ctx context.Context, i int) chunkOutcome {
+		chunk := uniqueChunks[i]
+		log.Printf("--- chunk %d/%d pages %s %q ---", i+1, len(uniqueChunks), chunk.PageRange, chunk.Sections)
 
-			if err != nil {
-				log.Printf("Chunk %s attempt %d failed: %v", chunk.PageRange, retry+1, err)
-				if retry == maxRetries {
-					consecutiveNoNew++
-					break
-				}
-				continue
-			}
+		chunkPrompt, err := chunkTmpl.Render(map[string]string{"Document": chunk.Text})
+		if err != nil {
+			log.Printf("Chunk %s: %v", chunk.PageRange, err)
+			return chunkOutcome{}
+		}
 
-			if len(chunkResp.Candidates) == 0 || len(chunkResp.Candidates[0].Content.Parts) == 0 {
-				log.Printf("Chunk %s attempt %d returned empty response", chunk.PageRange, retry+1)
-				if retry == maxRetries {
-					consecutiveNoNew++
-					break
-				}
-				continue
-			}
+		// Chunk calls run under the request deadline; a hung attempt is cut
+		// short and retried by the LLM client's policy
+		chunkCtx := WithLLMCallIndex(WithUsageMode(ctx, UsageModeChunk), i)
 
-			var chunkResult string
-			for _, part := range chunkResp.Candidates[0].Content.Parts {
-				if textPart, ok := part.(genai.Text); ok {
-					chunkResult += string(textPart)
-				}
-			}
+		var outcome chunkOutcome
+		var chunkSections []SectionAnalysis
+		chunkResp, err := g.generateStructured(chunkCtx, models.Flash, chunkPrompt, &chunkSections)
+		if chunkResp != nil {
+			outcome.repairs = chunkResp.Repairs
+		}
+		if err != nil {
+			log.Printf("Chunk %s failed (%s): %v", chunk.PageRange, ClassifyLLMError(err), err)
+			return outcome
+		}
 
-			log.Printf("RAW preview: %s", truncateStringForSections(strings.ReplaceAll(chunkResult, "\n", " "), 800))
-			chunkResult = cleanJSONResponse(chunkResult)
+		log.Printf("RAW preview: %s", truncateStringForSections(strings.ReplaceAll(chunkResp.Raw, "\n", " "), 800))
 
-			if json.Unmarshal([]byte(chunkResult), &chunkSections) == nil && len(chunkSections) > 0 {
-				chunkResults = append(chunkResults, chunkSections)
-				consecutiveNoNew = 0
-				success = true
-				log.Printf("Chunk %s processed successfully with %d sections", chunk.PageRange, len(chunkSections))
-				break
-			} else {
-				log.Printf("Chunk %s attempt %d failed to parse JSON", chunk.PageRange, retry+1)
-				if retry == maxRetries {
-					consecutiveNoNew++
-				}
-			}
+		if len(chunkSections) == 0 {
+			log.Printf("Chunk %s returned no sections", chunk.PageRange)
+			return outcome
 		}
+		outcome.sections = chunkSections
+		log.Printf("Chunk %s processed successfully with %d sections", chunk.PageRange, len(chunkSections))
+		return outcome
+	}
+
+	promptRefs = append(promptRefs, chunkTmpl.Ref())
+	runOrdered(ctx, len(uniqueChunks), workers, processChunk, func(i int, outcome chunkOutcome) bool {
+		processedCount++
+		repairs += outcome.repairs
 
-		if !success {
-			log.Printf("Chunk %s failed after all retry attempts", chunk.PageRange)
+		if len(outcome.sections) > 0 {
+			chunkResults = append(chunkResults, outcome.sections)
+			consecutiveNoNew = 0
+		} else {
+			consecutiveNoNew++
 		}
 
 		// Early stopping condition
 		if consecutiveNoNew >= maxConsecutiveNoNew {
 			log.Printf("Early stopping: %d consecutive chunks added no new info.", consecutiveNoNew)
-			break
+			return false
 		}
-
-		// Ultra-minimal throttle for maximum speed
-		if i < len(candidateChunks)-1 {
-			time.Sleep(50 * time.Millisecond)
-		}
-	}
+		return true
+	})
+	status := logInterrupted(ctx, "Section-wise analysis")
 
 	// Aggregate chunk results
 	log.Printf("Processed %d chunks successfully, got %d chunk results", processedCount, len(chunkResults))
 	if len(chunkResults) == 0 {
 		return &SectionwiseResult{
 			Mode:            "chunk_failed",
+			Status:          status,
 			Final:           []SectionAnalysis{},
 			ProcessedChunks: processedCount,
+			Repairs:         repairs,
+			Plan:            plan,
+			Prompts:         promptRefs,
 		}, nil
 	}
 
-	// Try model-based aggregation first
-	aggregated := g.aggregateChunksWithModel(ctx, chunkResults)
-	if aggregated != nil {
-		return &SectionwiseResult{
-			Mode:  "chunk_optimized",
-			Final: *aggregated,
-		}, nil
+	// Try model-based aggregation first, unless the request has run out of time
+	if status == ResultStatusComplete {
+		promptRefs = append(promptRefs, aggregateTmpl.Ref())
+		aggregated := g.aggregateChunksWithModel(WithUsageMode(ctx, UsageModeAggregate), aggregateTmpl, chunkResults)
+		if aggregated != nil {
+			return &SectionwiseResult{
+				Mode:    "chunk_optimized",
+				Status:  ResultStatusComplete,
+				Final:   *aggregated,
+				Repairs: repairs,
+				Plan:    plan,
+				Prompts: promptRefs,
+			}, nil
+		}
 	}
 
 	// Fallback to programmatic aggregation
 	final := g.programmaticAggregate(chunkResults)
 	return &SectionwiseResult{
-		Mode:  "chunk_optimized",
-		Final: final,
+		Mode:    "chunk_optimized",
+		Status:  resultStatus(ctx),
+		Final:   final,
+		Repairs: repairs,
+		Plan:    plan,
+		Prompts: promptRefs,
 	}, nil
 }
 
@@ -309,7 +296,7 @@ func (g *GeminiService) createDocumentChunks(text string, chunkSize int) []strin
 	return chunks
 }
 
-func (g *GeminiService) aggregateChunksWithModel(ctx context.Context, chunkResults [][]SectionAnalysis) *[]SectionAnalysis {
+func (g *GeminiService) aggregateChunksWithModel(ctx context.Context, tmpl *PromptTemplate, chunkResults [][]SectionAnalysis) *[]SectionAnalysis {
 	// Convert chunk results to JSON strings
 	var jsonArrays []string
 	for _, chunk := range chunkResults {
@@ -323,37 +310,24 @@ func (g *GeminiService) aggregateChunksWithModel(ctx context.Context, chunkResul
 	}
 
 	chunksJSON := strings.Join(jsonArrays, "\n")
-	prompt := fmt.Sprintf(AGGREGATE_PROMPT, chunksJSON)
-
-	resp, err := g.proModel.GenerateContent(ctx, genai.Text(prompt))
+	prompt, err := tmpl.Render(map[string]string{"Chunks": chunksJSON})
 	if err != nil {
 		log.Printf("Model aggregation failed: %v", err)
 		return nil
 	}
 
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return nil
-	}
-
-	var result string
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if textPart, ok := part.(genai.Text); ok {
-			result += string(textPart)
-		}
-	}
-
-	result = cleanJSONResponse(result)
-
 	var aggregated []SectionAnalysis
-	if json.Unmarshal([]byte(result), &aggregated) == nil {
-		return &aggregated
+	if _, err := g.generateStructured(ctx, g.routes[ExtractorSections].Pro, prompt, &aggregated); err != nil {
+		log.Printf("Model aggregation failed: %v", err)
+		return nil
 	}
 
-	return nil
+	return &aggregated
 }
 
 func (g *GeminiService) programmaticAggregate(chunkResults [][]SectionAnalysis) []SectionAnalysis {
 	merged := make(map[string]*SectionAnalysis)
+	var order []string
 
 	normalize := func(s string) string {
 		re := regexp.MustCompile(`\s+`)
@@ -390,6 +364,7 @@ func (g *GeminiService) programmaticAggregate(chunkResults [][]SectionAnalysis)
 				}
 			} else {
 				// Create new section
+				order = append(order, key)
 				merged[key] = &SectionAnalysis{
 					SectionName:       section.SectionName,
 					SectionSummary:    section.SectionSummary,
@@ -399,10 +374,10 @@ func (g *GeminiService) programmaticAggregate(chunkResults [][]SectionAnalysis)
 		}
 	}
 
-	// Convert map to slice
+	// Convert map to slice, keeping sections in document order
 	result := make([]SectionAnalysis, 0, len(merged))
-	for _, section := range merged {
-		result = append(result, *section)
+	for _, key := range order {
+		result = append(result, *merged[key])
 	}
 
 	return result
@@ -423,24 +398,44 @@ func truncateStringForSections(s string, maxLen int) string {
 }
 
 // Section header keywords for filtering
-var sectionHeaderKeywords = []string{
-	`\\brfp\\b`, `\\bsection\\b`, `\\bscope\\b`, `\\bscope of work\\b`, `\\bproject overview\\b`,
-	`\\bmajor work\\b`, `\\btechnical standard\\b`, `\\bsection-wise\\b`, `\\beligibility\\b`,
-	`\\bsection wise\\b`, `\\brfp section\\b`,
-}
+var sectionHeaderRegex = regexp.MustCompile(`\b(rfp|section|scope|scope of work|project overview|major work|technical standard|section-wise|eligibility|section wise|rfp section)\b`)
+
+// pageMarkerRegex matches the [PAGE:X] markers used in prompts and the
+// "--- Page X ---" markers written by PDFParser.ExtractText
+var pageMarkerRegex = regexp.MustCompile(`\[PAGE:\d+\]|--- Page \d+ ---`)
+
+var pageNumberRegex = regexp.MustCompile(`\d+`)
 
 func (g *GeminiService) extractTextByPage(documentText string) []string {
-	// Split document by page markers [PAGE:X]
-	pageRegex := regexp.MustCompile(`\\[PAGE:(\\d+)\\]`)
-	parts := pageRegex.Split(documentText, -1)
-	pages := make([]string, 0)
-
-	for i, part := range parts {
-		if i == 0 && !pageRegex.MatchString(documentText[:len(part)]) {
-			// First part before any page marker
-			continue
+	// Split document by page markers
+	locs := pageMarkerRegex.FindAllStringIndex(documentText, -1)
+	pages := make([]string, 0, len(locs))
+
+	// Pages are placed at their marker's number, so empty pages left out of
+	// the text keep outline page anchors in line. Markers that are not
+	// increasing are taken in sequence.
+	numbers := make([]int, len(locs))
+	numbered := true
+	for i, loc := range locs {
+		numbers[i], _ = strconv.Atoi(pageNumberRegex.FindString(documentText[loc[0]:loc[1]]))
+		if numbers[i] < 1 || (i > 0 && numbers[i] <= numbers[i-1]) {
+			numbered = false
 		}
-		pages = append(pages, strings.TrimSpace(part))
+	}
+	if numbered && len(locs) > 0 && numbers[len(locs)-1] > 10*len(locs) {
+		// Markers this sparse are not page numbers of this text
+		numbered = false
+	}
+
+	for i, loc := range locs {
+		end := len(documentText)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		for numbered && len(pages) < numbers[i]-1 {
+			pages = append(pages, "")
+		}
+		pages = append(pages, strings.TrimSpace(documentText[loc[1]:end]))
 	}
 
 	// Fallback: if no page markers found, split by estimated page size
@@ -458,43 +453,8 @@ func (g *GeminiService) extractTextByPage(documentText string) []string {
 	return pages
 }
 
-func (g *GeminiService) makeChunksFromPages(pageTexts []string, pagesPerChunk, overlapPages int) []OptimizedChunk {
-	chunks := make([]OptimizedChunk, 0)
-	n := len(pageTexts)
-	if n == 0 {
-		return chunks
-	}
-
-	i := 0
-	for i < n {
-		start := i
-		end := minInt(n, i+pagesPerChunk)
-
-		// Build chunk text with page markers
-		var textParts []string
-		for idx := start; idx < end; idx++ {
-			textParts = append(textParts, fmt.Sprintf("[PAGE:%d]\\n%s", idx+1, pageTexts[idx]))
-		}
-		text := strings.Join(textParts, "\\n\\n")
-
-		chunks = append(chunks, OptimizedChunk{
-			StartPage: start + 1,
-			EndPage:   end,
-			Text:      text,
-			PageRange: fmt.Sprintf("%d-%d", start+1, end),
-		})
-
-		if end == n {
-			break
-		}
-		i = end - overlapPages
-	}
-
-	return chunks
-}
-
-func (g *GeminiService) filterCandidateChunks(chunks []OptimizedChunk) []OptimizedChunk {
-	candidates := make([]OptimizedChunk, 0)
+func (g *GeminiService) filterCandidateChunks(chunks []Chunk) []Chunk {
+	candidates := make([]Chunk, 0)
 
 	for _, chunk := range chunks {
 		if g.chunkLikelyHasSectionHeader(chunk.Text) {
@@ -514,17 +474,15 @@ func (g *GeminiService) chunkLikelyHasSectionHeader(chunkText string) bool {
 	s := strings.ToLower(chunkText)
 
 	// Check for section header keywords
-	for _, pattern := range sectionHeaderKeywords {
-		if matched, _ := regexp.MatchString(pattern, s); matched {
-			return true
-		}
+	if sectionHeaderRegex.MatchString(s) {
+		return true
 	}
 
-	// Heuristic: detect all-caps headings
-	lines := strings.Split(chunkText, "\\n")
+	// Heuristic: detect all-caps headings; lines without letters don't count
+	lines := strings.Split(chunkText, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
-		if len(line) >= 4 && len(line) <= 120 && line == strings.ToUpper(line) && len(strings.Fields(line)) < 12 {
+		if len(line) >= 4 && len(line) <= 120 && line == strings.ToUpper(line) && line != strings.ToLower(line) && len(strings.Fields(line)) < 12 {
 			return true
 		}
 	}
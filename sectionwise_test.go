@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deadlineLLMClient answers every call with no sections and keeps the
+// deadline of each call by usage mode
+type deadlineLLMClient struct {
+	mu        sync.Mutex
+	deadlines map[string][]time.Time
+}
+
+func (d *deadlineLLMClient) Provider() string {
+	return "deadline"
+}
+
+func (d *deadlineLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	deadline, _ := ctx.Deadline()
+	d.mu.Lock()
+	d.deadlines[usageModeFrom(ctx)] = append(d.deadlines[usageModeFrom(ctx)], deadline)
+	d.mu.Unlock()
+	return &LLMResponse{Text: "[]", Model: req.Model}, nil
+}
+
+func (d *deadlineLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return estimateTokens(text), nil
+}
+
+func TestSectionwiseCallsUseRequestDeadline(t *testing.T) {
+	t.Setenv("TENDERIQ_LLM_RECORD", "")
+	t.Setenv("TENDERIQ_LLM_MAX_ATTEMPTS", "1")
+	for _, extractor := range []string{"DEFAULT", ExtractorSections} {
+		t.Setenv("TENDERIQ_ROUTE_"+extractor, "")
+	}
+	text := readGoldenInput(t, filepath.Join("testdata", "golden", "sectionwise"))
+
+	tests := []struct {
+		name        string
+		singleCall  string
+		chunkTokens string
+		modes       []string
+	}{
+		{"single call then chunks", "", "250", []string{UsageModeSingleCall, UsageModeChunk}},
+		{"planned chunks", "1", "250", []string{UsageModeChunk}},
+	}
+	for _, tt := range tests {
+		t.Setenv("TENDERIQ_SINGLE_CALL_MAX_TOKENS", tt.singleCall)
+		t.Setenv("TENDERIQ_CHUNK_MAX_TOKENS", tt.chunkTokens)
+		fake := &deadlineLLMClient{deadlines: make(map[string][]time.Time)}
+		service := NewGeminiServiceWithClient(fake)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		want, _ := ctx.Deadline()
+		if _, err := service.ExtractSectionwiseFromText(ctx, text, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		cancel()
+
+		for _, mode := range tt.modes {
+			if len(fake.deadlines[mode]) == 0 {
+				t.Errorf("%s: no %s calls made", tt.name, mode)
+			}
+		}
+		for mode, deadlines := range fake.deadlines {
+			for _, deadline := range deadlines {
+				if !deadline.Equal(want) {
+					t.Errorf("%s: %s call deadline %v, want the request deadline %v", tt.name, mode, deadline, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Default number of repair round-trips for a response that fails schema validation
+const defaultMaxSchemaRepairs = 2
+
+// SchemaValidationError is returned when a response still fails validation
+// after all repair attempts
+type SchemaValidationError struct {
+	Errors []string
+	Raw    string
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("response does not match schema: %s", strings.Join(e.Errors, "; "))
+}
+
+// StructuredResult describes how a structured response was obtained
+type StructuredResult struct {
+	Raw           string
+	Repairs       int
+	Continuations int
+}
+
+// maxSchemaRepairs reads TENDERIQ_MAX_SCHEMA_REPAIRS, falling back to the default
+func maxSchemaRepairs() int {
+	return getEnvInt("TENDERIQ_MAX_SCHEMA_REPAIRS", defaultMaxSchemaRepairs)
+}
+
+// schemaInstruction renders a schema as a prompt constraint for backends
+// that cannot take the schema as a request parameter
+func schemaInstruction(schema *Schema) string {
+	return "Respond with a single JSON value that conforms to this JSON Schema. Do not wrap it in markdown or add any other text.\n" + schema.String()
+}
+
+// responseEnvelopeKey holds the value of a response whose schema is not an
+// object, for backends whose JSON mode only produces objects
+const responseEnvelopeKey = "items"
+
+// envelopeSchema wraps schema in an object with the single property
+// responseEnvelopeKey
+func envelopeSchema(schema *Schema) *Schema {
+	return &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{responseEnvelopeKey: schema},
+		Required:   []string{responseEnvelopeKey},
+	}
+}
+
+// unwrapEnvelope returns the value of a response wrapped as envelopeSchema
+// describes, or raw as it is
+func unwrapEnvelope(raw string) string {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &envelope); err != nil || len(envelope) != 1 {
+		return raw
+	}
+	if value, ok := envelope[responseEnvelopeKey]; ok {
+		return string(value)
+	}
+	return raw
+}
+
+// generateStructured sends req with the schema derived from out, validates the
+// response and decodes it into out. Responses cut off at the output token
+// limit are continued first; invalid responses are then sent back to the
+// model together with the validation errors, up to maxSchemaRepairs times.
+func generateStructured(ctx context.Context, llm LLMClient, req LLMRequest, out interface{}) (*StructuredResult, error) {
+	if llm == nil {
+		return nil, fmt.Errorf("LLM client not initialized")
+	}
+
+	schema := SchemaFor(out)
+	req.ResponseSchema = schema
+	clean := func(text string) string {
+		raw := cleanJSONResponse(text)
+		if schema.Type != "object" {
+			raw = unwrapEnvelope(raw)
+		}
+		return raw
+	}
+
+	resp, continuations, err := generateWithContinuation(ctx, llm, req)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := clean(resp.Text)
+	validationErrs := schema.Validate([]byte(raw))
+
+	maxRepairs := maxSchemaRepairs()
+	repairs := 0
+	for len(validationErrs) > 0 && repairs < maxRepairs {
+		repairs++
+		log.Printf("Response from %s failed schema validation (%d error(s)), repair attempt %d/%d", req.Model, len(validationErrs), repairs, maxRepairs)
+
+		repairReq := req
+		repairReq.Prompt, err = promptRegistry().Get(PromptSchemaRepair).Render(map[string]string{
+			"Errors":   "- " + strings.Join(validationErrs, "\n- "),
+			"Response": raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var repairContinuations int
+		resp, repairContinuations, err = generateWithContinuation(WithUsageMode(ctx, UsageModeRepair), llm, repairReq)
+		continuations += repairContinuations
+		if err != nil {
+			return nil, fmt.Errorf("schema repair failed: %w", err)
+		}
+
+		raw = clean(resp.Text)
+		validationErrs = schema.Validate([]byte(raw))
+	}
+
+	result := &StructuredResult{Raw: raw, Repairs: repairs, Continuations: continuations}
+	if len(validationErrs) > 0 {
+		validationErr := &SchemaValidationError{Errors: validationErrs, Raw: raw}
+		if resp.FinishReason == FinishReasonMaxTokens {
+			return result, &LLMError{Class: ErrorClassTruncated, Model: req.Model, Err: fmt.Errorf("response truncated due to max tokens: %w", validationErr)}
+		}
+		return result, validationErr
+	}
+
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return result, fmt.Errorf("failed to decode validated response: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Table detection thresholds, in PDF points unless noted
+const (
+	// Lines whose tops differ by less than this are on the same row
+	tableRowTolerance = 3.0
+	// Cells starting closer together than this are in the same column
+	tableColumnTolerance = 10.0
+	// Rows further apart than this many line heights end a table
+	tableMaxRowGap = 2.5
+	// A table needs this many rows with two or more cells
+	tableMinRows = 2
+	// Two-column blocks with longer cells (in characters) on average are
+	// taken to be a two-column text layout rather than a table
+	tableMaxTwoColumnCellChars = 40
+)
+
+// TableCell is one cell and where it is on the page
+type TableCell struct {
+	Text string `json:"text"`
+	BBox BBox   `json:"bbox"`
+}
+
+// Table is a table reconstructed from positioned text. The first row is
+// usually the header.
+type Table struct {
+	Page int           `json:"page"`
+	BBox BBox          `json:"bbox"`
+	Rows [][]TableCell `json:"rows"`
+}
+
+// Markdown renders the table as a markdown table with the first row as header
+func (t *Table) Markdown() string {
+	var builder strings.Builder
+	for i, row := range t.Rows {
+		builder.WriteString("|")
+		for _, cell := range row {
+			text := strings.ReplaceAll(strings.ReplaceAll(cell.Text, "|", "\\|"), "\n", " ")
+			builder.WriteString(" " + text + " |")
+		}
+		builder.WriteString("\n")
+		if i == 0 {
+			builder.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// CSV renders the table as CSV
+func (t *Table) CSV() string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = cell.Text
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// layoutRow is the lines of a page sharing a row, left to right
+type layoutRow struct {
+	lines []int
+	bbox  BBox
+}
+
+// groupRows puts lines (sorted top to bottom) into rows
+func groupRows(lines []TextLine) []layoutRow {
+	var rows []layoutRow
+	for i, line := range lines {
+		if n := len(rows); n > 0 && line.BBox.Y0-lines[rows[n-1].lines[0]].BBox.Y0 < tableRowTolerance {
+			rows[n-1].lines = append(rows[n-1].lines, i)
+			rows[n-1].bbox = rows[n-1].bbox.Union(line.BBox)
+			continue
+		}
+		rows = append(rows, layoutRow{lines: []int{i}, bbox: line.BBox})
+	}
+	for _, row := range rows {
+		sort.SliceStable(row.lines, func(a, b int) bool {
+			return lines[row.lines[a]].BBox.X0 < lines[row.lines[b]].BBox.X0
+		})
+	}
+	return rows
+}
+
+// detectTables finds runs of rows whose cells line up in columns. Rows with
+// a single line continue a table when the line sits in one of its columns
+// other than the first, as wrapped cell text does. It also returns the set
+// of lines the tables use.
+func detectTables(page int, lines []TextLine) ([]Table, map[int]bool) {
+	rows := groupRows(lines)
+	used := make(map[int]bool)
+	var tables []Table
+
+	var current []layoutRow
+	flush := func() {
+		if table, ok := buildTable(page, lines, current); ok {
+			tables = append(tables, table)
+			for _, row := range current {
+				for _, i := range row.lines {
+					used[i] = true
+				}
+			}
+		}
+		current = nil
+	}
+
+	for _, row := range rows {
+		if n := len(current); n > 0 {
+			prev := current[n-1].bbox
+			if row.bbox.Y0-prev.Y1 > tableMaxRowGap*(prev.Y1-prev.Y0) {
+				flush()
+			}
+		}
+		if len(row.lines) >= 2 {
+			current = append(current, row)
+			continue
+		}
+		if len(current) > 0 && continuesColumn(lines, current, lines[row.lines[0]].BBox.X0) {
+			current = append(current, row)
+			continue
+		}
+		flush()
+	}
+	flush()
+
+	return tables, used
+}
+
+// continuesColumn reports whether a line starting at x lines up with a
+// column of the rows so far, other than the first
+func continuesColumn(lines []TextLine, rows []layoutRow, x float64) bool {
+	anchors := columnAnchors(lines, rows)
+	for i, anchor := range anchors {
+		if i > 0 && abs(x-anchor) <= tableColumnTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// columnAnchors clusters the cell starts of multi-cell rows into columns
+func columnAnchors(lines []TextLine, rows []layoutRow) []float64 {
+	var starts []float64
+	for _, row := range rows {
+		if len(row.lines) < 2 {
+			continue
+		}
+		for _, i := range row.lines {
+			starts = append(starts, lines[i].BBox.X0)
+		}
+	}
+	sort.Float64s(starts)
+
+	var anchors []float64
+	for _, x := range starts {
+		if n := len(anchors); n > 0 && x-anchors[n-1] <= tableColumnTolerance {
+			continue
+		}
+		anchors = append(anchors, x)
+	}
+	return anchors
+}
+
+// buildTable turns a run of rows into a table, or reports false when the
+// run does not look like one
+func buildTable(page int, lines []TextLine, rows []layoutRow) (Table, bool) {
+	multi := 0
+	for _, row := range rows {
+		if len(row.lines) >= 2 {
+			multi++
+		}
+	}
+	anchors := columnAnchors(lines, rows)
+	if multi < tableMinRows || len(anchors) < 2 {
+		return Table{}, false
+	}
+
+	table := Table{Page: page}
+	cellChars, cellCount := 0, 0
+	for _, row := range rows {
+		if len(row.lines) == 1 && len(table.Rows) > 0 {
+			// Wrapped text of a cell in the previous row
+			line := lines[row.lines[0]]
+			prev := table.Rows[len(table.Rows)-1]
+			appendToCell(&prev[columnOf(anchors, line.BBox.X0)], line)
+			table.BBox = table.BBox.Union(line.BBox)
+			continue
+		}
+
+		cells := make([]TableCell, len(anchors))
+		for _, i := range row.lines {
+			appendToCell(&cells[columnOf(anchors, lines[i].BBox.X0)], lines[i])
+		}
+		for _, cell := range cells {
+			if cell.Text != "" {
+				cellChars += len(cell.Text)
+				cellCount++
+			}
+		}
+		table.Rows = append(table.Rows, cells)
+		table.BBox = table.BBox.Union(row.bbox)
+	}
+
+	if len(anchors) == 2 && cellCount > 0 && cellChars/cellCount > tableMaxTwoColumnCellChars {
+		return Table{}, false
+	}
+	return table, true
+}
+
+// columnOf returns the column whose anchor is the last one at or left of x
+func columnOf(anchors []float64, x float64) int {
+	col := 0
+	for i, anchor := range anchors {
+		if anchor <= x+tableColumnTolerance {
+			col = i
+		}
+	}
+	return col
+}
+
+func appendToCell(cell *TableCell, line TextLine) {
+	if cell.Text != "" {
+		cell.Text += " "
+	}
+	cell.Text += line.Text
+	cell.BBox = cell.BBox.Union(line.BBox)
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// pageTextWithTables rebuilds a page's text from its lines with every table
+// written as markdown where it starts, so prompts see rows and cells instead
+// of the words of the table in reading order
+func pageTextWithTables(lines []TextLine, tables []Table, used map[int]bool) string {
+	var builder strings.Builder
+	next := 0
+	for _, row := range groupRows(lines) {
+		if used[row.lines[0]] {
+			if next < len(tables) && row.bbox.Y0 >= tables[next].BBox.Y0-tableRowTolerance {
+				builder.WriteString("\n" + tableLabel(tables[next].Page) + "\n")
+				builder.WriteString(tables[next].Markdown())
+				builder.WriteString("\n")
+				next++
+			}
+			continue
+		}
+
+		texts := make([]string, len(row.lines))
+		for i, idx := range row.lines {
+			texts[i] = lines[idx].Text
+		}
+		builder.WriteString(strings.Join(texts, " "))
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// tableLabel introduces a markdown table in page text
+func tableLabel(page int) string {
+	return fmt.Sprintf("[Table on page %d]", page)
+}
+
+// handleTableExtraction returns the tables of an uploaded file or a stored
+// document
+func handleTableExtraction(c echo.Context, documents *DocumentLoader) error {
+	ctx, cancel := withRequestDeadline(c.Request().Context(), EndpointUpload)
+	defer cancel()
+	doc, err := documents.FromRequest(ctx, c, "file")
+	if err != nil {
+		log.Printf("Table extraction error: %v", err)
+		return c.JSON(documentErrorStatus(err), withRequestStatus(ctx, documentErrorBody(err)))
+	}
+	tables := doc.Tables()
+
+	if c.QueryParam("format") == "csv" {
+		var builder strings.Builder
+		for i, table := range tables {
+			if i > 0 {
+				builder.WriteString("\n")
+			}
+			builder.WriteString(fmt.Sprintf("# Table %d, page %d\n", i+1, table.Page))
+			builder.WriteString(table.CSV())
+		}
+		return c.String(http.StatusOK, builder.String())
+	}
+
+	if tables == nil {
+		tables = []Table{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"filename": doc.Filename,
+		"tables":   tables,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testLine is a 10 point line of text starting at x, y
+func testLine(text string, x, y float64) TextLine {
+	return TextLine{
+		Page:     1,
+		Text:     text,
+		BBox:     BBox{X0: x, Y0: y, X1: x + float64(len(text))*5, Y1: y + 10},
+		FontSize: 10,
+	}
+}
+
+// tableTexts returns the cell texts of each table
+func tableTexts(tables []Table) [][][]string {
+	var out [][][]string
+	for _, table := range tables {
+		var rows [][]string
+		for _, row := range table.Rows {
+			var cells []string
+			for _, cell := range row {
+				cells = append(cells, cell.Text)
+			}
+			rows = append(rows, cells)
+		}
+		out = append(out, rows)
+	}
+	return out
+}
+
+func TestDetectTables(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []TextLine
+		want  [][][]string
+		used  int
+	}{
+		{
+			name: "three columns",
+			lines: []TextLine{
+				testLine("Item", 50, 100), testLine("Qty", 250, 100), testLine("Rate", 350, 100),
+				testLine("Pipes", 50, 115), testLine("120", 250, 115), testLine("450.00", 350, 115),
+				testLine("Valves", 50, 130), testLine("8", 252, 130), testLine("1200.00", 348, 130),
+			},
+			want: [][][]string{{
+				{"Item", "Qty", "Rate"},
+				{"Pipes", "120", "450.00"},
+				{"Valves", "8", "1200.00"},
+			}},
+			used: 9,
+		},
+		{
+			name: "prose",
+			lines: []TextLine{
+				testLine("The contractor shall supply all pipes.", 50, 100),
+				testLine("Work shall start within 15 days.", 50, 115),
+			},
+			used: 0,
+		},
+		{
+			name: "wrapped cell",
+			lines: []TextLine{
+				testLine("No", 50, 100), testLine("Description", 100, 100), testLine("Unit", 350, 100),
+				testLine("1", 50, 115), testLine("Supply of pipes", 100, 115), testLine("m", 350, 115),
+				testLine("including laying", 100, 127),
+				testLine("2", 50, 142), testLine("Valves", 100, 142), testLine("each", 350, 142),
+			},
+			want: [][][]string{{
+				{"No", "Description", "Unit"},
+				{"1", "Supply of pipes including laying", "m"},
+				{"2", "Valves", "each"},
+			}},
+			used: 10,
+		},
+		{
+			name: "line in first column ends table",
+			lines: []TextLine{
+				testLine("Item", 50, 100), testLine("Qty", 250, 100),
+				testLine("Pipes", 50, 115), testLine("120", 250, 115),
+				testLine("Notes follow below.", 50, 130),
+			},
+			want: [][][]string{{
+				{"Item", "Qty"},
+				{"Pipes", "120"},
+			}},
+			used: 4,
+		},
+		{
+			name: "gap splits tables",
+			lines: []TextLine{
+				testLine("Item", 50, 100), testLine("Qty", 250, 100),
+				testLine("Pipes", 50, 115), testLine("120", 250, 115),
+				testLine("Date", 50, 300), testLine("Event", 250, 300),
+				testLine("01.04.2025", 50, 315), testLine("Pre-bid meeting", 250, 315),
+			},
+			want: [][][]string{
+				{{"Item", "Qty"}, {"Pipes", "120"}},
+				{{"Date", "Event"}, {"01.04.2025", "Pre-bid meeting"}},
+			},
+			used: 8,
+		},
+		{
+			name: "two column text layout",
+			lines: []TextLine{
+				testLine("The bidder shall submit the bid security along with the bid.", 50, 100),
+				testLine("Bids received after the due date and time shall be rejected.", 320, 100),
+				testLine("The bid security of unsuccessful bidders shall be returned.", 50, 115),
+				testLine("The employer may extend the due date by issuing an addendum.", 320, 115),
+			},
+			used: 0,
+		},
+		{
+			name: "single row",
+			lines: []TextLine{
+				testLine("Item", 50, 100), testLine("Qty", 250, 100),
+			},
+			used: 0,
+		},
+	}
+	for _, tt := range tests {
+		tables, used := detectTables(1, tt.lines)
+		if got := tableTexts(tables); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: tables = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(used) != tt.used {
+			t.Errorf("%s: %d lines used, want %d", tt.name, len(used), tt.used)
+		}
+	}
+}
+
+func TestBuildTableBBox(t *testing.T) {
+	lines := []TextLine{
+		testLine("Item", 50, 100), testLine("Qty", 250, 100),
+		testLine("Pipes", 50, 115), testLine("120", 250, 115),
+	}
+	table, ok := buildTable(3, lines, groupRows(lines))
+	if !ok {
+		t.Fatalf("buildTable reported no table")
+	}
+	if table.Page != 3 {
+		t.Errorf("page = %d, want 3", table.Page)
+	}
+	want := BBox{X0: 50, Y0: 100, X1: 265, Y1: 125}
+	if table.BBox != want {
+		t.Errorf("bbox = %+v, want %+v", table.BBox, want)
+	}
+	if got := table.Rows[1][1].BBox; got != lines[3].BBox {
+		t.Errorf("cell bbox = %+v, want %+v", got, lines[3].BBox)
+	}
+}
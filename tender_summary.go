@@ -2,19 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
-	"time"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/labstack/echo/v4"
 )
 
@@ -53,313 +45,204 @@ type RiskEntry struct {
 	Detail string `json:"detail"`
 }
 
-type Chunk struct {
-	StartPage int
-	EndPage   int
-	Text      string
-}
-
 type TenderSummaryResult struct {
 	Mode          string            `json:"mode"`
+	Status        string            `json:"status"`
 	Final         TenderSummaryData `json:"final"`
 	RawSingle     string            `json:"raw_single,omitempty"`
 	PartialsCount int               `json:"partials_count,omitempty"`
+	Repairs       int               `json:"repairs,omitempty"`
+	Plan          *ExtractionPlan   `json:"plan,omitempty"`
+	Cache         *LLMCacheStats    `json:"cache,omitempty"`
+	Usage         *UsageSummary     `json:"usage,omitempty"`
+	Prompts       []PromptRef       `json:"prompts,omitempty"`
+	// Verification is the critical field cross-check, when requested
+	Verification *CrossCheckResult `json:"verification,omitempty"`
 }
 
 // TenderSummaryExtractor handles tender summary extraction
 type TenderSummaryExtractor struct {
 	geminiService *GeminiService
-	pdfParser     *PDFParser
+	documents     *DocumentLoader
 }
 
 // NewTenderSummaryExtractor creates a new tender summary extractor
-func NewTenderSummaryExtractor(geminiService *GeminiService, pdfParser *PDFParser) *TenderSummaryExtractor {
+func NewTenderSummaryExtractor(geminiService *GeminiService, documents *DocumentLoader) *TenderSummaryExtractor {
 	return &TenderSummaryExtractor{
 		geminiService: geminiService,
-		pdfParser:     pdfParser,
+		documents:     documents,
 	}
 }
 
 // Prompt templates for tender summary
-const TENDER_SUMMARY_SINGLE_DOC_PROMPT = `You are an expert legal/tender document parser. From the DOCUMENT extract a Tender Summary (One Pager) as ONE strict JSON object with the exact keys:
-
-{
-  "project_overview": "short paragraph summarizing the project (do NOT invent)",
-  "eligibility_highlights": [ up to 4 most relevant eligibility items (strings) ],
-  "important_dates": {
-     "pre_bid_queries": "date or date range or text (if present)",
-     "bid_submission": "date/time",
-     "other_dates": [ {"name": "...", "date": "..."} ]
-  },
-  "financial_requirements": {
-     "contract_value": "value (one token or short text, e.g., 'INR 10,00,00,000')",
-     "document_fees": "value (one token or short text)"
-  },
-  "risk_analysis": {
-     "penalty_risk": "concise description of penalty risk if present (one sentence)",
-     "other_risks": [ {"name":"...", "detail":"..."} ]
-  }
-}
-
-Rules:
-- RESPOND IN VALID JSON ONLY, and nothing else.
-- Do NOT invent facts; if a field is not present, return an empty string or empty list as appropriate.
-- For every extracted value, include page provenance when possible by appending " (page X)" or " (pages X-Y)" inside the string.
-- For eligibility_highlights select the up to 4 most important/representative items from the document (prioritize clear bullet items or eligibility criteria).
-- For important_dates, try to find Pre-bid Queries and Bid Submission dates explicitly; place other notable dates in other_dates array.
-- For financial_requirements, return the short token/value for Contract Value and Document Fees. If multiple values exist, prefer the one clearly labelled 'Contract Value' and the published tender value.
-- For penalty_risk, summarize any clause that describes penalties or liquidated damages in one sentence.
-
-DOCUMENT:
-<<<DOC>>>`
-
-const TENDER_SUMMARY_CHUNK_PROMPT = `You are an expert tender document parser. From the DOCUMENT CHUNK extract the same Tender Summary object (use schema described below) and return a single VALID JSON object.
-
-Schema (exact keys):
-{
-  "project_overview": "...",
-  "eligibility_highlights": [...],
-  "important_dates": {"pre_bid_queries":"...","bid_submission":"...","other_dates":[{"name":"...","date":"..."}]},
-  "financial_requirements": {"contract_value":"...","document_fees":"..."},
-  "risk_analysis": {"penalty_risk":"...","other_risks":[{"name":"...","detail":"..."}]}
+// ExtractTenderSummary performs tender summary extraction with single-call and
+// chunked fallback on a parsed document, reading its tables as markdown and
+// the text of the language policy of ctx
+func (tse *TenderSummaryExtractor) ExtractTenderSummary(ctx context.Context, doc *ParsedDocument) (*TenderSummaryResult, error) {
+	log.Printf("Starting tender summary extraction for: %s (%d pages)", doc.Filename, len(doc.Pages))
+	return tse.ExtractTenderSummaryFromPages(ctx, doc.PageTexts(languagePolicyFrom(ctx)))
 }
 
-Rules:
-- Return JSON ONLY.
-- Include page provenance (append page numbers in parentheses).
-DOCUMENT CHUNK:
-<<<DOC>>>`
-
-// ExtractTenderSummary performs tender summary extraction with single-call and chunked fallback
-func (tse *TenderSummaryExtractor) ExtractTenderSummary(pdfPath string) (*TenderSummaryResult, error) {
-	log.Printf("Starting tender summary extraction for: %s", pdfPath)
-
-	// Extract pages from PDF
-	file, err := os.Open(pdfPath)
+// ExtractTenderSummaryFromPages runs the extraction on already parsed page
+// texts, with the critical field cross-check if ctx asks for it
+func (tse *TenderSummaryExtractor) ExtractTenderSummaryFromPages(ctx context.Context, pages []string) (*TenderSummaryResult, error) {
+	ctx = WithLLMExtractor(ctx, ExtractorSummary)
+	if !criticalFieldCheckFrom(ctx) {
+		return tse.extractSummaryFromPages(ctx, pages)
+	}
+
+	// The cross-check runs alongside the extraction
+	var verification *CrossCheckResult
+	var verificationRefs []PromptRef
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		verification, verificationRefs = tse.geminiService.CrossCheckCriticalFields(ctx, ExtractorSummary, formatPages(pages, 0, len(pages)))
+	}()
+
+	result, err := tse.extractSummaryFromPages(ctx, pages)
+	<-done
 	if err != nil {
-		return nil, fmt.Errorf("failed to open PDF: %v", err)
-	}
-	defer file.Close()
-
-	pages, err := tse.pdfParser.ExtractTextByPage(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract pages: %v", err)
-	}
-
-	log.Printf("Extracted %d pages from PDF", len(pages))
-
-	// Prepare full document text
-	var fullTextBuilder strings.Builder
-	for i, page := range pages {
-		fullTextBuilder.WriteString(fmt.Sprintf("[PAGE:%d]\n%s\n\n", i+1, page))
+		return nil, err
 	}
-	fullText := fullTextBuilder.String()
-
-	// 1. Single-call attempt with gemini-2.5-flash
-	log.Println("=== Attempting single full-document extraction with gemini-2.5-flash ===")
-	singlePrompt := strings.Replace(TENDER_SUMMARY_SINGLE_DOC_PROMPT, "<<<DOC>>>", fullText, 1)
+	result.Verification = verification
+	result.Prompts = append(result.Prompts, verificationRefs...)
+	return result, nil
+}
 
-	singleResp, err := tse.callGeminiFlash(singlePrompt)
-	if err != nil {
-		log.Printf("Single-call error: %v", err)
-	} else {
-		log.Printf("Single-call RAW preview: %s", truncateString(singleResp, 2000))
+func (tse *TenderSummaryExtractor) extractSummaryFromPages(ctx context.Context, pages []string) (*TenderSummaryResult, error) {
+	// Templates are fetched once so a reload cannot mix versions within one extraction
+	singleTmpl := promptRegistry().Get(PromptSummarySingle)
+	chunkTmpl := promptRegistry().Get(PromptSummaryChunk)
+
+	// Count tokens up front and decide between a single call and chunks
+	plan := tse.geminiService.planner.Plan(ctx, tse.geminiService.routes[ExtractorSummary].Flash, singleTmpl.Text, chunkTmpl.Text, pages)
+
+	repairs := 0
+	var promptRefs []PromptRef
+	mode := "chunked"
+	if plan.Mode == PlanModeSingleCall {
+		// 1. Single-call attempt with gemini-2.5-flash
+		log.Println("=== Attempting single full-document extraction with gemini-2.5-flash ===")
+		fullText := formatPages(pages, 0, len(pages))
+		singlePrompt, err := singleTmpl.Render(map[string]string{"Document": fullText})
+		if err != nil {
+			return nil, err
+		}
+		promptRefs = append(promptRefs, singleTmpl.Ref())
 
-		parsed := tse.safeParseJSON(singleResp)
-		if summaryData, ok := parsed.(*TenderSummaryData); ok && summaryData != nil {
-			log.Println("Single-call parsed OK — returning result")
+		var singleData TenderSummaryData
+		singleResp, err := tse.callGeminiFlash(WithUsageMode(ctx, UsageModeSingleCall), singlePrompt, &singleData)
+		if err == nil {
+			log.Println("Single-call validated OK — returning result")
 			return &TenderSummaryResult{
 				Mode:      "single_call",
-				Final:     *summaryData,
-				RawSingle: singleResp,
+				Status:    ResultStatusComplete,
+				Final:     singleData,
+				RawSingle: singleResp.Raw,
+				Repairs:   singleResp.Repairs,
+				Plan:      plan,
+				Prompts:   promptRefs,
 			}, nil
 		}
-		log.Println("Single-call returned unparsable structure — falling back to chunked extraction")
+		if singleResp != nil {
+			repairs += singleResp.Repairs
+		}
+		if ctx.Err() != nil {
+			// No budget left for chunks; return the empty summary with its status
+			return &TenderSummaryResult{
+				Mode:    "single_call",
+				Status:  logInterrupted(ctx, "Tender summary extraction"),
+				Final:   tse.getEmptyTenderSummary(),
+				Repairs: repairs,
+				Plan:    plan,
+				Prompts: promptRefs,
+			}, nil
+		}
+		log.Printf("Single-call failed (%v) — falling back to chunked extraction", err)
+		mode = "chunked_fallback"
+		plan.ChunkCount = len(plan.Chunks)
 	}
 
-	// 2. Fallback: chunked extraction
-	log.Println("=== Running chunked extraction (fallback) with gemini-2.5-flash ===")
-	chunks := tse.makeChunksFromPages(pages, 6, 1)
-	log.Printf("Built %d chunk(s)", len(chunks))
+	// 2. Chunked extraction, planned or as fallback
+	log.Println("=== Running chunked extraction with gemini-2.5-flash ===")
+	chunks := plan.Chunks
+	log.Printf("Built %d chunk(s) within %d tokens each", len(chunks), plan.ChunkTokenBudget)
+
+	type chunkOutcome struct {
+		data    TenderSummaryData
+		repairs int
+	}
 
+	// Chunks run concurrently under the shared per-model rate limiter and are
+	// merged in page order
 	var partialObjs []TenderSummaryData
-	for i, chunk := range chunks {
+	promptRefs = append(promptRefs, chunkTmpl.Ref())
+	runOrdered(ctx, len(chunks), chunkWorkers(), func(ctx context.Context, i int) chunkOutcome {
+		chunk := chunks[i]
 		log.Printf("--- chunk %d/%d pages %d-%d ---", i+1, len(chunks), chunk.StartPage, chunk.EndPage)
 
-		chunkPrompt := strings.Replace(TENDER_SUMMARY_CHUNK_PROMPT, "<<<DOC>>>", chunk.Text, 1)
-		resp, err := tse.callGeminiFlash(chunkPrompt)
+		var outcome chunkOutcome
+		chunkPrompt, err := chunkTmpl.Render(map[string]string{"Document": chunk.Text})
 		if err != nil {
-			log.Printf("Chunk %d error: %v", i+1, err)
-			partialObjs = append(partialObjs, tse.getEmptyTenderSummary())
-			continue
+			log.Printf("Warning: chunk %d failed (%v); storing empty placeholder", i+1, err)
+			outcome.data = tse.getEmptyTenderSummary()
+			return outcome
+		}
+		resp, err := tse.callGeminiFlash(WithLLMCallIndex(WithUsageMode(ctx, UsageModeChunk), i), chunkPrompt, &outcome.data)
+		if resp != nil {
+			outcome.repairs = resp.Repairs
+		}
+		if err != nil {
+			log.Printf("Warning: chunk %d failed (%v); storing empty placeholder", i+1, err)
+			outcome.data = tse.getEmptyTenderSummary()
+			return outcome
 		}
 
-		log.Printf("RAW preview: %s", truncateString(resp, 2000))
-
-		parsed := tse.safeParseJSON(resp)
-		if summaryData, ok := parsed.(*TenderSummaryData); ok && summaryData != nil {
-			// Add provenance to project overview if missing
-			if summaryData.ProjectOverview != "" && !strings.Contains(strings.ToLower(summaryData.ProjectOverview), "page") {
-				summaryData.ProjectOverview = fmt.Sprintf("%s (pages %d-%d)", summaryData.ProjectOverview, chunk.StartPage, chunk.EndPage)
-			}
-
-			// Add provenance to dates if missing
-			tse.addProvenanceToSummary(summaryData, chunk.StartPage, chunk.EndPage)
+		log.Printf("RAW preview: %s", truncateString(resp.Raw, 2000))
 
-			partialObjs = append(partialObjs, *summaryData)
-		} else {
-			log.Printf("Warning: chunk %d parsing failed; storing empty placeholder", i+1)
-			partialObjs = append(partialObjs, tse.getEmptyTenderSummary())
+		// Add provenance to project overview if missing
+		if outcome.data.ProjectOverview != "" && !strings.Contains(strings.ToLower(outcome.data.ProjectOverview), "page") {
+			outcome.data.ProjectOverview = fmt.Sprintf("%s (pages %d-%d)", outcome.data.ProjectOverview, chunk.StartPage, chunk.EndPage)
 		}
 
-		// Throttle requests
-		time.Sleep(500 * time.Millisecond)
-	}
+		// Add provenance to dates if missing
+		tse.addProvenanceToSummary(&outcome.data, chunk.StartPage, chunk.EndPage)
+
+		return outcome
+	}, func(i int, outcome chunkOutcome) bool {
+		repairs += outcome.repairs
+		partialObjs = append(partialObjs, outcome.data)
+		return true
+	})
+	status := logInterrupted(ctx, "Tender summary extraction")
 
 	// 3. Aggregate results
 	log.Println("=== Aggregating partial results ===")
 	final := tse.mergeTenderObjects(partialObjs)
 
 	return &TenderSummaryResult{
-		Mode:          "chunked_fallback",
+		Mode:          mode,
+		Status:        status,
 		Final:         final,
 		PartialsCount: len(partialObjs),
+		Repairs:       repairs,
+		Plan:          plan,
+		Prompts:       promptRefs,
 	}, nil
 }
 
-// callGeminiFlash calls Gemini Flash model
-func (tse *TenderSummaryExtractor) callGeminiFlash(prompt string) (string, error) {
-	if tse.geminiService == nil || tse.geminiService.flashModel == nil {
-		return "", fmt.Errorf("gemini service not initialized")
-	}
-
-	ctx := context.Background()
-	resp, err := tse.geminiService.flashModel.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		return "", err
-	}
-
-	if resp.Candidates == nil || len(resp.Candidates) == 0 {
-		return "", fmt.Errorf("no candidates in response")
-	}
-
-	candidate := resp.Candidates[0]
-	if candidate.Content == nil || len(candidate.Content.Parts) == 0 {
-		return "", fmt.Errorf("no content in candidate")
-	}
-
-	var result strings.Builder
-	for _, part := range candidate.Content.Parts {
-		result.WriteString(fmt.Sprintf("%v", part))
-	}
-
-	return result.String(), nil
-}
-
-// makeChunksFromPages creates chunks from pages
-func (tse *TenderSummaryExtractor) makeChunksFromPages(pages []string, pagesPerChunk, overlapPages int) []Chunk {
-	var chunks []Chunk
-	n := len(pages)
-	if n == 0 {
-		return chunks
-	}
-
-	i := 0
-	for i < n {
-		start := i
-		end := min(n, i+pagesPerChunk)
-
-		var textBuilder strings.Builder
-		for idx := start; idx < end; idx++ {
-			textBuilder.WriteString(fmt.Sprintf("[PAGE:%d]\n%s\n\n", idx+1, pages[idx]))
-		}
-
-		chunks = append(chunks, Chunk{
-			StartPage: start + 1,
-			EndPage:   end,
-			Text:      textBuilder.String(),
-		})
-
-		if end == n {
-			break
-		}
-		i = end - overlapPages
-	}
-
-	return chunks
-}
-
-// safeParseJSON safely parses JSON with fallback strategies
-func (tse *TenderSummaryExtractor) safeParseJSON(raw string) interface{} {
-	if raw == "" {
-		return nil
+// callGeminiFlash calls Gemini Flash model with the tender summary schema
+func (tse *TenderSummaryExtractor) callGeminiFlash(ctx context.Context, prompt string, out *TenderSummaryData) (*StructuredResult, error) {
+	if tse.geminiService == nil || tse.geminiService.llm == nil {
+		return nil, fmt.Errorf("gemini service not initialized")
 	}
 
-	cleaned := tse.cleanModelOutput(raw)
-
-	// Try direct parsing
-	var summaryData TenderSummaryData
-	if err := json.Unmarshal([]byte(cleaned), &summaryData); err == nil {
-		return &summaryData
-	}
-
-	// Try extracting JSON block
-	block := tse.extractJSONBlock(cleaned)
-	if block != "" {
-		if err := json.Unmarshal([]byte(block), &summaryData); err == nil {
-			return &summaryData
-		}
-
-		// Progressive trim fallback
-		for end := len(block); end > 0; end-- {
-			if err := json.Unmarshal([]byte(block[:end]), &summaryData); err == nil {
-				return &summaryData
-			}
-		}
-	}
-
-	return nil
-}
-
-// cleanModelOutput cleans model output
-func (tse *TenderSummaryExtractor) cleanModelOutput(raw string) string {
-	if raw == "" {
-		return ""
-	}
-
-	// Remove code block markers
-	re1 := regexp.MustCompile("(?i)```(?:json)?\\s*")
-	re2 := regexp.MustCompile("\\s*```")
-
-	cleaned := re1.ReplaceAllString(raw, "")
-	cleaned = re2.ReplaceAllString(cleaned, "")
-
-	return strings.TrimSpace(cleaned)
-}
-
-// extractJSONBlock extracts JSON block from text
-func (tse *TenderSummaryExtractor) extractJSONBlock(text string) string {
-	if text == "" {
-		return ""
-	}
-
-	// Find JSON objects or arrays
-	re := regexp.MustCompile(`(\{[\s\S]*\}|\[[\s\S]*\])`)
-	matches := re.FindAllString(text, -1)
-
-	if len(matches) == 0 {
-		return ""
-	}
-
-	// Return the longest match
-	longest := ""
-	for _, match := range matches {
-		if len(match) > len(longest) {
-			longest = match
-		}
-	}
-
-	return longest
+	return generateStructured(ctx, tse.geminiService.llm, LLMRequest{
+		Model:  tse.geminiService.routes[ExtractorSummary].Flash,
+		Prompt: prompt,
+		Config: tse.geminiService.config,
+	}, out)
 }
 
 // getEmptyTenderSummary returns empty tender summary structure
@@ -517,37 +400,40 @@ func (tse *TenderSummaryExtractor) dedupeOtherRisks(risks []RiskEntry) []RiskEnt
 	return unique
 }
 
-// HTTP handler for tender summary extraction
+// HTTP handler for tender summary extraction, on an uploaded file or a
+// stored document
 func (tse *TenderSummaryExtractor) HandleTenderSummaryExtraction(c echo.Context) error {
-	// Parse multipart form
-	err := c.Request().ParseMultipartForm(32 << 20) // 32MB max
+	language, err := languagePolicyRequested(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to parse form"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	file, header, err := c.Request().FormFile("pdf")
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No PDF file provided"})
-	}
-	defer file.Close()
+	// The deadline covers parsing as well as the model calls
+	ctx, cacheStats := llmCacheContext(c)
+	ctx, cancel := withRequestDeadline(ctx, ExtractorSummary)
+	defer cancel()
 
-	// Save uploaded file temporarily
-	tempPath, cleanup, err := saveUploadedFile(file, header.Filename)
+	doc, err := tse.documents.FromRequest(ctx, c, "pdf")
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save uploaded file"})
+		log.Printf("Error loading document for tender summary: %v", err)
+		return c.JSON(documentErrorStatus(err), withRequestStatus(ctx, documentErrorBody(err)))
 	}
-	defer cleanup()
-
-	log.Printf("Processing tender summary extraction for: %s", header.Filename)
+	log.Printf("Processing tender summary extraction for: %s", doc.Filename)
 
 	// Extract tender summary
-	result, err := tse.ExtractTenderSummary(tempPath)
+	ctx, usage := WithUsageCollector(ctx, c.Path())
+	ctx = WithLanguagePolicy(ctx, language)
+	ctx = WithCriticalFieldCheck(ctx, criticalFieldCheckRequested(c))
+	result, err := tse.ExtractTenderSummary(ctx, doc)
+	tse.documents.RecordUsage(c, usage.Summary())
 	if err != nil {
 		log.Printf("Tender summary extraction failed: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Extraction failed: %v", err)})
+		return c.JSON(llmErrorStatus(err), withRequestStatus(ctx, llmErrorBody(fmt.Sprintf("Extraction failed: %v", err), err)))
 	}
 
-	log.Printf("Tender summary extraction completed successfully for: %s", header.Filename)
+	result.Cache = cacheStats
+	result.Usage = usage.Summary()
+	log.Printf("Tender summary extraction completed successfully for: %s", doc.Filename)
 	return c.JSON(http.StatusOK, result)
 }
 
@@ -566,30 +452,3 @@ func min(a, b int) int {
 	}
 	return b
 }
-
-// saveUploadedFile saves an uploaded file temporarily and returns the path and cleanup function
-func saveUploadedFile(file multipart.File, filename string) (string, func(), error) {
-	// Create temporary file
-	tempFile, err := os.CreateTemp("", "tender_*_"+filepath.Base(filename))
-	if err != nil {
-		return "", nil, err
-	}
-	
-	tempPath := tempFile.Name()
-	
-	// Copy uploaded file to temporary file
-	_, err = io.Copy(tempFile, file)
-	tempFile.Close()
-	
-	if err != nil {
-		os.Remove(tempPath)
-		return "", nil, err
-	}
-	
-	// Return path and cleanup function
-	cleanup := func() {
-		os.Remove(tempPath)
-	}
-	
-	return tempPath, cleanup, nil
-}
@@ -1,10 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -15,7 +12,7 @@ import (
 type TenderIQHandler struct {
 	geminiService *GeminiService
 	vectorStore   *VectorStore
-	pdfParser     *PDFParser
+	documents     *DocumentLoader
 }
 
 type UploadResponse struct {
@@ -23,20 +20,27 @@ type UploadResponse struct {
 	Filename   string                 `json:"filename"`
 	Pages      int                    `json:"pages"`
 	Metadata   map[string]interface{} `json:"metadata"`
+	Outline    *DocumentOutline       `json:"outline,omitempty"`
 	Message    string                 `json:"message"`
 }
 
 type AnalysisRequest struct {
 	DocumentID string `json:"document_id"`
 	Query      string `json:"query"`
+	// Verify cross-checks the critical fields with a second model
+	Verify bool `json:"verify,omitempty"`
 }
 
 type AnalysisResponse struct {
-	DocumentID     string          `json:"document_id"`
-	Query          string          `json:"query"`
-	Analysis       TenderAnalysis  `json:"analysis"`
-	RelevantChunks []SearchResult  `json:"relevant_chunks"`
-	Message        string          `json:"message"`
+	DocumentID     string         `json:"document_id"`
+	Query          string         `json:"query"`
+	Analysis       TenderAnalysis `json:"analysis"`
+	RelevantChunks []SearchResult `json:"relevant_chunks"`
+	Message        string         `json:"message"`
+	Status         string         `json:"status"`
+	Cache          *LLMCacheStats `json:"cache,omitempty"`
+	Usage          *UsageSummary  `json:"usage,omitempty"`
+	Prompts        []PromptRef    `json:"prompts,omitempty"`
 }
 
 type TenderAnalysis struct {
@@ -50,6 +54,8 @@ type TenderAnalysis struct {
 	EligibilityHighlights []string             `json:"eligibility_highlights"`
 	ImportantDates      ImportantDates         `json:"important_dates"`
 	RiskAnalysis        RiskAnalysis           `json:"risk_analysis"`
+	// Verification is filled in after decoding, so it is not part of the response schema
+	Verification *CrossCheckResult `json:"verification,omitempty" schema:"-"`
 }
 
 type FinancialRequirements struct {
@@ -83,87 +89,45 @@ type DocumentInfo struct {
 	Metadata map[string]interface{} `json:"metadata"`
 }
 
-func NewTenderIQHandler(geminiService *GeminiService, vectorStore *VectorStore, pdfParser *PDFParser) *TenderIQHandler {
+func NewTenderIQHandler(geminiService *GeminiService, vectorStore *VectorStore, documents *DocumentLoader) *TenderIQHandler {
 	return &TenderIQHandler{
 		geminiService: geminiService,
 		vectorStore:   vectorStore,
-		pdfParser:     pdfParser,
+		documents:     documents,
 	}
 }
 
-// Upload and parse PDF document
+// Upload and parse a document or tender package
 func (h *TenderIQHandler) UploadDocument(c echo.Context) error {
-	// Get the uploaded file
-	file, err := c.FormFile("file")
+	// Extract the text of every file; PDF pages without a text layer are
+	// transcribed from images within the upload deadline. A partly
+	// transcribed document is not stored.
+	ctx, cancel := withRequestDeadline(c.Request().Context(), EndpointUpload)
+	defer cancel()
+	parsed, err := h.documents.FromUpload(ctx, c, "file")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "No file uploaded or invalid file",
-		})
-	}
-
-	// Check if it's a PDF file
-	if !strings.HasSuffix(strings.ToLower(file.Filename), ".pdf") {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Only PDF files are supported",
-		})
-	}
-
-	// Open the uploaded file
-	src, err := file.Open()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to open uploaded file",
-		})
-	}
-	defer src.Close()
-
-	// Read file content into memory
-	fileContent, err := io.ReadAll(src)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to read file content",
-		})
-	}
-
-	// Create a reader from the content
-	reader := bytes.NewReader(fileContent)
-
-	// Extract text from PDF
-	extractedText, err := h.pdfParser.ExtractText(reader, int64(len(fileContent)))
-	if err != nil {
-		log.Printf("PDF parsing error: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to extract text from PDF: " + err.Error(),
-		})
+		return c.JSON(documentErrorStatus(err), withRequestStatus(ctx, documentErrorBody(err)))
 	}
 
-	// Extract metadata
-	reader.Seek(0, 0) // Reset reader position
-	metadata, err := h.pdfParser.ExtractMetadata(reader, int64(len(fileContent)))
-	if err != nil {
-		log.Printf("Metadata extraction error: %v", err)
-		metadata = make(map[string]interface{})
-	}
-
-	// Add filename to metadata
-	metadata["filename"] = file.Filename
-	metadata["file_size"] = len(fileContent)
-
-	// Store document in vector store
-	docID, err := h.vectorStore.AddDocument(extractedText, metadata)
+	// Store document in vector store, with its outline: headings from
+	// bookmarks, fonts or styles, and the files of a package, let section
+	// analysis follow the document's own structure
+	docID, err := h.vectorStore.AddParsedDocument(parsed)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to store document: " + err.Error(),
 		})
 	}
+	metadata, outline := parsed.Metadata, parsed.Outline
 
 	pages, _ := metadata["num_pages"].(int)
 	
 	response := UploadResponse{
 		DocumentID: docID,
-		Filename:   file.Filename,
+		Filename:   parsed.Filename,
 		Pages:      pages,
 		Metadata:   metadata,
+		Outline:    outline,
 		Message:    "Document uploaded and processed successfully",
 	}
 
@@ -189,6 +153,13 @@ func (h *TenderIQHandler) AnalyzeDocument(c echo.Context) error {
 		req.Query = "Provide a comprehensive analysis of this tender document including key requirements, financial details, and important dates."
 	}
 
+	language, err := languagePolicyRequested(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
 	// Get document from vector store
 	document, exists := h.vectorStore.GetDocument(req.DocumentID)
 	if !exists {
@@ -196,6 +167,11 @@ func (h *TenderIQHandler) AnalyzeDocument(c echo.Context) error {
 			"error": "Document not found",
 		})
 	}
+	// Only the text of the requested language goes into the prompts
+	content := document.Content
+	if document.Parsed != nil {
+		content = document.Parsed.TextFor(language)
+	}
 
 	// Search for relevant chunks
 	relevantChunks, err := h.vectorStore.SearchSimilar(req.Query, 5)
@@ -207,61 +183,43 @@ func (h *TenderIQHandler) AnalyzeDocument(c echo.Context) error {
 	// Combine relevant chunks for context
 	var contextText strings.Builder
 	contextText.WriteString("DOCUMENT SUMMARY:\n")
-	contextText.WriteString(document.Content[:min(2000, len(document.Content))])
+	contextText.WriteString(content[:min(2000, len(content))])
 	contextText.WriteString("\n\nRELEVANT SECTIONS:\n")
 	
 	for _, chunk := range relevantChunks {
 		contextText.WriteString(fmt.Sprintf("- %s\n", chunk.Content))
 	}
 
-	// Analyze with Gemini
-	analysisJSON, err := h.geminiService.AnalyzeTenderDocument(contextText.String(), req.Query)
+	// Analyze with Gemini; the response is validated against the TenderAnalysis schema
+	ctx, cacheStats := llmCacheContext(c)
+	ctx, cancel := withRequestDeadline(ctx, ExtractorAnalyze)
+	defer cancel()
+	ctx, usage := WithUsageCollector(ctx, c.Path())
+	tenderAnalysis, promptRefs, err := h.geminiService.AnalyzeTenderDocument(ctx, contextText.String(), req.Query)
 	if err != nil {
+		h.vectorStore.RecordUsage(req.DocumentID, usage.Summary())
 		log.Printf("Gemini analysis error: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to analyze document: " + err.Error(),
-		})
+		return c.JSON(llmErrorStatus(err), withRequestStatus(ctx, llmErrorBody("Failed to analyze document: "+err.Error(), err)))
 	}
 
-	// Parse the JSON response from Gemini
-	var tenderAnalysis TenderAnalysis
-	if err := json.Unmarshal([]byte(analysisJSON), &tenderAnalysis); err != nil {
-		log.Printf("JSON parsing error: %v", err)
-		// Fallback to a default structure if JSON parsing fails
-		tenderAnalysis = TenderAnalysis{
-			TenderID:         "Not extracted",
-			Title:            "Document Analysis",
-			DueDate:          "Not specified",
-			IssuingAuthority: "Not specified",
-			ContractValue:    "Not specified",
-			ProjectOverview:  analysisJSON, // Use raw text as fallback
-			FinancialReqs: FinancialRequirements{
-				ContractValue: "Not specified",
-				EMD:          "Not specified",
-				PerformanceBG: "Not specified",
-				DocumentFees:  "Not specified",
-			},
-			EligibilityHighlights: []string{"Analysis available in project overview"},
-			ImportantDates: ImportantDates{
-				PreBidQueries:       "Not specified",
-				BidSubmission:       "Not specified",
-				TechnicalBidOpening: "Not specified",
-				FinancialBidOpening: "Not specified",
-			},
-			RiskAnalysis: RiskAnalysis{
-				PenaltyRisk: "Not specified",
-				Retention:   "Not specified",
-				KeyRisks:    []string{"Please review document manually"},
-			},
-		}
+	// The cross-check reads the whole document, not just the retrieved context
+	if req.Verify || criticalFieldCheckRequested(c) {
+		verification, verificationRefs := h.geminiService.CrossCheckCriticalFields(ctx, ExtractorAnalyze, content)
+		tenderAnalysis.Verification = verification
+		promptRefs = append(promptRefs, verificationRefs...)
 	}
+	h.vectorStore.RecordUsage(req.DocumentID, usage.Summary())
 
 	response := AnalysisResponse{
 		DocumentID:     req.DocumentID,
 		Query:          req.Query,
-		Analysis:       tenderAnalysis,
+		Analysis:       *tenderAnalysis,
 		RelevantChunks: relevantChunks,
 		Message:        "Document analysis completed successfully",
+		Status:         resultStatus(ctx),
+		Cache:          cacheStats,
+		Usage:          usage.Summary(),
+		Prompts:        promptRefs,
 	}
 
 	return c.JSON(http.StatusOK, response)
@@ -275,7 +233,7 @@ func (h *TenderIQHandler) ListDocuments(c echo.Context) error {
 	for _, docID := range docIDs {
 		if doc, exists := h.vectorStore.GetDocument(docID); exists {
 			filename, _ := doc.Metadata["filename"].(string)
-			pages, _ := doc.Metadata["num_pages"].(int)
+			pages := metadataInt(doc.Metadata, "num_pages")
 			
 			documents = append(documents, DocumentInfo{
 				ID:       docID,
@@ -373,24 +331,51 @@ func (h *TenderIQHandler) AnalyzeSections(c echo.Context) error {
 		})
 	}
 
+	language, err := languagePolicyRequested(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
 	// Get document from vector store
 	doc, exists := h.vectorStore.GetDocument(request.DocumentID)
-	if !exists {
+	if !exists || doc.Parsed == nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error": "Document not found",
 		})
 	}
 
 	// Perform section-wise analysis with optimized fallback strategy
-	sectionsResult, err := h.geminiService.ExtractSectionwiseAnalysis(doc.Content)
+	ctx, cacheStats := llmCacheContext(c)
+	ctx, cancel := withRequestDeadline(ctx, ExtractorSections)
+	defer cancel()
+	ctx, usage := WithUsageCollector(ctx, c.Path())
+	ctx = WithLanguagePolicy(ctx, language)
+	sectionsResult, err := h.geminiService.ExtractSectionwiseAnalysis(ctx, doc.Parsed)
+	h.vectorStore.RecordUsage(request.DocumentID, usage.Summary())
 	if err != nil {
 		log.Printf("Section-wise analysis failed: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Section-wise analysis failed"})
+		return c.JSON(llmErrorStatus(err), withRequestStatus(ctx, llmErrorBody("Section-wise analysis failed", err)))
 	}
+	sectionsResult.Cache = cacheStats
+	sectionsResult.Usage = usage.Summary()
 
 	return c.JSON(http.StatusOK, sectionsResult)
 }
 
+// metadataInt reads a number from document metadata, where it is a float64
+// once the document has been read back from storage
+func metadataInt(metadata map[string]interface{}, key string) int {
+	switch n := metadata[key].(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
 // Helper function for min
 func minValue(a, b int) int {
 	if a < b {
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Usage modes recorded for model calls, set by extractors via WithUsageMode
+const (
+	UsageModeSingleCall   = "single_call"
+	UsageModeChunk        = "chunk"
+	UsageModeAggregate    = "aggregate"
+	UsageModeRepair       = "repair"
+	UsageModeContinuation = "continuation"
+	UsageModeCrossCheck   = "cross_check"
+	UsageModeTranscribe   = "transcription"
+	UsageModeChat         = "chat"
+)
+
+// ModelPrice is the USD price per million tokens of a model
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// Default list prices; override with a JSON file in TENDERIQ_PRICE_TABLE
+var defaultPriceTable = map[string]ModelPrice{
+	ModelGeminiPro:   {InputPerMillion: 1.25, OutputPerMillion: 10.00},
+	ModelGeminiFlash: {InputPerMillion: 0.30, OutputPerMillion: 2.50},
+	"gpt-3.5-turbo":  {InputPerMillion: 0.50, OutputPerMillion: 1.50},
+}
+
+var (
+	priceTableOnce sync.Once
+	priceTable     map[string]ModelPrice
+)
+
+// prices returns the price table, loading TENDERIQ_PRICE_TABLE on first use.
+// Entries in the file replace or extend the defaults.
+func prices() map[string]ModelPrice {
+	priceTableOnce.Do(func() {
+		priceTable = make(map[string]ModelPrice, len(defaultPriceTable))
+		for model, price := range defaultPriceTable {
+			priceTable[model] = price
+		}
+
+		path := os.Getenv("TENDERIQ_PRICE_TABLE")
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: failed to read price table %s: %v", path, err)
+			return
+		}
+		var custom map[string]ModelPrice
+		if err := json.Unmarshal(data, &custom); err != nil {
+			log.Printf("Warning: failed to parse price table %s: %v", path, err)
+			return
+		}
+		for model, price := range custom {
+			priceTable[model] = price
+		}
+		log.Printf("Loaded prices for %d model(s) from %s", len(custom), path)
+	})
+	return priceTable
+}
+
+// UsageRecord is the usage of a single model call
+type UsageRecord struct {
+	Time         time.Time
+	Endpoint     string
+	Provider     string
+	Model        string
+	Mode         string
+	PromptTokens int
+	OutputTokens int
+	Latency      time.Duration
+	Cached       bool
+	Estimated    bool
+}
+
+// Cost prices the record; cached responses are free
+func (r UsageRecord) Cost() float64 {
+	if r.Cached {
+		return 0
+	}
+	price := prices()[r.Model]
+	return (float64(r.PromptTokens)*price.InputPerMillion + float64(r.OutputTokens)*price.OutputPerMillion) / 1e6
+}
+
+// UsageTotals accumulates usage records. Token counts the provider did not
+// report, such as Gemini's, are estimated from the text length and kept
+// apart from the reported counts; the cost prices both.
+type UsageTotals struct {
+	Calls                 int     `json:"calls"`
+	CachedCalls           int     `json:"cached_calls,omitempty"`
+	PromptTokens          int     `json:"prompt_tokens"`
+	OutputTokens          int     `json:"output_tokens"`
+	EstimatedCalls        int     `json:"estimated_calls,omitempty"`
+	EstimatedPromptTokens int     `json:"estimated_prompt_tokens,omitempty"`
+	EstimatedOutputTokens int     `json:"estimated_output_tokens,omitempty"`
+	LatencyMs             int64   `json:"latency_ms"`
+	CostUSD               float64 `json:"cost_usd"`
+}
+
+func (t *UsageTotals) add(r UsageRecord) {
+	t.Calls++
+	if r.Cached {
+		t.CachedCalls++
+	}
+	if r.Estimated {
+		t.EstimatedCalls++
+		t.EstimatedPromptTokens += r.PromptTokens
+		t.EstimatedOutputTokens += r.OutputTokens
+	} else {
+		t.PromptTokens += r.PromptTokens
+		t.OutputTokens += r.OutputTokens
+	}
+	t.LatencyMs += r.Latency.Milliseconds()
+	t.CostUSD += r.Cost()
+}
+
+func (t *UsageTotals) merge(other UsageTotals) {
+	t.Calls += other.Calls
+	t.CachedCalls += other.CachedCalls
+	t.PromptTokens += other.PromptTokens
+	t.OutputTokens += other.OutputTokens
+	t.EstimatedCalls += other.EstimatedCalls
+	t.EstimatedPromptTokens += other.EstimatedPromptTokens
+	t.EstimatedOutputTokens += other.EstimatedOutputTokens
+	t.LatencyMs += other.LatencyMs
+	t.CostUSD += other.CostUSD
+}
+
+// UsageSummary is the usage block returned with results and stored on documents
+type UsageSummary struct {
+	UsageTotals
+	ByModel map[string]*UsageTotals `json:"by_model,omitempty"`
+	ByMode  map[string]*UsageTotals `json:"by_mode,omitempty"`
+}
+
+func newUsageSummary() *UsageSummary {
+	return &UsageSummary{
+		ByModel: make(map[string]*UsageTotals),
+		ByMode:  make(map[string]*UsageTotals),
+	}
+}
+
+func (s *UsageSummary) add(r UsageRecord) {
+	s.UsageTotals.add(r)
+	addTotals(s.ByModel, r.Model, r)
+	if r.Mode != "" {
+		addTotals(s.ByMode, r.Mode, r)
+	}
+}
+
+// Merge folds another summary into s
+func (s *UsageSummary) Merge(other *UsageSummary) {
+	if other == nil {
+		return
+	}
+	s.UsageTotals.merge(other.UsageTotals)
+	// Summaries read back from storage lack the maps they had no entries in
+	if s.ByModel == nil {
+		s.ByModel = make(map[string]*UsageTotals)
+	}
+	if s.ByMode == nil {
+		s.ByMode = make(map[string]*UsageTotals)
+	}
+	for model, totals := range other.ByModel {
+		if s.ByModel[model] == nil {
+			s.ByModel[model] = &UsageTotals{}
+		}
+		s.ByModel[model].merge(*totals)
+	}
+	for mode, totals := range other.ByMode {
+		if s.ByMode[mode] == nil {
+			s.ByMode[mode] = &UsageTotals{}
+		}
+		s.ByMode[mode].merge(*totals)
+	}
+}
+
+func addTotals(m map[string]*UsageTotals, key string, r UsageRecord) {
+	totals, ok := m[key]
+	if !ok {
+		totals = &UsageTotals{}
+		m[key] = totals
+	}
+	totals.add(r)
+}
+
+// UsageCollector gathers the model calls made while serving one HTTP request
+type UsageCollector struct {
+	endpoint string
+
+	mu      sync.Mutex
+	summary *UsageSummary
+}
+
+// Summary returns a copy of the usage collected so far
+func (c *UsageCollector) Summary() *UsageSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	summary := newUsageSummary()
+	summary.Merge(c.summary)
+	return summary
+}
+
+func (c *UsageCollector) record(r UsageRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.summary.add(r)
+}
+
+type usageCollectorKey struct{}
+type usageModeKey struct{}
+
+// WithUsageCollector attaches a collector for endpoint to ctx
+func WithUsageCollector(ctx context.Context, endpoint string) (context.Context, *UsageCollector) {
+	collector := &UsageCollector{endpoint: endpoint, summary: newUsageSummary()}
+	return context.WithValue(ctx, usageCollectorKey{}, collector), collector
+}
+
+// WithUsageMode labels the model calls made with ctx, e.g. "chunk"
+func WithUsageMode(ctx context.Context, mode string) context.Context {
+	return context.WithValue(ctx, usageModeKey{}, mode)
+}
+
+func usageCollectorFrom(ctx context.Context) *UsageCollector {
+	collector, _ := ctx.Value(usageCollectorKey{}).(*UsageCollector)
+	return collector
+}
+
+func usageModeFrom(ctx context.Context) string {
+	mode, _ := ctx.Value(usageModeKey{}).(string)
+	return mode
+}
+
+// usageKey groups ledger totals
+type usageKey struct {
+	Day      string
+	Endpoint string
+	Model    string
+}
+
+// UsageLedger keeps process-wide usage totals by day, endpoint and model
+type UsageLedger struct {
+	mu     sync.Mutex
+	totals map[usageKey]*UsageTotals
+}
+
+func NewUsageLedger() *UsageLedger {
+	return &UsageLedger{totals: make(map[usageKey]*UsageTotals)}
+}
+
+var usageLedger = NewUsageLedger()
+
+func (l *UsageLedger) Record(r UsageRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := usageKey{Day: r.Time.UTC().Format("2006-01-02"), Endpoint: r.Endpoint, Model: r.Model}
+	totals, ok := l.totals[key]
+	if !ok {
+		totals = &UsageTotals{}
+		l.totals[key] = totals
+	}
+	totals.add(r)
+}
+
+// UsageRow is one day/endpoint/model line of the usage report
+type UsageRow struct {
+	Day      string `json:"day"`
+	Endpoint string `json:"endpoint"`
+	Model    string `json:"model"`
+	UsageTotals
+}
+
+// UsageReport is the body of GET /api/tenderiq/usage
+type UsageReport struct {
+	From       string                  `json:"from,omitempty"`
+	To         string                  `json:"to,omitempty"`
+	Total      UsageTotals             `json:"total"`
+	ByDay      map[string]*UsageTotals `json:"by_day"`
+	ByEndpoint map[string]*UsageTotals `json:"by_endpoint"`
+	ByModel    map[string]*UsageTotals `json:"by_model"`
+	Rows       []UsageRow              `json:"rows"`
+	Prices     map[string]ModelPrice   `json:"prices"`
+	// Notes explain figures that are not what the providers reported
+	Notes []string `json:"notes,omitempty"`
+}
+
+// estimatedUsageNote explains the estimated_* figures of a report
+const estimatedUsageNote = "%d call(s) had no token counts reported by the provider: the Gemini SDK in use returns no usage metadata. " +
+	"Their tokens are estimated at ~4 characters per token and counted in estimated_prompt_tokens and estimated_output_tokens, not prompt_tokens and output_tokens; cost_usd includes them."
+
+// Report totals the ledger for days in [from, to]; empty bounds are open
+func (l *UsageLedger) Report(from, to string) *UsageReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report := &UsageReport{
+		From:       from,
+		To:         to,
+		ByDay:      make(map[string]*UsageTotals),
+		ByEndpoint: make(map[string]*UsageTotals),
+		ByModel:    make(map[string]*UsageTotals),
+		Rows:       []UsageRow{},
+		Prices:     prices(),
+	}
+
+	for key, totals := range l.totals {
+		if (from != "" && key.Day < from) || (to != "" && key.Day > to) {
+			continue
+		}
+		report.Total.merge(*totals)
+		mergeInto(report.ByDay, key.Day, *totals)
+		mergeInto(report.ByEndpoint, key.Endpoint, *totals)
+		mergeInto(report.ByModel, key.Model, *totals)
+		report.Rows = append(report.Rows, UsageRow{Day: key.Day, Endpoint: key.Endpoint, Model: key.Model, UsageTotals: *totals})
+	}
+
+	if report.Total.EstimatedCalls > 0 {
+		report.Notes = append(report.Notes, fmt.Sprintf(estimatedUsageNote, report.Total.EstimatedCalls))
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Endpoint != b.Endpoint {
+			return a.Endpoint < b.Endpoint
+		}
+		return a.Model < b.Model
+	})
+
+	return report
+}
+
+func mergeInto(m map[string]*UsageTotals, key string, totals UsageTotals) {
+	if m[key] == nil {
+		m[key] = &UsageTotals{}
+	}
+	m[key].merge(totals)
+}
+
+// MeteredLLMClient records the usage of every call in the request's
+// collector and the process-wide ledger
+type MeteredLLMClient struct {
+	next LLMClient
+}
+
+func NewMeteredLLMClient(next LLMClient) *MeteredLLMClient {
+	return &MeteredLLMClient{next: next}
+}
+
+func (m *MeteredLLMClient) Provider() string {
+	return m.next.Provider()
+}
+
+func (m *MeteredLLMClient) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	resp, err := m.next.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	record := UsageRecord{
+		Time:         time.Now(),
+		Provider:     m.next.Provider(),
+		Model:        req.Model,
+		Mode:         usageModeFrom(ctx),
+		PromptTokens: resp.Usage.PromptTokens,
+		OutputTokens: resp.Usage.OutputTokens,
+		Latency:      resp.Usage.Latency,
+		Cached:       resp.Cached,
+		Estimated:    resp.Usage.Estimated,
+	}
+	if collector := usageCollectorFrom(ctx); collector != nil {
+		record.Endpoint = collector.endpoint
+		collector.record(record)
+	}
+	usageLedger.Record(record)
+
+	return resp, nil
+}
+
+func (m *MeteredLLMClient) CountTokens(ctx context.Context, model string, text string) (int, error) {
+	return m.next.CountTokens(ctx, model, text)
+}
+
+func (m *MeteredLLMClient) Close() {
+	if closer, ok := m.next.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// HandleUsage reports usage totals by day, endpoint and model. Optional
+// from/to query parameters (YYYY-MM-DD) bound the days included.
+func HandleUsage(c echo.Context) error {
+	from, to := c.QueryParam("from"), c.QueryParam("to")
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Invalid date %q, expected YYYY-MM-DD", day),
+			})
+		}
+	}
+
+	return c.JSON(http.StatusOK, usageLedger.Report(from, to))
+}
@@ -11,10 +11,12 @@ import (
 	"sync"
 )
 
-// Simple in-memory vector store for document embeddings
+// Simple in-memory vector store for document embeddings, writing through to
+// a storage that keeps them across restarts
 type VectorStore struct {
 	documents map[string]*Document
 	mutex     sync.RWMutex
+	storage   DocumentStorage
 }
 
 type Document struct {
@@ -22,6 +24,11 @@ type Document struct {
 	Content  string                 `json:"content"`
 	Metadata map[string]interface{} `json:"metadata"`
 	Chunks   []DocumentChunk        `json:"chunks"`
+	Usage    *UsageSummary          `json:"usage,omitempty"`
+	Outline  *DocumentOutline       `json:"outline,omitempty"`
+	FileHash string                 `json:"file_hash,omitempty"`
+	// Parsed is the parse the document was stored from
+	Parsed *ParsedDocument `json:"-"`
 }
 
 type DocumentChunk struct {
@@ -39,16 +46,86 @@ type SearchResult struct {
 	Metadata   map[string]interface{} `json:"metadata"`
 }
 
+// NewVectorStore creates a store that keeps documents in memory only
 func NewVectorStore() *VectorStore {
 	return &VectorStore{
 		documents: make(map[string]*Document),
+		storage:   MemoryStorage{},
 	}
 }
 
+// NewVectorStoreWithStorage creates a store holding the documents of
+// storage, and writing every change to it
+func NewVectorStoreWithStorage(storage DocumentStorage) (*VectorStore, error) {
+	docs, err := storage.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored documents: %w", err)
+	}
+	vs := &VectorStore{
+		documents: make(map[string]*Document, len(docs)),
+		storage:   storage,
+	}
+	for _, doc := range docs {
+		vs.documents[doc.ID] = doc
+	}
+	log.Printf("Loaded %d stored documents", len(docs))
+	return vs, nil
+}
+
+// NewVectorStoreFromEnv creates a store with the storage chosen by
+// TENDERIQ_STORE
+func NewVectorStoreFromEnv() (*VectorStore, error) {
+	storage, err := documentStorageFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewVectorStoreWithStorage(storage)
+}
+
+// Close closes the store's storage
+func (vs *VectorStore) Close() error {
+	return vs.storage.Close()
+}
+
 func (vs *VectorStore) AddDocument(content string, metadata map[string]interface{}) (string, error) {
+	doc := vs.newDocument(content, metadata)
+	if err := vs.putDocument(doc); err != nil {
+		return "", err
+	}
+	return doc.ID, nil
+}
+
+// putDocument stores a document, replacing any with the same ID but keeping
+// its usage total. Only writing the change to storage holds the lock; the
+// document is encoded before and synced to disk after.
+func (vs *VectorStore) putDocument(doc *Document) error {
+	encoded, err := vs.storage.Encode(doc)
+	if err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+
 	vs.mutex.Lock()
-	defer vs.mutex.Unlock()
+	if existing, exists := vs.documents[doc.ID]; exists {
+		doc.Usage = existing.Usage
+	}
+	err = vs.storage.Put(encoded)
+	if err == nil {
+		vs.documents[doc.ID] = doc
+	}
+	vs.mutex.Unlock()
 
+	if err == nil {
+		err = vs.storage.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+	log.Printf("Added document %s with %d chunks", doc.ID, len(doc.Chunks))
+	return nil
+}
+
+// newDocument chunks content and embeds the chunks
+func (vs *VectorStore) newDocument(content string, metadata map[string]interface{}) *Document {
 	// Generate document ID
 	docID := fmt.Sprintf("%x", md5.Sum([]byte(content)))
 
@@ -71,17 +148,29 @@ func (vs *VectorStore) AddDocument(content string, metadata map[string]interface
 		})
 	}
 
-	document := &Document{
+	return &Document{
 		ID:       docID,
 		Content:  content,
 		Metadata: metadata,
 		Chunks:   documentChunks,
 	}
+}
 
-	vs.documents[docID] = document
-	log.Printf("Added document %s with %d chunks", docID, len(documentChunks))
-
-	return docID, nil
+// AddParsedDocument stores the text of a parsed upload along with the parse,
+// so later requests on the document need not parse the file again
+func (vs *VectorStore) AddParsedDocument(parsed *ParsedDocument) (string, error) {
+	metadata := make(map[string]interface{}, len(parsed.Metadata))
+	for key, value := range parsed.Metadata {
+		metadata[key] = value
+	}
+	doc := vs.newDocument(parsed.Text(), metadata)
+	doc.Parsed = parsed
+	doc.Outline = parsed.Outline
+	doc.FileHash = parsed.Hash
+	if err := vs.putDocument(doc); err != nil {
+		return "", err
+	}
+	return doc.ID, nil
 }
 
 func (vs *VectorStore) SearchSimilar(query string, topK int) ([]SearchResult, error) {
@@ -122,21 +211,60 @@ func (vs *VectorStore) SearchSimilar(query string, topK int) ([]SearchResult, er
 	return results, nil
 }
 
+// GetDocument returns a copy of a document, which callers may read, e.g.
+// to encode it, while the store updates the document
 func (vs *VectorStore) GetDocument(docID string) (*Document, bool) {
 	vs.mutex.RLock()
 	defer vs.mutex.RUnlock()
 
 	doc, exists := vs.documents[docID]
-	return doc, exists
+	if !exists {
+		return nil, false
+	}
+	copied := *doc
+	return &copied, true
 }
 
-func (vs *VectorStore) DeleteDocument(docID string) bool {
+// RecordUsage adds the model usage of an analysis to a document's running
+// total. The total is replaced rather than changed, as copies handed out by
+// GetDocument share it.
+func (vs *VectorStore) RecordUsage(docID string, usage *UsageSummary) {
 	vs.mutex.Lock()
-	defer vs.mutex.Unlock()
+	doc, exists := vs.documents[docID]
+	if !exists {
+		vs.mutex.Unlock()
+		return
+	}
+	total := newUsageSummary()
+	total.Merge(doc.Usage)
+	total.Merge(usage)
+	doc.Usage = total
+	err := vs.storage.SetUsage(docID, total)
+	vs.mutex.Unlock()
+
+	if err == nil {
+		err = vs.storage.Sync()
+	}
+	if err != nil {
+		log.Printf("Warning: failed to store usage of document %s: %v", docID, err)
+	}
+}
 
+func (vs *VectorStore) DeleteDocument(docID string) bool {
+	vs.mutex.Lock()
 	_, exists := vs.documents[docID]
+	var err error
 	if exists {
 		delete(vs.documents, docID)
+		err = vs.storage.Delete(docID)
+	}
+	vs.mutex.Unlock()
+
+	if exists && err == nil {
+		err = vs.storage.Sync()
+	}
+	if err != nil {
+		log.Printf("Warning: failed to delete stored document %s: %v", docID, err)
 	}
 	return exists
 }
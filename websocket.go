@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -24,6 +25,12 @@ type Message struct {
 	Type    string `json:"type"`
 	Content string `json:"content"`
 	Error   string `json:"error,omitempty"`
+	// ErrorClass classifies a failed model call, e.g. "rate_limited"
+	ErrorClass string `json:"error_class,omitempty"`
+	// NoCache on a user message bypasses the LLM response cache; Cached on
+	// a reply marks an answer served from it
+	NoCache bool `json:"no_cache,omitempty"`
+	Cached  bool `json:"cached,omitempty"`
 }
 
 func NewWebSocketHandler(openAIService *OpenAIService) *WebSocketHandler {
@@ -42,6 +49,10 @@ func (h *WebSocketHandler) HandleWebSocket(c echo.Context) error {
 
 	log.Println("New WebSocket connection established")
 
+	// Replies still in flight are cancelled when the connection closes
+	connCtx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
 	// Send welcome message
 	welcomeMsg := Message{
 		Type:    "system",
@@ -67,7 +78,7 @@ func (h *WebSocketHandler) HandleWebSocket(c echo.Context) error {
 		// Process the message based on type
 		switch msg.Type {
 		case "user_message":
-			go h.handleUserMessage(ws, msg.Content)
+			go h.handleUserMessage(connCtx, ws, msg.Content, msg.NoCache)
 		case "ping":
 			pongMsg := Message{Type: "pong", Content: "pong"}
 			if err := ws.WriteJSON(pongMsg); err != nil {
@@ -89,7 +100,7 @@ func (h *WebSocketHandler) HandleWebSocket(c echo.Context) error {
 	return nil
 }
 
-func (h *WebSocketHandler) handleUserMessage(ws *websocket.Conn, userMessage string) {
+func (h *WebSocketHandler) handleUserMessage(connCtx context.Context, ws *websocket.Conn, userMessage string, noCache bool) {
 	// Send typing indicator
 	typingMsg := Message{
 		Type:    "typing",
@@ -101,11 +112,20 @@ func (h *WebSocketHandler) handleUserMessage(ws *websocket.Conn, userMessage str
 	}
 
 	// Get response from OpenAI
-	response, err := h.openAIService.GetChatResponse(userMessage)
+	ctx, cancel := withRequestDeadline(connCtx, ExtractorChat)
+	defer cancel()
+	ctx, cacheStats := WithLLMCache(ctx, noCache)
+	ctx, _ = WithUsageCollector(ctx, "/roadgpt")
+	response, err := h.openAIService.GetChatResponse(ctx, userMessage)
 	if err != nil {
+		if connCtx.Err() != nil {
+			// The client has gone; there is no one to reply to
+			return
+		}
 		errorMsg := Message{
-			Type:  "error",
-			Error: "Sorry, I'm having trouble processing your request. Please try again.",
+			Type:       "error",
+			Error:      "Sorry, I'm having trouble processing your request. Please try again.",
+			ErrorClass: ClassifyLLMError(err),
 		}
 		if err := ws.WriteJSON(errorMsg); err != nil {
 			log.Printf("Error sending error response: %v", err)
@@ -117,6 +137,7 @@ func (h *WebSocketHandler) handleUserMessage(ws *websocket.Conn, userMessage str
 	responseMsg := Message{
 		Type:    "ai_response",
 		Content: response,
+		Cached:  cacheStats.Hits > 0,
 	}
 	if err := ws.WriteJSON(responseMsg); err != nil {
 		log.Printf("Error sending AI response: %v", err)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Default number of chunks processed concurrently
+const defaultChunkWorkers = 4
+
+// chunkWorkers reads TENDERIQ_CHUNK_WORKERS, falling back to the default
+func chunkWorkers() int {
+	workers := getEnvInt("TENDERIQ_CHUNK_WORKERS", defaultChunkWorkers)
+	if workers < 1 {
+		return 1
+	}
+	return workers
+}
+
+// runOrdered runs work for indices 0..n-1 on up to workers goroutines and
+// hands each result to consume strictly in index order, so callers see the
+// same sequence as a plain loop. When consume returns false no further work
+// is dispatched, in-flight work is cancelled and later results are dropped.
+func runOrdered[T any](ctx context.Context, n, workers int, work func(ctx context.Context, i int) T, consume func(i int, result T) bool) {
+	if n == 0 {
+		return
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	results := make([]T, n)
+	done := make([]chan struct{}, n)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// Cancel before waiting so the dispatcher and in-flight work wind down
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Dispatcher: start work in index order, at most `workers` at a time
+	sem := make(chan struct{}, workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[i] = work(ctx, i)
+				close(done[i])
+			}(i)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-done[i]:
+		case <-ctx.Done():
+			return
+		}
+
+		if !consume(i, results[i]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Rows of a sheet per page; each page repeats the sheet's header row so a
+// chunk of a long bill of quantities still has its column names
+const xlsxRowsPerPage = 100
+
+// Excel's column limit; references beyond it are malformed
+const xlsxMaxColumns = 16384
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:",innerxml"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ExtractXLSX reads every sheet of an XLSX workbook as markdown tables, one
+// or more pages per sheet, with one outline entry per sheet. Values are the
+// stored ones: formulas give their last computed result and dates their
+// serial number.
+func ExtractXLSX(data []byte) ([]PageText, []OutlineEntry, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open XLSX file: %w", err)
+	}
+
+	var workbook xlsxWorkbook
+	if err := unmarshalZipPart(archive, "xl/workbook.xml", &workbook); err != nil {
+		return nil, nil, fmt.Errorf("failed to read XLSX workbook: %w", err)
+	}
+	var rels xlsxRelationships
+	if err := unmarshalZipPart(archive, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, nil, fmt.Errorf("failed to read XLSX workbook relationships: %w", err)
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	// Workbooks without any text cells have no shared strings part
+	var shared []string
+	if part, err := readZipPart(archive, "xl/sharedStrings.xml"); err == nil {
+		if shared, err = parseSharedStrings(part); err != nil {
+			return nil, nil, fmt.Errorf("failed to read XLSX shared strings: %w", err)
+		}
+	}
+
+	var pages []PageText
+	var outline []OutlineEntry
+	for _, sheet := range workbook.Sheets {
+		target, ok := targets[sheet.RID]
+		if !ok {
+			continue
+		}
+		var worksheet xlsxWorksheet
+		if err := unmarshalZipPart(archive, target, &worksheet); err != nil {
+			return nil, nil, fmt.Errorf("failed to read sheet %q: %w", sheet.Name, err)
+		}
+
+		rows := sheetRows(worksheet, shared)
+		if len(rows) == 0 {
+			continue
+		}
+		outline = append(outline, OutlineEntry{Title: sheet.Name, Level: 1, Page: len(pages) + 1})
+
+		header, body := rows[0], rows[1:]
+		for start := 0; start == 0 || start < len(body); start += xlsxRowsPerPage {
+			end := start + xlsxRowsPerPage
+			if end > len(body) {
+				end = len(body)
+			}
+			table := Table{Page: len(pages) + 1, Rows: append([][]TableCell{header}, body[start:end]...)}
+			label := fmt.Sprintf("[Sheet %s]", sheet.Name)
+			if len(body) > xlsxRowsPerPage {
+				label = fmt.Sprintf("[Sheet %s, rows %d-%d of %d]", sheet.Name, start+1, end, len(body))
+			}
+			text := label + "\n" + table.Markdown()
+			pages = append(pages, PageText{Number: len(pages) + 1, Text: text, Source: PageSourceText, Tables: []Table{table}})
+		}
+	}
+	return pages, outline, nil
+}
+
+// sheetRows lays the cells of a sheet out by their references, dropping
+// empty rows and columns
+func sheetRows(worksheet xlsxWorksheet, shared []string) [][]TableCell {
+	var rows [][]TableCell
+	for _, row := range worksheet.Rows {
+		var cells []TableCell
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				col = columnIndex(cell.Ref)
+			}
+			value := cellValue(cell.Type, cell.Value, cell.Inline.Text, shared)
+			if value == "" || col < 0 || col >= xlsxMaxColumns {
+				continue
+			}
+			for len(cells) <= col {
+				cells = append(cells, TableCell{})
+			}
+			cells[col].Text = value
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		rows = append(rows, cells)
+	}
+	rows = padRows(rows)
+
+	// Columns left empty for spacing are dropped
+	if len(rows) == 0 {
+		return rows
+	}
+	var used []int
+	for col := range rows[0] {
+		for _, row := range rows {
+			if row[col].Text != "" {
+				used = append(used, col)
+				break
+			}
+		}
+	}
+	for i, row := range rows {
+		kept := make([]TableCell, len(used))
+		for j, col := range used {
+			kept[j] = row[col]
+		}
+		rows[i] = kept
+	}
+	return rows
+}
+
+// cellValue resolves a cell's stored value by its type
+func cellValue(cellType, value, inline string, shared []string) string {
+	switch cellType {
+	case "s":
+		var i int
+		if _, err := fmt.Sscanf(value, "%d", &i); err == nil && i >= 0 && i < len(shared) {
+			return strings.TrimSpace(shared[i])
+		}
+		return ""
+	case "inlineStr":
+		return strings.TrimSpace(xmlText(inline))
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return strings.TrimSpace(value)
+	}
+}
+
+// columnIndex turns the letters of a cell reference such as "AB12" into a
+// zero-based column number
+func columnIndex(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
+
+// parseSharedStrings reads the workbook's string table. Rich text strings are
+// split into runs, which are joined; phonetic guides are left out.
+func parseSharedStrings(part []byte) ([]string, error) {
+	var strs []string
+	var current strings.Builder
+	inText, inPhonetic := false, false
+
+	decoder := xml.NewDecoder(bytes.NewReader(part))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				inPhonetic = true
+			}
+		case xml.CharData:
+			if inText && !inPhonetic {
+				current.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				strs = append(strs, current.String())
+			case "t":
+				inText = false
+			case "rPh":
+				inPhonetic = false
+			}
+		}
+	}
+	return strs, nil
+}
+
+// xmlText returns the character data of an XML fragment
+func xmlText(fragment string) string {
+	var text strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(fragment))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := token.(xml.CharData); ok {
+			text.Write(data)
+		}
+	}
+	return text.String()
+}
+
+// unmarshalZipPart decodes one XML file of an archive into v
+func unmarshalZipPart(archive *zip.Reader, name string, v interface{}) error {
+	part, err := readZipPart(archive, name)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(part, v)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testWorkbook = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+	`<sheet name="BOQ" sheetId="1" r:id="rId1"/><sheet name="Blank" sheetId="2" r:id="rId2"/><sheet name="Rates" sheetId="3" r:id="rId3"/>` +
+	`</sheets></workbook>`
+
+const testWorkbookRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="worksheets/sheet2.xml"/>` +
+	`<Relationship Id="rId3" Target="/xl/worksheets/sheet3.xml"/>` +
+	`</Relationships>`
+
+const testSharedStrings = `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<si><t>Item</t></si><si><t>Rate</t></si>` +
+	`<si><r><t>Pipes </t></r><r><t>DI K9</t></r><rPh><t>phonetic</t></rPh></si>` +
+	`</sst>`
+
+// testSheet wraps rows in a worksheet part
+func testSheet(rows string) string {
+	return `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+func TestExtractXLSX(t *testing.T) {
+	boq := testSheet(
+		`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>` +
+			`<row r="2"></row>` +
+			`<row r="3"><c r="A3" t="s"><v>2</v></c><c r="B3"><v></v></c><c r="C3"><v>450.5</v></c></row>` +
+			`<row r="4"><c r="A4" t="inlineStr"><is><t>Valves</t></is></c><c r="C4" t="b"><v>1</v></c></row>`)
+	var rateRows strings.Builder
+	rateRows.WriteString(`<row r="1"><c r="A1" t="inlineStr"><is><t>Code</t></is></c></row>`)
+	for i := 2; i <= xlsxRowsPerPage+6; i++ {
+		rateRows.WriteString(fmt.Sprintf(`<row r="%d"><c r="A%d"><v>%d</v></c></row>`, i, i, i))
+	}
+
+	data := buildZip(t,
+		zipEntry{"xl/workbook.xml", []byte(testWorkbook)},
+		zipEntry{"xl/_rels/workbook.xml.rels", []byte(testWorkbookRels)},
+		zipEntry{"xl/sharedStrings.xml", []byte(testSharedStrings)},
+		zipEntry{"xl/worksheets/sheet1.xml", []byte(boq)},
+		zipEntry{"xl/worksheets/sheet2.xml", []byte(testSheet(""))},
+		zipEntry{"xl/worksheets/sheet3.xml", []byte(testSheet(rateRows.String()))},
+	)
+	pages, outline, err := ExtractXLSX(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantOutline := []OutlineEntry{
+		{Title: "BOQ", Level: 1, Page: 1},
+		{Title: "Rates", Level: 1, Page: 2},
+	}
+	if !reflect.DeepEqual(outline, wantOutline) {
+		t.Errorf("outline = %+v, want %+v", outline, wantOutline)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want 3", len(pages))
+	}
+
+	wantBOQ := "[Sheet BOQ]\n| Item | Rate |\n| --- | --- |\n| Pipes DI K9 | 450.5 |\n| Valves | TRUE |\n"
+	if pages[0].Text != wantBOQ {
+		t.Errorf("BOQ page = %q, want %q", pages[0].Text, wantBOQ)
+	}
+
+	tests := []struct {
+		page   int
+		label  string
+		first  string
+		rows   int
+		tables int
+	}{
+		{1, "[Sheet Rates, rows 1-100 of 105]", "2", xlsxRowsPerPage + 1, 1},
+		{2, "[Sheet Rates, rows 101-105 of 105]", "102", 6, 1},
+	}
+	for _, tt := range tests {
+		page := pages[tt.page]
+		if !strings.HasPrefix(page.Text, tt.label+"\n") {
+			t.Errorf("page %d starts %q, want label %q", page.Number, strings.SplitN(page.Text, "\n", 2)[0], tt.label)
+		}
+		if len(page.Tables) != tt.tables {
+			t.Errorf("page %d has %d tables, want %d", page.Number, len(page.Tables), tt.tables)
+			continue
+		}
+		rows := page.Tables[0].Rows
+		if len(rows) != tt.rows || rows[0][0].Text != "Code" || rows[1][0].Text != tt.first {
+			t.Errorf("page %d has %d rows, header %q and first row %q; want %d rows, header \"Code\" and first row %q",
+				page.Number, len(rows), rows[0][0].Text, rows[1][0].Text, tt.rows, tt.first)
+		}
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1", 0},
+		{"C12", 2},
+		{"Z3", 25},
+		{"AA1", 26},
+		{"AB12", 27},
+		{"XFD1", xlsxMaxColumns - 1},
+		{"1", -1},
+	}
+	for _, tt := range tests {
+		if got := columnIndex(tt.ref); got != tt.want {
+			t.Errorf("columnIndex(%q) = %d, want %d", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestCellValue(t *testing.T) {
+	shared := []string{" Item ", "Rate"}
+	tests := []struct {
+		cellType string
+		value    string
+		inline   string
+		want     string
+	}{
+		{"s", "1", "", "Rate"},
+		{"s", "0", "", "Item"},
+		{"s", "7", "", ""},
+		{"s", "x", "", ""},
+		{"inlineStr", "", "<t>Valves</t>", "Valves"},
+		{"inlineStr", "", "<r><t>Rich </t></r><r><t>text</t></r>", "Rich text"},
+		{"b", "1", "", "TRUE"},
+		{"b", "0", "", "FALSE"},
+		{"", " 450.5 ", "", "450.5"},
+		{"str", "=SUM", "", "=SUM"},
+	}
+	for _, tt := range tests {
+		if got := cellValue(tt.cellType, tt.value, tt.inline, shared); got != tt.want {
+			t.Errorf("cellValue(%q, %q, %q) = %q, want %q", tt.cellType, tt.value, tt.inline, got, tt.want)
+		}
+	}
+}